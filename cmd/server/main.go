@@ -2,18 +2,22 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"log/slog"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
 
 	"github.com/beavercli/beaver_api/common/config"
 	"github.com/beavercli/beaver_api/common/database"
+	"github.com/beavercli/beaver_api/common/logger"
 	_ "github.com/beavercli/beaver_api/docs"
 	"github.com/beavercli/beaver_api/internal/integrations/github"
+	"github.com/beavercli/beaver_api/internal/integrations/gitlab"
+	"github.com/beavercli/beaver_api/internal/integrations/oidc"
 	"github.com/beavercli/beaver_api/internal/router"
 	"github.com/beavercli/beaver_api/internal/service"
 )
@@ -29,6 +33,12 @@ func main() {
 	ctx := context.Background()
 	cfg := config.New()
 
+	log, err := logger.New(cfg.Log, os.Stderr)
+	if err != nil {
+		panic(err)
+	}
+	slog.SetDefault(log)
+
 	pool, err := database.New(ctx, cfg.DB)
 	if err != nil {
 		panic(err)
@@ -36,27 +46,93 @@ func main() {
 	defer pool.Close()
 
 	ghCLient := github.New(cfg.OAuth.ClientID, 2*time.Second)
-	service := service.New(pool, service.Config{Secret: cfg.OAuth.Secret}, ghCLient)
-	server := router.New(cfg.Server, service)
+	ghCLient.Logger = log
+	providers := []service.LoginProvider{service.NewGithubProvider(ghCLient)}
+	if cfg.GitLab.ClientID != "" {
+		glClient := gitlab.New(cfg.GitLab.BaseURL, cfg.GitLab.ClientID, 5*time.Second)
+		providers = append(providers, service.NewGitlabProvider(glClient))
+	}
+	if cfg.OIDC.Issuer != "" {
+		oidcClient := oidc.New(cfg.OIDC.Issuer, cfg.OIDC.ClientID, cfg.OIDC.ClientSecret, 5*time.Second)
+		oidcClient.Scope = cfg.OIDC.Scope
+		providers = append(providers, service.NewOIDCProvider(cfg.OIDC.Name, oidcClient))
+	}
+	limitStore := service.NewMemoryRateLimitStore()
+	if cfg.RateLimit.Store == "postgres" {
+		limitStore = service.NewPostgresRateLimitStore(pool, cfg.RateLimit.LongestPeriod())
+	}
+	signingKeys, activeKeyID := toSigningKeys(cfg)
+	service := service.New(pool, service.Config{
+		SigningKeys:              signingKeys,
+		ActiveKeyID:              activeKeyID,
+		JWEKey:                   cfg.OAuth.JWEKey,
+		TrashRetention:           cfg.Snippets.TrashRetention,
+		TokenRotationGracePeriod: cfg.ServiceAccessTokens.RotationGracePeriod,
+		AdminEmails:              cfg.Users.AdminEmails,
+		Logger:                   log,
+	}, providers...)
+	server := router.New(cfg.Server, cfg.RateLimit, service, limitStore, log)
+
+	purgeCtx, stopPurge := context.WithCancel(ctx)
+	defer stopPurge()
+	go service.RunTrashPurger(purgeCtx, cfg.Snippets.TrashPurgeInterval)
+
+	usageCtx, stopUsage := context.WithCancel(ctx)
+	defer stopUsage()
+	usageDone := make(chan struct{})
+	go func() {
+		defer close(usageDone)
+		service.RunTokenUsageFlusher(usageCtx, cfg.ServiceAccessTokens.UsageFlushInterval)
+	}()
 
 	go func() {
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			fmt.Println(err)
-			panic(err)
+			log.Error("API server failed", "error", err)
+			os.Exit(1)
 		}
 	}()
 
-	fmt.Println("Starting API server")
+	log.Info("starting API server", "addr", cfg.Server.Addr)
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
+	stopPurge()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
-		panic(err)
+		log.Error("shutting down the API server failed", "error", err)
+	}
+
+	// the last flush stores the usage of the requests served until shutdown
+	stopUsage()
+	<-usageDone
+	log.Info("server stopped")
+}
+
+// toSigningKeys builds the JWT keyring. Without any JWT keys the OAuth
+// secret signs the tokens, as it did before the keyring existed.
+func toSigningKeys(cfg *config.Config) ([]service.SigningKey, string) {
+	if len(cfg.JWT.SigningKeys) == 0 && len(cfg.JWT.PrivateKeys) == 0 {
+		return []service.SigningKey{{ID: "default", Secret: cfg.OAuth.Secret}}, "default"
+	}
+
+	keys := make([]service.SigningKey, 0, len(cfg.JWT.SigningKeys)+len(cfg.JWT.PrivateKeys))
+	for _, k := range cfg.JWT.SigningKeys {
+		keys = append(keys, service.SigningKey{
+			ID:      k.ID,
+			Secret:  k.Secret,
+			Retired: slices.Contains(cfg.JWT.RetiredKeyIDs, k.ID),
+		})
+	}
+	for _, k := range cfg.JWT.PrivateKeys {
+		keys = append(keys, service.SigningKey{
+			ID:      k.ID,
+			Private: k.Key,
+			Retired: slices.Contains(cfg.JWT.RetiredKeyIDs, k.ID),
+		})
 	}
-	fmt.Println("Server stopped")
+	return keys, cfg.JWT.ActiveKeyID
 }
@@ -1,8 +1,21 @@
 package config
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v11"
@@ -18,7 +31,120 @@ type Database struct {
 }
 type OAuth struct {
 	ClientID string    `env:"CLIENT_ID,required"`
-	Secret   SecretKey `env:"SECRET,required"`
+	Secret   SecretKey `env:"SECRET,required"`  // signs JWTs while no JWT keys are set
+	JWEKey   SecretKey `env:"JWE_KEY,required"` // encrypts the device-flow state, must differ from every signing key
+}
+
+// validate checks the JWE key is not one of the JWT signing secrets, a key
+// is only ever used for one purpose.
+func (o OAuth) validate(j JWT) error {
+	signing := []SecretKey{}
+	if len(j.SigningKeys) == 0 && len(j.PrivateKeys) == 0 {
+		signing = append(signing, o.Secret)
+	}
+	for _, k := range j.SigningKeys {
+		signing = append(signing, k.Secret)
+	}
+	for _, k := range signing {
+		if bytes.Equal(k, o.JWEKey) {
+			return errors.New("the JWE key must not be a JWT signing key")
+		}
+	}
+	return nil
+}
+
+// GitLab configures sign in with gitlab.com or a self-hosted instance, enabled
+// when the client ID is set.
+type GitLab struct {
+	BaseURL  string `env:"GITLAB_BASE_URL" envDefault:"https://gitlab.com"`
+	ClientID string `env:"GITLAB_CLIENT_ID"`
+}
+
+// OIDC configures an OpenID Connect issuer to sign in with next to GitHub,
+// enabled when the issuer is set.
+type OIDC struct {
+	Name         string `env:"OIDC_NAME" envDefault:"oidc"` // provider segment of the /auth/{provider} routes
+	Issuer       string `env:"OIDC_ISSUER"`
+	ClientID     string `env:"OIDC_CLIENT_ID"`
+	ClientSecret string `env:"OIDC_CLIENT_SECRET"`
+	Scope        string `env:"OIDC_SCOPE" envDefault:"openid email profile"`
+}
+
+// JWT holds the keyring the API tokens are signed with. Tokens are signed with
+// the active key and verified with any key that is not retired, so a key is
+// rotated by adding a new active one and retiring the old one once the tokens
+// it signed have expired. HS256 secrets and ES256/EdDSA private keys can be
+// mixed, the public keys of the latter are published as a JWKS.
+type JWT struct {
+	SigningKeys   []SigningKey `env:"JWT_SIGNING_KEYS"` // comma separated kid:base64 entries
+	PrivateKeys   []PrivateKey `env:"JWT_PRIVATE_KEYS"` // comma separated kid:path entries of PEM files
+	ActiveKeyID   string       `env:"JWT_ACTIVE_KEY_ID"`
+	RetiredKeyIDs []string     `env:"JWT_RETIRED_KEY_IDS"`
+}
+
+type Snippets struct {
+	TrashRetention     time.Duration `env:"SNIPPETS_TRASH_RETENTION" envDefault:"720h"`
+	TrashPurgeInterval time.Duration `env:"SNIPPETS_TRASH_PURGE_INTERVAL" envDefault:"1h"`
+}
+
+// validate rejects durations the purger cannot run with, and a retention that
+// would purge snippets as soon as they are trashed.
+func (s Snippets) validate() error {
+	if s.TrashRetention <= 0 {
+		return errors.New("snippets trash retention must be positive")
+	}
+	if s.TrashPurgeInterval <= 0 {
+		return errors.New("snippets trash purge interval must be positive")
+	}
+	return nil
+}
+
+type ServiceAccessTokens struct {
+	UsageFlushInterval  time.Duration `env:"SERVICE_ACCESS_TOKENS_USAGE_FLUSH_INTERVAL" envDefault:"1m"`
+	RotationGracePeriod time.Duration `env:"SERVICE_ACCESS_TOKENS_ROTATION_GRACE_PERIOD" envDefault:"24h"`
+}
+
+func (s ServiceAccessTokens) validate() error {
+	if s.UsageFlushInterval <= 0 {
+		return errors.New("service access tokens usage flush interval must be positive")
+	}
+	return nil
+}
+
+// Users configures the site-wide roles. Users signing in with an admin email
+// are made admins, which bootstraps the first admin of a new deployment.
+type Users struct {
+	AdminEmails []string `env:"ADMIN_EMAILS"`
+}
+
+// RateLimit configures the request limits of the API, as a number of requests
+// per period such as 60/m. Authenticated requests are limited per user, or per
+// service access token for the tokens, the rest per client IP. A limit of 0
+// turns it off.
+type RateLimit struct {
+	Store      string `env:"RATE_LIMIT_STORE" envDefault:"memory"` // memory, or postgres to share the limits between instances
+	Read       Rate   `env:"RATE_LIMIT_READ" envDefault:"600/m"`
+	Write      Rate   `env:"RATE_LIMIT_WRITE" envDefault:"60/m"`
+	TokenRead  Rate   `env:"RATE_LIMIT_TOKEN_READ" envDefault:"1200/m"`
+	TokenWrite Rate   `env:"RATE_LIMIT_TOKEN_WRITE" envDefault:"120/m"`
+	Auth       Rate   `env:"RATE_LIMIT_AUTH" envDefault:"30/m"`
+	Public     Rate   `env:"RATE_LIMIT_PUBLIC" envDefault:"300/m"`
+}
+
+// LongestPeriod returns the longest period of the limits that are on.
+func (r RateLimit) LongestPeriod() time.Duration {
+	var longest time.Duration
+	for _, rate := range []Rate{r.Read, r.Write, r.TokenRead, r.TokenWrite, r.Auth, r.Public} {
+		if rate.Requests > 0 {
+			longest = max(longest, rate.Per)
+		}
+	}
+	return longest
+}
+
+type Log struct {
+	Format string     `env:"LOG_FORMAT" envDefault:"json"` // json or text
+	Level  slog.Level `env:"LOG_LEVEL" envDefault:"info"`
 }
 
 type Server struct {
@@ -29,9 +155,18 @@ type Server struct {
 type Config struct {
 	DebugMode bool `env:"DEBUG"`
 
-	OAuth  OAuth
-	Server Server
-	DB     Database
+	Log      Log
+	OAuth    OAuth
+	GitLab   GitLab
+	OIDC     OIDC
+	JWT      JWT
+	Server   Server
+	DB       Database
+	Snippets Snippets
+	Users    Users
+
+	RateLimit           RateLimit
+	ServiceAccessTokens ServiceAccessTokens
 }
 
 func New() *Config {
@@ -39,9 +174,56 @@ func New() *Config {
 	if err := env.Parse(&cfg); err != nil {
 		panic(err)
 	}
+	if err := cfg.JWT.validate(); err != nil {
+		panic(err)
+	}
+	if err := cfg.OAuth.validate(cfg.JWT); err != nil {
+		panic(err)
+	}
+	if s := cfg.RateLimit.Store; s != "memory" && s != "postgres" {
+		panic(fmt.Errorf("unknown rate limit store %q", s))
+	}
+	if err := cfg.Snippets.validate(); err != nil {
+		panic(err)
+	}
+	if err := cfg.ServiceAccessTokens.validate(); err != nil {
+		panic(err)
+	}
 	return &cfg
 }
 
+func (j JWT) validate() error {
+	if len(j.SigningKeys) == 0 && len(j.PrivateKeys) == 0 {
+		return nil
+	}
+
+	ids := map[string]bool{}
+	add := func(id string) error {
+		if ids[id] {
+			return fmt.Errorf("signing key %q is configured twice", id)
+		}
+		ids[id] = true
+		return nil
+	}
+	for _, k := range j.SigningKeys {
+		if err := add(k.ID); err != nil {
+			return err
+		}
+	}
+	for _, k := range j.PrivateKeys {
+		if err := add(k.ID); err != nil {
+			return err
+		}
+	}
+	if !ids[j.ActiveKeyID] {
+		return fmt.Errorf("active signing key %q is not configured", j.ActiveKeyID)
+	}
+	if slices.Contains(j.RetiredKeyIDs, j.ActiveKeyID) {
+		return fmt.Errorf("active signing key %q is retired", j.ActiveKeyID)
+	}
+	return nil
+}
+
 // SecretKey represents a 32-byte symmetric key decoded from base64.
 type SecretKey []byte
 
@@ -57,3 +239,109 @@ func (k *SecretKey) UnmarshalText(text []byte) error {
 	*k = decoded
 	return nil
 }
+
+// Rate is a number of requests allowed per period, which is also the most
+// that can be made at once.
+type Rate struct {
+	Requests int
+	Per      time.Duration
+}
+
+// UnmarshalText parses a requests/period entry, the period is s, m, h or a
+// duration such as 10s.
+func (r *Rate) UnmarshalText(text []byte) error {
+	if string(text) == "0" {
+		*r = Rate{}
+		return nil
+	}
+
+	n, period, ok := strings.Cut(string(text), "/")
+	if !ok {
+		return fmt.Errorf("rate %q must be a requests/period entry", text)
+	}
+	requests, err := strconv.Atoi(n)
+	if err != nil || requests < 0 {
+		return fmt.Errorf("rate %q: requests must be a positive integer", text)
+	}
+
+	switch period {
+	case "s", "m", "h":
+		period = "1" + period
+	}
+	per, err := time.ParseDuration(period)
+	if err != nil || per <= 0 {
+		return fmt.Errorf("rate %q: invalid period", text)
+	}
+
+	*r = Rate{Requests: requests, Per: per}
+	return nil
+}
+
+// SigningKey is a JWT signing key identified by the kid header of the tokens
+// it signs.
+type SigningKey struct {
+	ID     string
+	Secret SecretKey
+}
+
+// UnmarshalText parses a kid:base64 entry.
+func (k *SigningKey) UnmarshalText(text []byte) error {
+	id, secret, ok := strings.Cut(string(text), ":")
+	if !ok || id == "" {
+		return errors.New("signing key must be a kid:base64 entry")
+	}
+	if err := k.Secret.UnmarshalText([]byte(secret)); err != nil {
+		return fmt.Errorf("signing key %q: %w", id, err)
+	}
+	k.ID = id
+	return nil
+}
+
+// PrivateKey is an ECDSA P-256 or Ed25519 JWT signing key loaded from a PEM
+// file.
+type PrivateKey struct {
+	ID  string
+	Key crypto.Signer // *ecdsa.PrivateKey or ed25519.PrivateKey
+}
+
+// UnmarshalText parses a kid:path entry and loads the PEM file at path.
+func (k *PrivateKey) UnmarshalText(text []byte) error {
+	id, path, ok := strings.Cut(string(text), ":")
+	if !ok || id == "" || path == "" {
+		return errors.New("private key must be a kid:path entry")
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("private key %q: %w", id, err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return fmt.Errorf("private key %q: %s is not a PEM file", id, path)
+	}
+
+	var key any
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return fmt.Errorf("private key %q: %w", id, err)
+	}
+
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return fmt.Errorf("private key %q: only the P-256 curve is supported", id)
+		}
+		k.Key = key
+	case ed25519.PrivateKey:
+		k.Key = key
+	default:
+		return fmt.Errorf("private key %q: only ECDSA P-256 and Ed25519 keys are supported", id)
+	}
+	k.ID = id
+	return nil
+}
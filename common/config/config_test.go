@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -15,6 +16,8 @@ func TestConfig(t *testing.T) {
 	os.Setenv("DATABASE_URI", db_uri)
 	os.Setenv("SERVER_ADDR", srv_addr)
 	os.Setenv("DEBUG", "true")
+	os.Setenv("SECRET", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	os.Setenv("JWE_KEY", "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
 
 	cfg := New()
 
@@ -22,3 +25,63 @@ func TestConfig(t *testing.T) {
 	assert.Equal(t, srv_addr, cfg.Server.Addr)
 	assert.Equal(t, dbg, cfg.DebugMode)
 }
+
+func TestRateUnmarshalText(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Rate
+		wantErr bool
+	}{
+		{in: "60/m", want: Rate{Requests: 60, Per: time.Minute}},
+		{in: "5/s", want: Rate{Requests: 5, Per: time.Second}},
+		{in: "1000/h", want: Rate{Requests: 1000, Per: time.Hour}},
+		{in: "10/30s", want: Rate{Requests: 10, Per: 30 * time.Second}},
+		{in: "0", want: Rate{}},
+		{in: "60", wantErr: true},
+		{in: "-1/m", wantErr: true},
+		{in: "60/fortnight", wantErr: true},
+		{in: "60/0s", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var r Rate
+			err := r.UnmarshalText([]byte(tt.in))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, r)
+		})
+	}
+}
+
+func TestOAuthValidate(t *testing.T) {
+	secret := SecretKey("0123456789abcdef0123456789abcdef")
+	other := SecretKey("fedcba9876543210fedcba9876543210")
+
+	assert.NoError(t, OAuth{Secret: secret, JWEKey: other}.validate(JWT{}))
+	// the secret signs the JWTs while no JWT keys are set
+	assert.Error(t, OAuth{Secret: secret, JWEKey: secret}.validate(JWT{}))
+	assert.NoError(t, OAuth{Secret: secret, JWEKey: secret}.validate(JWT{
+		SigningKeys: []SigningKey{{ID: "k1", Secret: other}},
+	}))
+	assert.Error(t, OAuth{Secret: secret, JWEKey: other}.validate(JWT{
+		SigningKeys: []SigningKey{{ID: "k1", Secret: other}},
+	}))
+}
+
+func TestSnippetsValidate(t *testing.T) {
+	assert.NoError(t, Snippets{TrashRetention: time.Hour, TrashPurgeInterval: time.Minute}.validate())
+	assert.Error(t, Snippets{TrashRetention: 0, TrashPurgeInterval: time.Minute}.validate())
+	assert.Error(t, Snippets{TrashRetention: time.Hour, TrashPurgeInterval: -time.Minute}.validate())
+}
+
+func TestRateLimitLongestPeriod(t *testing.T) {
+	r := RateLimit{
+		Read:  Rate{Requests: 600, Per: time.Minute},
+		Write: Rate{Requests: 100, Per: 24 * time.Hour},
+		Auth:  Rate{Per: 48 * time.Hour}, // off
+	}
+	assert.Equal(t, 24*time.Hour, r.LongestPeriod())
+}
@@ -0,0 +1,93 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/beavercli/beaver_api/common/config"
+)
+
+// Redacted replaces the value of sensitive attributes.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values never reach the logs, next to
+// any key ending in _token, _hash or _secret.
+var sensitiveKeys = map[string]bool{
+	"token":         true,
+	"password":      true,
+	"secret":        true,
+	"authorization": true,
+	"cookie":        true,
+	"device_code":   true,
+}
+
+func New(cfg config.Log, w io.Writer) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{
+		Level:       cfg.Level,
+		ReplaceAttr: redact,
+	}
+
+	var h slog.Handler
+	switch cfg.Format {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if isSensitive(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	return a
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	return sensitiveKeys[key] ||
+		strings.HasSuffix(key, "_token") ||
+		strings.HasSuffix(key, "_hash") ||
+		strings.HasSuffix(key, "_secret")
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx whose log records carry id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request ctx belongs to, empty outside of
+// requests.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request ID of the context to the records, so the
+// logs of a request can be told apart from the rest.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
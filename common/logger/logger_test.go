@@ -0,0 +1,36 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/beavercli/beaver_api/common/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := New(config.Log{Format: "json", Level: slog.LevelInfo}, &buf)
+	require.NoError(t, err)
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	log.InfoContext(ctx, "token created",
+		"token_id", 7,
+		"token_hash", "abc",
+		slog.Group("github", "access_token", "gho_x"),
+	)
+
+	var rec map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rec))
+	assert.Equal(t, "req-1", rec["request_id"])
+	assert.Equal(t, float64(7), rec["token_id"])
+	assert.Equal(t, Redacted, rec["token_hash"])
+	assert.Equal(t, map[string]any{"access_token": Redacted}, rec["github"])
+
+	_, err = New(config.Log{Format: "xml"}, &buf)
+	assert.Error(t, err)
+}
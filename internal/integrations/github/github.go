@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
@@ -22,15 +23,41 @@ const (
 type Client struct {
 	Timeout  time.Duration
 	ClientID string
+	Logger   *slog.Logger
 }
 
 func New(clientID string, timeout time.Duration) *Client {
 	return &Client{
 		Timeout:  timeout,
 		ClientID: clientID,
+		Logger:   slog.Default(),
 	}
 }
 
+// do sends rq and logs the call, without the query or body where the codes
+// and tokens of the user are.
+func (c *Client) do(rq *http.Request) (*http.Response, error) {
+	start := time.Now()
+	rp, err := http.DefaultClient.Do(rq)
+	attrs := []any{
+		"method", rq.Method,
+		"url", rq.URL.Host + rq.URL.Path,
+		"duration", time.Since(start),
+	}
+	if err != nil {
+		c.Logger.WarnContext(rq.Context(), "github request failed", append(attrs, "error", err)...)
+		return nil, err
+	}
+
+	attrs = append(attrs, "status", rp.StatusCode)
+	if rp.StatusCode != http.StatusOK {
+		c.Logger.WarnContext(rq.Context(), "github request failed", attrs...)
+	} else {
+		c.Logger.DebugContext(rq.Context(), "github request", attrs...)
+	}
+	return rp, nil
+}
+
 type GithubDevicePayload struct {
 	UserCode        string `json:"user_code"`
 	DeviceCode      string `json:"device_code"`
@@ -54,7 +81,7 @@ func (c *Client) GetDeviceCode(ctx context.Context) (GithubDevicePayload, error)
 	rq.Header.Add("Accept", "application/json")
 	rq.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	rp, err := http.DefaultClient.Do(rq)
+	rp, err := c.do(rq)
 	if err != nil {
 		return GithubDevicePayload{}, err
 	}
@@ -99,7 +126,7 @@ func (c *Client) GetAccessToken(ctx context.Context, dc string) (GithubAccesToke
 	rq.Header.Add("Accept", "application/json")
 	rq.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	rp, err := http.DefaultClient.Do(rq)
+	rp, err := c.do(rq)
 	if err != nil {
 		return GithubAccesTokenPayload{}, err
 	}
@@ -117,6 +144,7 @@ func (c *Client) GetAccessToken(ctx context.Context, dc string) (GithubAccesToke
 }
 
 type GithubUserPayload struct {
+	ID    int64  `json:"id"`
 	Login string `json:"login"`
 	Name  string `json:"name"`
 }
@@ -132,7 +160,7 @@ func (c *Client) GetUser(ctx context.Context, t GithubAccesTokenPayload) (Github
 	rq.Header.Add("Accept", "application/vnd.github+json")
 	rq.Header.Add("Authorization", "Bearer "+t.AccessToken)
 
-	rp, err := http.DefaultClient.Do(rq)
+	rp, err := c.do(rq)
 	if err != nil {
 		return GithubUserPayload{}, err
 	}
@@ -167,7 +195,7 @@ func (c *Client) GetUserEmail(ctx context.Context, t GithubAccesTokenPayload) (G
 	rq.Header.Add("Accept", "application/vnd.github+json")
 	rq.Header.Add("Authorization", "Bearer "+t.AccessToken)
 
-	rp, err := http.DefaultClient.Do(rq)
+	rp, err := c.do(rq)
 	if err != nil {
 		return GithubUserEmailPayload{}, err
 	}
@@ -0,0 +1,173 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultBaseURL = "https://gitlab.com"
+	Scope          = "read_user"
+	GrantType      = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// Client signs users in with the device flow of gitlab.com or a self-hosted
+// GitLab instance at BaseURL.
+type Client struct {
+	BaseURL  string
+	Timeout  time.Duration
+	ClientID string
+}
+
+func New(baseURL, clientID string, timeout time.Duration) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return &Client{
+		BaseURL:  strings.TrimSuffix(baseURL, "/"),
+		Timeout:  timeout,
+		ClientID: clientID,
+	}
+}
+
+type GitlabDevicePayload struct {
+	UserCode                string `json:"user_code"`
+	DeviceCode              string `json:"device_code"`
+	VerificationURL         string `json:"verification_uri"`
+	VerificationURLComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+func (c *Client) GetDeviceCode(ctx context.Context) (GitlabDevicePayload, error) {
+	val := url.Values{
+		"client_id": {c.ClientID},
+		"scope":     {Scope},
+	}
+
+	var rb GitlabDevicePayload
+	rp, err := c.postForm(ctx, c.BaseURL+"/oauth/authorize_device", val, &rb)
+	if err != nil {
+		return GitlabDevicePayload{}, err
+	}
+	if rp.StatusCode != http.StatusOK {
+		return GitlabDevicePayload{}, fmt.Errorf("Request device code failed: %s", rp.Status)
+	}
+	return rb, nil
+}
+
+type GitlabAccessTokenPayload struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	Scope       string `json:"scope"`
+
+	// error payload, GitLab answers pending grants with a 400
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (c *Client) GetAccessToken(ctx context.Context, dc string) (GitlabAccessTokenPayload, error) {
+	val := url.Values{
+		"client_id":   {c.ClientID},
+		"grant_type":  {GrantType},
+		"device_code": {dc},
+	}
+
+	var rb GitlabAccessTokenPayload
+	rp, err := c.postForm(ctx, c.BaseURL+"/oauth/token", val, &rb)
+	if err != nil {
+		return GitlabAccessTokenPayload{}, err
+	}
+	if rp.StatusCode != http.StatusOK && rb.Error == "" {
+		return GitlabAccessTokenPayload{}, fmt.Errorf("Request access token failed: %s", rp.Status)
+	}
+	return rb, nil
+}
+
+type GitlabUserPayload struct {
+	ID          int64  `json:"id"`
+	Username    string `json:"username"`
+	Name        string `json:"name"`
+	Email       string `json:"email"`        // primary email, only returned for the authenticated user
+	ConfirmedAt string `json:"confirmed_at"` // empty while the primary email is not confirmed
+}
+
+func (c *Client) GetUser(ctx context.Context, t GitlabAccessTokenPayload) (GitlabUserPayload, error) {
+	var rb GitlabUserPayload
+	if err := c.getJSON(ctx, c.BaseURL+"/api/v4/user", t, &rb); err != nil {
+		return GitlabUserPayload{}, err
+	}
+	return rb, nil
+}
+
+type GitlabUserEmailPayload struct {
+	ID          int64  `json:"id"`
+	Email       string `json:"email"`
+	ConfirmedAt string `json:"confirmed_at"`
+}
+
+// GetUserEmail returns the first confirmed email of the user, or an empty
+// payload when there is none.
+func (c *Client) GetUserEmail(ctx context.Context, t GitlabAccessTokenPayload) (GitlabUserEmailPayload, error) {
+	var rb []GitlabUserEmailPayload
+	if err := c.getJSON(ctx, c.BaseURL+"/api/v4/user/emails", t, &rb); err != nil {
+		return GitlabUserEmailPayload{}, err
+	}
+	for _, e := range rb {
+		if e.ConfirmedAt != "" {
+			return e, nil
+		}
+	}
+	return GitlabUserEmailPayload{}, nil
+}
+
+func (c *Client) postForm(ctx context.Context, endpoint string, val url.Values, rb any) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	rq, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(val.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	rq.Header.Add("Accept", "application/json")
+	rq.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	rp, err := http.DefaultClient.Do(rq)
+	if err != nil {
+		return nil, err
+	}
+	defer rp.Body.Close()
+
+	if err := json.NewDecoder(rp.Body).Decode(rb); err != nil {
+		return nil, fmt.Errorf("Request to %s failed: %s", endpoint, rp.Status)
+	}
+	return rp, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, endpoint string, t GitlabAccessTokenPayload, rb any) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	rq, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	rq.Header.Add("Accept", "application/json")
+	rq.Header.Add("Authorization", "Bearer "+t.AccessToken)
+
+	rp, err := http.DefaultClient.Do(rq)
+	if err != nil {
+		return err
+	}
+	defer rp.Body.Close()
+
+	if rp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Request gitlab user failed: %s", rp.Status)
+	}
+	return json.NewDecoder(rp.Body).Decode(rb)
+}
@@ -0,0 +1,284 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+const (
+	DefaultScope = "openid email profile"
+	GrantType    = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// ErrAuthorizationPending is returned by GetToken until the user approves the
+// device code.
+var ErrAuthorizationPending = errors.New("authorization pending")
+
+// Client signs users in with any OpenID Connect issuer that supports the
+// device authorization grant, such as Keycloak or Dex.
+type Client struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // optional, for confidential clients
+	Scope        string
+	Timeout      time.Duration
+
+	mu        sync.Mutex
+	discovery *Discovery
+	keys      jose.JSONWebKeySet
+}
+
+func New(issuer, clientID, clientSecret string, timeout time.Duration) *Client {
+	return &Client{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scope:        DefaultScope,
+		Timeout:      timeout,
+	}
+}
+
+// Discovery is the part of the issuer's discovery document the client uses.
+type Discovery struct {
+	Issuer                      string `json:"issuer"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	JWKSURI                     string `json:"jwks_uri"`
+}
+
+// Discover fetches the discovery document of the issuer once and caches it.
+func (c *Client) Discover(ctx context.Context) (Discovery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.discovery != nil {
+		return *c.discovery, nil
+	}
+
+	var d Discovery
+	if err := c.getJSON(ctx, c.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return Discovery{}, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != c.Issuer {
+		return Discovery{}, fmt.Errorf("Discovery document is for issuer %s, expected %s", d.Issuer, c.Issuer)
+	}
+	if d.DeviceAuthorizationEndpoint == "" {
+		return Discovery{}, fmt.Errorf("Issuer %s does not support the device authorization grant", c.Issuer)
+	}
+	c.discovery = &d
+	return d, nil
+}
+
+type DevicePayload struct {
+	UserCode                string `json:"user_code"`
+	DeviceCode              string `json:"device_code"`
+	VerificationURL         string `json:"verification_uri"`
+	VerificationURLComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+func (c *Client) GetDeviceCode(ctx context.Context) (DevicePayload, error) {
+	d, err := c.Discover(ctx)
+	if err != nil {
+		return DevicePayload{}, err
+	}
+
+	val := c.clientValues()
+	val.Set("scope", c.Scope)
+
+	var rb DevicePayload
+	rp, err := c.postForm(ctx, d.DeviceAuthorizationEndpoint, val, &rb)
+	if err != nil {
+		return DevicePayload{}, err
+	}
+	if rp.StatusCode != http.StatusOK {
+		return DevicePayload{}, fmt.Errorf("Request device code failed: %s", rp.Status)
+	}
+	return rb, nil
+}
+
+type TokenPayload struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+
+	// error payload
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// GetToken exchanges an approved device code for tokens. It returns
+// ErrAuthorizationPending while the user has not approved it yet.
+func (c *Client) GetToken(ctx context.Context, deviceCode string) (TokenPayload, error) {
+	d, err := c.Discover(ctx)
+	if err != nil {
+		return TokenPayload{}, err
+	}
+
+	val := c.clientValues()
+	val.Set("grant_type", GrantType)
+	val.Set("device_code", deviceCode)
+
+	var rb TokenPayload
+	rp, err := c.postForm(ctx, d.TokenEndpoint, val, &rb)
+	if err != nil {
+		return TokenPayload{}, err
+	}
+
+	switch rb.Error {
+	case "":
+	case "authorization_pending", "slow_down":
+		return TokenPayload{}, ErrAuthorizationPending
+	default:
+		return TokenPayload{}, fmt.Errorf("Request token failed: %s %s", rb.Error, rb.ErrorDescription)
+	}
+	if rp.StatusCode != http.StatusOK {
+		return TokenPayload{}, fmt.Errorf("Request token failed: %s", rp.Status)
+	}
+	if rb.IDToken == "" {
+		return TokenPayload{}, fmt.Errorf("Issuer did not return an ID token, is the openid scope granted?")
+	}
+	return rb, nil
+}
+
+type IDTokenClaims struct {
+	jwt.Claims
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+}
+
+// VerifyIDToken checks the signature of an ID token against the keys of the
+// issuer, and that it was issued by the issuer for this client and is current.
+func (c *Client) VerifyIDToken(ctx context.Context, raw string) (IDTokenClaims, error) {
+	token, err := jwt.ParseSigned(raw, []jose.SignatureAlgorithm{jose.RS256, jose.PS256, jose.ES256, jose.EdDSA})
+	if err != nil {
+		return IDTokenClaims{}, err
+	}
+
+	key, err := c.verificationKey(ctx, token.Headers[0].KeyID)
+	if err != nil {
+		return IDTokenClaims{}, err
+	}
+
+	var claims IDTokenClaims
+	if err := token.Claims(key, &claims); err != nil {
+		return IDTokenClaims{}, err
+	}
+
+	// iss has to match the identifier of the discovery document exactly,
+	// including a trailing slash the configured issuer URL may be without
+	d, err := c.Discover(ctx)
+	if err != nil {
+		return IDTokenClaims{}, err
+	}
+	expected := jwt.Expected{
+		Issuer:      d.Issuer,
+		AnyAudience: jwt.Audience{c.ClientID},
+		Time:        time.Now(),
+	}
+	if err := claims.ValidateWithLeeway(expected, time.Minute); err != nil {
+		return IDTokenClaims{}, fmt.Errorf("ID token is not valid: %w", err)
+	}
+	if claims.Subject == "" {
+		return IDTokenClaims{}, fmt.Errorf("ID token has no subject")
+	}
+	return claims, nil
+}
+
+// verificationKey looks kid up in the cached keys of the issuer, refreshing
+// them once when it is unknown since the issuer may have rotated its keys.
+func (c *Client) verificationKey(ctx context.Context, kid string) (jose.JSONWebKey, error) {
+	c.mu.Lock()
+	keys := c.keys.Key(kid)
+	c.mu.Unlock()
+	if len(keys) > 0 {
+		return keys[0], nil
+	}
+
+	d, err := c.Discover(ctx)
+	if err != nil {
+		return jose.JSONWebKey{}, err
+	}
+	var set jose.JSONWebKeySet
+	if err := c.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return jose.JSONWebKey{}, err
+	}
+
+	c.mu.Lock()
+	c.keys = set
+	c.mu.Unlock()
+
+	keys = set.Key(kid)
+	if len(keys) == 0 {
+		return jose.JSONWebKey{}, fmt.Errorf("ID token is signed with the unknown key %q", kid)
+	}
+	return keys[0], nil
+}
+
+func (c *Client) clientValues() url.Values {
+	val := url.Values{"client_id": {c.ClientID}}
+	if c.ClientSecret != "" {
+		val.Set("client_secret", c.ClientSecret)
+	}
+	return val
+}
+
+// postForm posts val and decodes the JSON answer into rb, error answers
+// included since the token endpoint reports pending grants with a 400.
+func (c *Client) postForm(ctx context.Context, endpoint string, val url.Values, rb any) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	rq, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(val.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	rq.Header.Add("Accept", "application/json")
+	rq.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	rp, err := http.DefaultClient.Do(rq)
+	if err != nil {
+		return nil, err
+	}
+	defer rp.Body.Close()
+
+	if err := json.NewDecoder(rp.Body).Decode(rb); err != nil {
+		return nil, fmt.Errorf("Request to %s failed: %s", endpoint, rp.Status)
+	}
+	return rp, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, endpoint string, rb any) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	rq, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	rq.Header.Add("Accept", "application/json")
+
+	rp, err := http.DefaultClient.Do(rq)
+	if err != nil {
+		return err
+	}
+	defer rp.Body.Close()
+
+	if rp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Request to %s failed: %s", endpoint, rp.Status)
+	}
+	return json.NewDecoder(rp.Body).Decode(rb)
+}
@@ -0,0 +1,72 @@
+package oidc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/beavercli/beaver_api/internal/integrations/oidc"
+	"github.com/beavercli/beaver_api/internal/integrations/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceLogin(t *testing.T) {
+	srv := oidctest.NewServer("beaver")
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := oidc.New(srv.Issuer(), "beaver", "", time.Second)
+
+	dc, err := c.GetDeviceCode(ctx)
+	require.NoError(t, err)
+
+	_, err = c.GetToken(ctx, dc.DeviceCode)
+	assert.ErrorIs(t, err, oidc.ErrAuthorizationPending)
+
+	u := oidctest.User{Subject: "42", Email: "ada@example.com", EmailVerified: true, PreferredUsername: "ada"}
+	require.True(t, srv.Approve(dc.UserCode, u))
+
+	tp, err := c.GetToken(ctx, dc.DeviceCode)
+	require.NoError(t, err)
+
+	claims, err := c.VerifyIDToken(ctx, tp.IDToken)
+	require.NoError(t, err)
+	assert.Equal(t, "42", claims.Subject)
+	assert.Equal(t, "ada@example.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+	assert.Equal(t, "ada", claims.PreferredUsername)
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	srv := oidctest.NewServer("beaver")
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := oidc.New(srv.Issuer(), "beaver", "", time.Second)
+	u := oidctest.User{Subject: "42"}
+
+	_, err := c.VerifyIDToken(ctx, srv.IDToken(u, "another-client", time.Hour))
+	assert.Error(t, err)
+
+	_, err = c.VerifyIDToken(ctx, srv.IDToken(u, "beaver", -time.Hour))
+	assert.Error(t, err)
+
+	other := oidctest.NewServer("beaver")
+	defer other.Close()
+	_, err = c.VerifyIDToken(ctx, other.IDToken(u, "beaver", time.Hour))
+	assert.Error(t, err)
+}
+
+func TestVerifyIDTokenIssuerWithTrailingSlash(t *testing.T) {
+	srv := oidctest.NewServer("beaver")
+	srv.TrailingSlash = true
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := oidc.New(srv.Issuer(), "beaver", "", time.Second)
+
+	claims, err := c.VerifyIDToken(ctx, srv.IDToken(oidctest.User{Subject: "42"}, "beaver", time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, "42", claims.Subject)
+}
@@ -0,0 +1,212 @@
+// Package oidctest runs a local OpenID Connect issuer supporting the device
+// authorization grant, for tests that sign users in without a real provider.
+package oidctest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+const keyID = "oidctest"
+
+// User is the account the issuer signs in when a device code is approved.
+type User struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+// Server is a mock issuer. Device codes stay pending until Approve is called
+// with their user code.
+type Server struct {
+	*httptest.Server
+	ClientID string
+	// TrailingSlash ends the issuer identifier in the discovery document and
+	// the ID tokens with a slash, as some issuers such as Auth0 do.
+	TrailingSlash bool
+
+	key *ecdsa.PrivateKey
+
+	mu      sync.Mutex
+	devices map[string]*device // by device code
+}
+
+type device struct {
+	userCode string
+	user     *User
+}
+
+// NewServer starts an issuer that accepts clientID. Call Close when done.
+func NewServer(clientID string) *Server {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	s := &Server{
+		ClientID: clientID,
+		key:      key,
+		devices:  map[string]*device{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.handleDiscovery)
+	mux.HandleFunc("GET /jwks", s.handleJWKS)
+	mux.HandleFunc("POST /device", s.handleDevice)
+	mux.HandleFunc("POST /token", s.handleToken)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Issuer is the issuer URL to configure the client with.
+func (s *Server) Issuer() string {
+	return s.URL
+}
+
+// identifier is the issuer identifier of the discovery document and ID tokens.
+func (s *Server) identifier() string {
+	if s.TrailingSlash {
+		return s.URL + "/"
+	}
+	return s.URL
+}
+
+// Approve signs u in on the device that was given userCode. It reports
+// whether the user code is known.
+func (s *Server) Approve(userCode string, u User) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.devices {
+		if d.userCode == userCode {
+			d.user = &u
+			return true
+		}
+	}
+	return false
+}
+
+// IDToken signs an ID token for u with the given audience, for tests of the
+// token validation.
+func (s *Server) IDToken(u User, audience string, ttl time.Duration) string {
+	signerOpts := jose.SignerOptions{}
+	signerOpts.WithType("JWT")
+	signerOpts.WithHeader("kid", keyID)
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: s.key}, &signerOpts)
+	if err != nil {
+		panic(err)
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss":                s.identifier(),
+		"sub":                u.Subject,
+		"aud":                audience,
+		"iat":                jwt.NewNumericDate(now),
+		"exp":                jwt.NewNumericDate(now.Add(ttl)),
+		"email":              u.Email,
+		"email_verified":     u.EmailVerified,
+		"preferred_username": u.PreferredUsername,
+	}
+	token, err := jwt.Signed(signer).Claims(claims).Serialize()
+	if err != nil {
+		panic(err)
+	}
+	return token
+}
+
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                        s.identifier(),
+		"device_authorization_endpoint": s.URL + "/device",
+		"token_endpoint":                s.URL + "/token",
+		"jwks_uri":                      s.URL + "/jwks",
+	})
+}
+
+func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:       s.key.Public(),
+		KeyID:     keyID,
+		Algorithm: string(jose.ES256),
+		Use:       "sig",
+	}}})
+}
+
+func (s *Server) handleDevice(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("client_id") != s.ClientID {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	s.mu.Lock()
+	deviceCode, userCode := randomCode(), randomCode()[:8]
+	s.devices[deviceCode] = &device{userCode: userCode}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"device_code":      deviceCode,
+		"user_code":        userCode,
+		"verification_uri": s.URL + "/activate",
+		"expires_in":       600,
+		"interval":         1,
+	})
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("client_id") != s.ClientID {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	s.mu.Lock()
+	d, ok := s.devices[r.FormValue("device_code")]
+	var u *User
+	if ok {
+		u = d.user
+		if u != nil {
+			delete(s.devices, r.FormValue("device_code"))
+		}
+	}
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+	case u == nil:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "authorization_pending"})
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{
+			"access_token": randomCode(),
+			"id_token":     s.IDToken(*u, s.ClientID, time.Hour),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}
+}
+
+func randomCode() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -18,6 +18,16 @@ DELETE FROM tags WHERE NOT (id = ANY(sqlc.narg('ids')::BIGINT[]));
 -- name: CountTags :one
 SELECT COUNT(*) FROM tags;
 
+-- name: SearchTags :many
+SELECT id, name, word_similarity(sqlc.arg('query')::TEXT, name)::REAL AS similarity
+FROM tags
+WHERE sqlc.arg('query')::TEXT <% name
+ORDER BY similarity DESC, id
+OFFSET sqlc.arg('sql_offset')::INT LIMIT sqlc.arg('sql_limit')::INT;
+
+-- name: CountSearchTags :one
+SELECT COUNT(*) FROM tags WHERE sqlc.arg('query')::TEXT <% name;
+
 -- name: BulkUpsertTags :many
 WITH input AS (
     SELECT unnest(sqlc.arg('names')::text[]) AS name
@@ -72,6 +82,9 @@ DELETE FROM languages WHERE NOT (id = ANY(sqlc.narg('ids')::BIGINT[]));
 -- name: CountLanguages :one
 SELECT COUNT(*) FROM languages;
 
+-- name: GetLanguageByID :one
+SELECT * FROM languages WHERE id = $1;
+
 -- name: GetLanguageBySnippetID :one
 SELECT l.* FROM languages l
 INNER JOIN snippets s ON s.language_id = l.id
@@ -120,21 +133,115 @@ JOIN input i ON c.email = i.email;
 -- Snippets
 
 -- name: UpsertSnippet :one
-INSERT INTO snippets (title, code, project_url,  git_file_path, git_version, language_id, git_repo_id, user_id, created_at)
-VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
-ON CONFLICT (git_repo_id, git_file_path) DO UPDATE SET
+-- only the owner overwrites a snippet, and not while it is in the trash
+INSERT INTO snippets (title, code, project_url,  git_file_path, git_version, language_id, git_repo_id, user_id, created_at, visibility, organization_id)
+VALUES(
+    sqlc.arg('title'),
+    sqlc.arg('code'),
+    sqlc.arg('project_url'),
+    sqlc.arg('git_file_path'),
+    sqlc.arg('git_version'),
+    sqlc.arg('language_id'),
+    sqlc.arg('git_repo_id'),
+    sqlc.arg('user_id'),
+    sqlc.arg('created_at'),
+    COALESCE(sqlc.narg('visibility')::VARCHAR, 'team'),
+    sqlc.narg('organization_id')
+)
+ON CONFLICT (git_repo_id, git_file_path, organization_id) DO UPDATE SET
     code = EXCLUDED.code,
     project_url = EXCLUDED.project_url,
     git_repo_id = EXCLUDED.git_repo_id,
     git_file_path = EXCLUDED.git_file_path,
     git_version = EXCLUDED.git_version,
     language_id = EXCLUDED.language_id,
-    user_id = EXCLUDED.user_id,
-    created_at = EXCLUDED.created_at
+    created_at = EXCLUDED.created_at,
+    visibility = COALESCE(sqlc.narg('visibility')::VARCHAR, snippets.visibility)
+WHERE snippets.user_id = EXCLUDED.user_id AND snippets.deleted_at IS NULL
 RETURNING id;
 
--- name: GetSnippetIDByTitle :one
-SELECT id FROM snippets WHERE title=$1;
+-- name: GetSnippetIDByRepoPath :one
+SELECT id FROM snippets
+WHERE git_repo_id = $1 AND git_file_path = $2 AND organization_id IS NOT DISTINCT FROM $3;
+
+-- name: GetSnippetAuditState :one
+SELECT s.title, s.code, s.visibility, s.user_id, s.organization_id, s.deleted_at, l.name AS language_name
+FROM snippets s
+LEFT JOIN languages l ON s.language_id = l.id
+WHERE s.id = $1;
+
+-- name: GetSnippetOwnerForUpdate :one
+SELECT user_id, deleted_at FROM snippets WHERE id = $1 FOR UPDATE;
+
+-- name: UpdateSnippet :exec
+UPDATE snippets SET
+    title = COALESCE(sqlc.narg('title')::VARCHAR, title),
+    code = COALESCE(sqlc.narg('code')::TEXT, code),
+    visibility = COALESCE(sqlc.narg('visibility')::VARCHAR, visibility),
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg('id');
+
+-- name: SoftDeleteSnippet :exec
+UPDATE snippets SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1;
+
+-- name: RestoreSnippet :exec
+UPDATE snippets SET deleted_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1;
+
+-- name: ListTrashedSnippets :many
+SELECT
+    s.id,
+    s.title,
+    s.git_file_path,
+    s.git_version,
+    g.id AS git_repo_id,
+    g.url AS git_repo_url,
+    l.id AS language_id,
+    l.name AS language_name,
+    s.deleted_at
+FROM snippets s
+LEFT JOIN languages l ON s.language_id = l.id
+LEFT JOIN git_repos g ON s.git_repo_id = g.id
+WHERE s.user_id = $1 AND s.deleted_at IS NOT NULL
+ORDER BY s.deleted_at DESC
+OFFSET $2 LIMIT $3;
+
+-- name: CountTrashedSnippets :one
+SELECT COUNT(*) FROM snippets WHERE user_id = $1 AND deleted_at IS NOT NULL;
+
+-- name: ListSnippetsForTransfer :many
+-- locks the snippets of a deleted account while they are handed over
+SELECT
+    s.id,
+    s.organization_id,
+    s.visibility,
+    EXISTS (
+        SELECT 1 FROM organization_members m
+        WHERE m.organization_id = s.organization_id
+            AND m.user_id = sqlc.arg('to_user_id')::BIGINT
+    ) AS recipient_is_member
+FROM snippets s
+WHERE s.user_id = sqlc.arg('from_user_id')::BIGINT
+ORDER BY s.id
+FOR UPDATE OF s;
+
+-- name: TransferSnippets :execrows
+UPDATE snippets s SET
+    user_id = sqlc.arg('to_user_id')::BIGINT,
+    organization_id = NULLIF(t.organization_id, 0),
+    visibility = t.visibility,
+    updated_at = CURRENT_TIMESTAMP
+FROM unnest(
+    sqlc.arg('ids')::BIGINT[],
+    sqlc.arg('organization_ids')::BIGINT[],
+    sqlc.arg('visibilities')::VARCHAR[]
+) AS t(id, organization_id, visibility)
+WHERE s.id = t.id;
+
+-- name: DeleteSnippetsByUserID :execrows
+DELETE FROM snippets WHERE user_id = $1;
+
+-- name: PurgeDeletedSnippets :execrows
+DELETE FROM snippets WHERE deleted_at < $1;
 
 -- name: ListUsedLanguageIDs :many
 SELECT DISTINCT(language_id) FROM snippets;
@@ -175,6 +282,7 @@ WHERE snippet_id = sqlc.arg('snippet_id')::bigint
 SELECT DISTINCT(contributor_id) FROM snippet_contributors;
 
 -- name: GetSnippetByID :one
+-- any_visibility skips the visibility filter, for moderators.
 SELECT
     s.id,
     s.title,
@@ -184,6 +292,8 @@ SELECT
     s.git_version,
     s.created_at,
     s.updated_at,
+    s.visibility,
+    s.organization_id,
     g.id AS git_repo_id,
     g.url AS git_repo_url,
     l.id AS language_id,
@@ -191,7 +301,17 @@ SELECT
 FROM snippets s
 LEFT JOIN languages l ON s.language_id = l.id
 LEFT JOIN git_repos g ON s.git_repo_id = g.id
-WHERE s.id = $1;
+WHERE s.id = sqlc.arg('id') AND s.deleted_at IS NULL
+  AND (sqlc.arg('any_visibility')::BOOLEAN
+    OR s.visibility = 'public'
+    OR (sqlc.narg('viewer_id')::BIGINT IS NOT NULL
+      AND (s.user_id = sqlc.narg('viewer_id')::BIGINT
+        OR (s.visibility = 'team'
+          AND (s.organization_id IS NULL OR EXISTS (
+            SELECT 1
+            FROM organization_members m
+            WHERE m.organization_id = s.organization_id
+              AND m.user_id = sqlc.narg('viewer_id')::BIGINT))))));
 
 -- name: GetTagsBySnippetID :many
 SELECT t.id, t.name
@@ -218,11 +338,33 @@ SELECT
     g.id AS git_repo_id,
     g.url AS git_repo_url,
     l.id AS language_id,
-    l.name AS language_name
+    l.name AS language_name,
+    s.visibility,
+    s.organization_id,
+    COALESCE(CASE WHEN sqlc.arg('fuzzy')::BOOLEAN
+        THEN word_similarity(sqlc.narg('query')::TEXT, s.title)
+        ELSE ts_rank(s.search_vector, websearch_to_tsquery('simple', sqlc.narg('query')::TEXT))
+    END, 0)::REAL AS rank,
+    COALESCE(word_similarity(sqlc.narg('query')::TEXT, s.title), 0)::REAL AS similarity,
+    (CASE WHEN sqlc.arg('fuzzy')::BOOLEAN THEN ''
+        ELSE COALESCE(ts_headline('simple', s.code, websearch_to_tsquery('simple', sqlc.narg('query')::TEXT),
+            'StartSel=<mark>, StopSel=</mark>, MaxFragments=1, MaxWords=24, MinWords=8'), '')
+    END)::TEXT AS headline
 FROM snippets s
 LEFT JOIN languages l ON s.language_id = l.id
 LEFT JOIN git_repos g ON s.git_repo_id = g.id
-WHERE (sqlc.narg('language_id')::BIGINT IS NULL OR s.language_id = sqlc.narg('language_id')::BIGINT)
+WHERE s.deleted_at IS NULL
+  AND (s.visibility = 'public'
+    OR (sqlc.narg('viewer_id')::BIGINT IS NOT NULL
+      AND (s.user_id = sqlc.narg('viewer_id')::BIGINT
+        OR (s.visibility = 'team'
+          AND (s.organization_id IS NULL OR EXISTS (
+            SELECT 1
+            FROM organization_members m
+            WHERE m.organization_id = s.organization_id
+              AND m.user_id = sqlc.narg('viewer_id')::BIGINT))))))
+  AND (sqlc.narg('organization_id')::BIGINT IS NULL OR s.organization_id = sqlc.narg('organization_id')::BIGINT)
+  AND (sqlc.narg('language_id')::BIGINT IS NULL OR s.language_id = sqlc.narg('language_id')::BIGINT)
   AND NOT EXISTS (
     SELECT 1
     FROM (SELECT unnest(sqlc.narg('tag_ids')::BIGINT[]) AS tag_id) ft
@@ -233,12 +375,26 @@ WHERE (sqlc.narg('language_id')::BIGINT IS NULL OR s.language_id = sqlc.narg('la
         AND st.tag_id = ft.tag_id
     )
   )
-ORDER BY s.id
+  AND (sqlc.narg('query')::TEXT IS NULL
+    OR (sqlc.arg('fuzzy')::BOOLEAN AND sqlc.narg('query')::TEXT <% s.title)
+    OR (NOT sqlc.arg('fuzzy')::BOOLEAN AND s.search_vector @@ websearch_to_tsquery('simple', sqlc.narg('query')::TEXT)))
+ORDER BY rank DESC, s.id
 OFFSET sqlc.arg('sql_offset')::INT LIMIT sqlc.arg('sql_limit')::INT;
 
 -- name: CountSnippetsFiltered :one
 SELECT COUNT(*) FROM snippets s
-WHERE (sqlc.narg('language_id')::BIGINT IS NULL OR s.language_id = sqlc.narg('language_id')::BIGINT)
+WHERE s.deleted_at IS NULL
+  AND (s.visibility = 'public'
+    OR (sqlc.narg('viewer_id')::BIGINT IS NOT NULL
+      AND (s.user_id = sqlc.narg('viewer_id')::BIGINT
+        OR (s.visibility = 'team'
+          AND (s.organization_id IS NULL OR EXISTS (
+            SELECT 1
+            FROM organization_members m
+            WHERE m.organization_id = s.organization_id
+              AND m.user_id = sqlc.narg('viewer_id')::BIGINT))))))
+  AND (sqlc.narg('organization_id')::BIGINT IS NULL OR s.organization_id = sqlc.narg('organization_id')::BIGINT)
+  AND (sqlc.narg('language_id')::BIGINT IS NULL OR s.language_id = sqlc.narg('language_id')::BIGINT)
   AND NOT EXISTS (
     SELECT 1
     FROM (SELECT unnest(sqlc.narg('tag_ids')::BIGINT[]) AS tag_id) ft
@@ -248,7 +404,10 @@ WHERE (sqlc.narg('language_id')::BIGINT IS NULL OR s.language_id = sqlc.narg('la
       WHERE st.snippet_id = s.id
         AND st.tag_id = ft.tag_id
     )
-  );
+  )
+  AND (sqlc.narg('query')::TEXT IS NULL
+    OR (sqlc.arg('fuzzy')::BOOLEAN AND sqlc.narg('query')::TEXT <% s.title)
+    OR (NOT sqlc.arg('fuzzy')::BOOLEAN AND s.search_vector @@ websearch_to_tsquery('simple', sqlc.narg('query')::TEXT)));
 
 -- name: GetTagsBySnippetIDs :many
 SELECT st.snippet_id, t.id, t.name
@@ -256,13 +415,62 @@ FROM tags t
 INNER JOIN snippet_tags st ON t.id = st.tag_id
 WHERE st.snippet_id = ANY(sqlc.arg('snippet_ids')::BIGINT[]);
 
+-- Snippet revisions
+
+-- name: CreateSnippetRevision :exec
+-- Snapshots the current state of a snippet as its next revision. Nothing is
+-- written when the latest revision already matches the snippet.
+INSERT INTO snippet_revisions (revision, title, code, project_url, git_file_path, git_version, snippet_id, language_id, user_id)
+SELECT
+    COALESCE((SELECT MAX(r.revision) FROM snippet_revisions r WHERE r.snippet_id = s.id), 0) + 1,
+    s.title, s.code, s.project_url, s.git_file_path, s.git_version, s.id, s.language_id, s.user_id
+FROM snippets s
+WHERE s.id = $1
+  AND NOT EXISTS (
+    SELECT 1
+    FROM snippet_revisions r
+    WHERE r.snippet_id = s.id
+      AND r.revision = (SELECT MAX(revision) FROM snippet_revisions WHERE snippet_id = s.id)
+      AND r.title IS NOT DISTINCT FROM s.title
+      AND r.code IS NOT DISTINCT FROM s.code
+      AND r.git_version IS NOT DISTINCT FROM s.git_version
+      AND r.language_id IS NOT DISTINCT FROM s.language_id
+  );
+
+-- name: ListSnippetRevisions :many
+SELECT id, revision, git_version, user_id, created_at
+FROM snippet_revisions
+WHERE snippet_id = $1
+ORDER BY revision DESC
+OFFSET $2 LIMIT $3;
+
+-- name: CountSnippetRevisions :one
+SELECT COUNT(*) FROM snippet_revisions WHERE snippet_id = $1;
+
+-- name: GetSnippetRevision :one
+SELECT
+    r.revision,
+    r.title,
+    r.code,
+    r.project_url,
+    r.git_file_path,
+    r.git_version,
+    r.user_id,
+    r.created_at,
+    l.id AS language_id,
+    l.name AS language_name
+FROM snippet_revisions r
+LEFT JOIN languages l ON r.language_id = l.id
+WHERE r.snippet_id = $1 AND r.revision = $2;
+
 -- Users
 
 -- name: UpsertUser :one
+-- no row when the username is taken by a user of another email
 WITH ins AS (
     INSERT INTO users (username, email, password_hash)
     VALUES ($1, $2, $3)
-    ON CONFLICT (email) DO NOTHING
+    ON CONFLICT DO NOTHING
     RETURNING id
 )
 SELECT id FROM ins
@@ -272,9 +480,25 @@ SELECT id FROM users WHERE email = $2;
 -- name: GetUserIDByEmail :one
 SELECT id FROM users WHERE email = $1;
 
+-- name: GetUserIDByUsername :one
+SELECT id FROM users WHERE username = $1;
+
 -- name: GetUserByID :one
 SELECT * FROM users WHERE id = $1;
 
+-- name: GetUserByIDForUpdate :one
+SELECT * FROM users WHERE id = $1 FOR UPDATE;
+
+-- name: DeleteUser :exec
+DELETE FROM users WHERE id = $1;
+
+-- name: UpdateUserProfile :exec
+UPDATE users SET
+    display_name = $2,
+    default_language_id = $3,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1;
+
 -- name: ListAllTags :many
 SELECT * FROM tags;
 
@@ -287,11 +511,73 @@ SELECT * FROM contributors;
 -- name: ListAllUsers :many
 SELECT * FROM users;
 
+-- name: GetUserAccess :one
+SELECT role, disabled_at FROM users WHERE id = $1;
+
+-- name: ListUsers :many
+SELECT * FROM users
+ORDER BY id
+OFFSET $1 LIMIT $2;
+
+-- name: CountUsers :one
+SELECT COUNT(*) FROM users;
+
+-- name: UpdateUserRole :execrows
+UPDATE users SET role = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1;
+
+-- name: LockActiveAdmins :many
+-- locks the active admins, so concurrent demotions and disables of admins are
+-- checked one after the other
+SELECT id FROM users WHERE role = 'admin' AND disabled_at IS NULL FOR UPDATE;
+
+-- name: SetUserDisabledAt :execrows
+UPDATE users SET disabled_at = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1;
+
+-- name: DeleteSessionsByUserID :execrows
+DELETE FROM sessions WHERE user_id = $1;
+
+-- name: ListOrphanedSnippets :many
+SELECT id, title, organization_id, created_at FROM snippets
+WHERE user_id IS NULL AND deleted_at IS NULL
+ORDER BY id
+OFFSET $1 LIMIT $2;
+
+-- name: CountOrphanedSnippets :one
+SELECT COUNT(*) FROM snippets WHERE user_id IS NULL AND deleted_at IS NULL;
+
+-- name: TakeOverSnippet :execrows
+UPDATE snippets SET user_id = $2, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND user_id IS NULL AND deleted_at IS NULL;
+
+-- User identities
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities WHERE provider = $1 AND provider_user_id = $2;
+
+-- name: CreateUserIdentity :one
+INSERT INTO user_identities (user_id, provider, provider_user_id, username, email)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: UpdateUserIdentity :exec
+UPDATE user_identities
+SET username = $2, email = $3, updated_at = now()
+WHERE id = $1;
+
+-- name: ListUserIdentities :many
+SELECT * FROM user_identities WHERE user_id = $1 ORDER BY provider;
+
+-- name: CountUserIdentities :one
+SELECT COUNT(*) FROM user_identities WHERE user_id = $1;
+
+-- name: DeleteUserIdentity :execrows
+DELETE FROM user_identities WHERE user_id = $1 AND provider = $2;
+
 -- Refresh tokens
 
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token_hash, issued_at, expires_at, user_id)
-VALUES ($1, $2, $3, $4)
+INSERT INTO refresh_tokens (token_hash, issued_at, expires_at, user_id, session_id)
+VALUES ($1, $2, $3, $4, $5)
 RETURNING *;
 
 -- name: DeleteRefreshTokenByID :exec
@@ -303,26 +589,256 @@ DELETE FROM refresh_tokens WHERE user_id = $1;
 -- name: GetRefreshTokenByHash :one
 SELECT * FROM refresh_tokens WHERE token_hash = $1;
 
+-- name: MarkRefreshTokenRotated :execrows
+UPDATE refresh_tokens
+SET rotated_at = now(), updated_at = now()
+WHERE id = $1 AND rotated_at IS NULL;
+
+-- name: RevokeSessionByID :exec
+DELETE FROM sessions WHERE id = $1;
+
+-- Sessions
+
+-- name: CreateSession :one
+INSERT INTO sessions (user_id, device_name, user_agent, ip_address)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: GetSession :one
+SELECT * FROM sessions WHERE id = $1 AND user_id = $2;
+
+-- name: TouchSession :exec
+UPDATE sessions SET
+    last_used_at = CURRENT_TIMESTAMP,
+    user_agent = COALESCE(sqlc.narg('user_agent')::VARCHAR, user_agent),
+    ip_address = COALESCE(sqlc.narg('ip_address')::VARCHAR, ip_address)
+WHERE id = sqlc.arg('id');
+
+-- name: ListActiveSessions :many
+SELECT *
+FROM sessions
+WHERE user_id = $1 AND last_used_at > $2
+ORDER BY last_used_at DESC
+OFFSET $3 LIMIT $4;
+
+-- name: CountActiveSessions :one
+SELECT COUNT(*) FROM sessions WHERE user_id = $1 AND last_used_at > $2;
+
+-- name: DeleteSession :execrows
+DELETE FROM sessions WHERE id = $1 AND user_id = $2;
+
 -- Service access tokens
 
 -- name: CreateServiceAccessToken :one
-INSERT INTO service_access_tokens (token_hash, name, issued_at, expires_at, user_id)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO service_access_tokens (token_hash, name, issued_at, expires_at, user_id, organization_id, scopes)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
 RETURNING *;
 
--- name: DeleteServiceAccessTokenByID :exec
-DELETE FROM service_access_tokens WHERE id = $1;
+-- name: GetServiceAccessTokenByID :one
+SELECT * FROM service_access_tokens WHERE id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeServiceAccessToken :execrows
+UPDATE service_access_tokens
+SET revoked_at = now(), revoked_by = $2, updated_at = now()
+WHERE id = $1 AND revoked_at IS NULL;
+
+-- name: DeleteServiceAccessTokensByUserID :exec
+DELETE FROM service_access_tokens WHERE user_id = $1;
 
 -- name: ListServiceAccessTokensByUserID :many
 SELECT *
 FROM service_access_tokens
-WHERE user_id = $1
+WHERE user_id = $1 AND revoked_at IS NULL
 ORDER BY created_at DESC;
 
 -- name: CountServiceAccessTokensByUserID :one
 SELECT COUNT(*)
 FROM service_access_tokens
-WHERE user_id = $1;
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: ListServiceAccessTokensByOrganizationID :many
+SELECT *
+FROM service_access_tokens
+WHERE organization_id = $1 AND revoked_at IS NULL
+ORDER BY created_at DESC
+OFFSET $2 LIMIT $3;
+
+-- name: CountServiceAccessTokensByOrganizationID :one
+SELECT COUNT(*)
+FROM service_access_tokens
+WHERE organization_id = $1 AND revoked_at IS NULL;
 
 -- name: GetServiceAccessTokenByHash :one
-SELECT * FROM service_access_tokens WHERE token_hash = $1;
+SELECT *
+FROM service_access_tokens
+WHERE token_hash = $1
+   OR (previous_token_hash = $1 AND previous_token_expires_at > now());
+
+-- name: RotateServiceAccessToken :one
+UPDATE service_access_tokens
+SET previous_token_hash = token_hash,
+    previous_token_expires_at = $3,
+    token_hash = $2,
+    issued_at = now(),
+    updated_at = now()
+WHERE id = $1 AND revoked_at IS NULL
+RETURNING *;
+
+-- name: RecordServiceAccessTokenUsage :exec
+UPDATE service_access_tokens t
+SET usage_count = t.usage_count + u.uses,
+    last_used_ip = CASE
+        WHEN t.last_used_at IS NULL OR u.last_used_at >= t.last_used_at THEN NULLIF(u.last_used_ip, '')
+        ELSE t.last_used_ip
+    END,
+    last_used_at = GREATEST(t.last_used_at, u.last_used_at)
+FROM unnest(
+    sqlc.arg('ids')::BIGINT[],
+    sqlc.arg('uses')::BIGINT[],
+    sqlc.arg('last_used_at')::TIMESTAMPTZ[],
+    sqlc.arg('last_used_ip')::VARCHAR[]
+) AS u(id, uses, last_used_at, last_used_ip)
+WHERE t.id = u.id;
+
+-- name: ListStaleServiceAccessTokens :many
+SELECT *
+FROM service_access_tokens
+WHERE user_id = sqlc.arg('user_id')
+  AND revoked_at IS NULL
+  AND (
+    COALESCE(last_used_at, issued_at) < sqlc.narg('unused_since')::TIMESTAMPTZ
+    OR expires_at < sqlc.narg('expires_before')::TIMESTAMPTZ
+  )
+ORDER BY expires_at ASC, id ASC
+OFFSET sqlc.arg('offset') LIMIT sqlc.arg('limit');
+
+-- name: CountStaleServiceAccessTokens :one
+SELECT COUNT(*)
+FROM service_access_tokens
+WHERE user_id = sqlc.arg('user_id')
+  AND revoked_at IS NULL
+  AND (
+    COALESCE(last_used_at, issued_at) < sqlc.narg('unused_since')::TIMESTAMPTZ
+    OR expires_at < sqlc.narg('expires_before')::TIMESTAMPTZ
+  );
+
+-- Organizations
+
+-- name: CreateOrganization :one
+INSERT INTO organizations (name) VALUES ($1) RETURNING *;
+
+-- name: GetOrganizationByID :one
+SELECT * FROM organizations WHERE id = $1;
+
+-- name: UpdateOrganization :one
+UPDATE organizations SET
+    name = sqlc.arg('name'),
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- name: DeleteOrganization :exec
+DELETE FROM organizations WHERE id = $1;
+
+-- Team snippets of a deleted organization must not become readable by everyone.
+-- name: MakeOrganizationSnippetsPrivate :exec
+UPDATE snippets SET visibility = 'private', updated_at = CURRENT_TIMESTAMP
+WHERE organization_id = $1 AND visibility = 'team';
+
+-- name: ListOrganizationsByUserID :many
+SELECT o.id, o.name, o.created_at, m.role
+FROM organizations o
+INNER JOIN organization_members m ON m.organization_id = o.id
+WHERE m.user_id = $1
+ORDER BY o.name
+OFFSET $2 LIMIT $3;
+
+-- name: CountOrganizationsByUserID :one
+SELECT COUNT(*) FROM organization_members WHERE user_id = $1;
+
+-- name: AddOrganizationMember :execrows
+INSERT INTO organization_members (organization_id, user_id, role)
+VALUES ($1, $2, $3)
+ON CONFLICT (organization_id, user_id) DO NOTHING;
+
+-- name: UpdateOrganizationMemberRole :execrows
+UPDATE organization_members SET role = $3
+WHERE organization_id = $1 AND user_id = $2;
+
+-- name: RemoveOrganizationMember :execrows
+DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2;
+
+-- name: GetOrganizationMemberRole :one
+SELECT role FROM organization_members WHERE organization_id = $1 AND user_id = $2;
+
+-- name: LockOrganizationOwners :many
+-- locks the owner memberships, so concurrent demotions and removals of owners
+-- are checked one after the other
+SELECT user_id FROM organization_members WHERE organization_id = $1 AND role = 'owner' FOR UPDATE;
+
+-- name: ListOrganizationMembers :many
+SELECT u.id, u.username, u.email, m.role, m.created_at
+FROM organization_members m
+INNER JOIN users u ON u.id = m.user_id
+WHERE m.organization_id = $1
+ORDER BY m.created_at, u.id
+OFFSET $2 LIMIT $3;
+
+-- name: CountOrganizationMembers :one
+SELECT COUNT(*) FROM organization_members WHERE organization_id = $1;
+
+-- name: GetOtherOrganizationOwner :one
+SELECT user_id
+FROM organization_members
+WHERE organization_id = $1 AND role = 'owner' AND user_id <> $2
+ORDER BY created_at, user_id
+LIMIT 1;
+
+-- Organizations that would be left without an owner if the user went away.
+-- name: ListSoleOwnedOrganizations :many
+SELECT o.id, o.name
+FROM organizations o
+INNER JOIN organization_members m ON m.organization_id = o.id
+WHERE m.user_id = $1 AND m.role = 'owner'
+  AND NOT EXISTS (
+    SELECT 1
+    FROM organization_members other
+    WHERE other.organization_id = o.id
+      AND other.role = 'owner'
+      AND other.user_id <> $1
+  )
+ORDER BY o.name;
+
+-- Audit events
+
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (actor_id, action, target_type, target_id, data, token_id, session_id, ip_address, before_data, after_data)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+
+-- name: ListAuditEvents :many
+SELECT * FROM audit_events
+WHERE (sqlc.narg('before_id')::BIGINT IS NULL OR id < sqlc.narg('before_id')::BIGINT)
+  AND (sqlc.narg('actor_id')::BIGINT IS NULL OR actor_id = sqlc.narg('actor_id')::BIGINT)
+  AND (sqlc.narg('action')::VARCHAR IS NULL OR action = sqlc.narg('action')::VARCHAR)
+  AND (sqlc.narg('target_type')::VARCHAR IS NULL OR target_type = sqlc.narg('target_type')::VARCHAR)
+  AND (sqlc.narg('target_id')::BIGINT IS NULL OR target_id = sqlc.narg('target_id')::BIGINT)
+  AND (sqlc.narg('token_id')::BIGINT IS NULL OR token_id = sqlc.narg('token_id')::BIGINT)
+  AND (sqlc.narg('since')::TIMESTAMPTZ IS NULL OR created_at >= sqlc.narg('since')::TIMESTAMPTZ)
+  AND (sqlc.narg('until')::TIMESTAMPTZ IS NULL OR created_at < sqlc.narg('until')::TIMESTAMPTZ)
+ORDER BY id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: TakeRateLimitToken :one
+-- refills the bucket for the time since the last request and takes a token
+-- if one is available, in a single statement so instances do not race
+INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+VALUES (sqlc.arg('key'), sqlc.arg('burst')::FLOAT8 - 1, TRUE, now())
+ON CONFLICT (key) DO UPDATE SET
+    tokens = LEAST(sqlc.arg('burst')::FLOAT8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::FLOAT8 * sqlc.arg('rate')::FLOAT8)
+        - CASE WHEN LEAST(sqlc.arg('burst')::FLOAT8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::FLOAT8 * sqlc.arg('rate')::FLOAT8) >= 1 THEN 1 ELSE 0 END,
+    allowed = LEAST(sqlc.arg('burst')::FLOAT8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::FLOAT8 * sqlc.arg('rate')::FLOAT8) >= 1,
+    updated_at = now()
+RETURNING tokens, allowed;
+
+-- name: DeleteIdleRateLimitBuckets :execrows
+DELETE FROM rate_limit_buckets WHERE updated_at < sqlc.arg('idle_since');
@@ -0,0 +1,246 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/beavercli/beaver_api/internal/service"
+)
+
+// @Summary		List users
+// @Description	Returns a paginated list of every user with their role and whether the account is disabled
+// @Tags			admin
+// @Produce		json
+// @Param			page		query	int	false	"Page number"		default(1)
+// @Param			page_size	query	int	false	"Items per page"	default(20)
+// @Security		BearerAuth
+// @Success		200	{object}	AdminUsersPageResponse
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Router			/api/v1/admin/users [get]
+func (s *server) handleAdminListUsers(w http.ResponseWriter, r *http.Request) {
+	p, err := toPageQuery(r.URL.Query())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ul, err := s.service.GetUsersPage(r.Context(), service.PageParam{
+		Page:     p.Page,
+		PageSize: p.PageSize,
+	})
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toPage(toAdminUsers(ul.Items), ul.Total, p.Page, p.PageSize))
+}
+
+// @Summary		Change user role
+// @Description	Sets the site-wide role of a user. The last active admin cannot be demoted.
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			UserID	path	int						true	"User ID"
+// @Param			body	body	UpdateUserRoleRequest	true	"New role"
+// @Security		BearerAuth
+// @Success		200	{object}	AdminUser
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		409	{object}	ErrorResponse
+// @Router			/api/v1/admin/users/{UserID} [patch]
+func (s *server) handleAdminUpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.PathValue("UserID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	actorID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	p, err := decodeJSONBody[UpdateUserRoleRequest](r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	u, err := s.service.SetUserRole(r.Context(), actorID, userID, service.Role(p.Role))
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toAdminUser(u))
+}
+
+// @Summary		Disable user
+// @Description	Blocks the user from signing in and logs them out everywhere. Their service access tokens are rejected while the account is disabled.
+// @Tags			admin
+// @Param			UserID	path	int	true	"User ID"
+// @Security		BearerAuth
+// @Success		204
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		409	{object}	ErrorResponse
+// @Router			/api/v1/admin/users/{UserID}/disable [post]
+func (s *server) handleAdminDisableUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.PathValue("UserID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	actorID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := s.service.DisableUser(r.Context(), actorID, userID); err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary		Enable user
+// @Description	Lets a disabled user sign in again
+// @Tags			admin
+// @Param			UserID	path	int	true	"User ID"
+// @Security		BearerAuth
+// @Success		204
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Router			/api/v1/admin/users/{UserID}/enable [post]
+func (s *server) handleAdminEnableUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.PathValue("UserID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	actorID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := s.service.EnableUser(r.Context(), actorID, userID); err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary		Force logout
+// @Description	Ends every session of the user, they have to sign in again on all of their devices
+// @Tags			admin
+// @Produce		json
+// @Param			UserID	path	int	true	"User ID"
+// @Security		BearerAuth
+// @Success		200	{object}	ForceLogoutResponse
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Router			/api/v1/admin/users/{UserID}/logout [post]
+func (s *server) handleAdminForceLogout(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.PathValue("UserID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	actorID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	n, err := s.service.ForceLogoutUser(r.Context(), actorID, userID)
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, ForceLogoutResponse{RevokedSessions: n})
+}
+
+// @Summary		List orphaned snippets
+// @Description	Returns a paginated list of the snippets that have no owner
+// @Tags			admin
+// @Produce		json
+// @Param			page		query	int	false	"Page number"		default(1)
+// @Param			page_size	query	int	false	"Items per page"	default(20)
+// @Security		BearerAuth
+// @Success		200	{object}	OrphanedSnippetsPageResponse
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Router			/api/v1/admin/snippets/orphaned [get]
+func (s *server) handleAdminListOrphanedSnippets(w http.ResponseWriter, r *http.Request) {
+	p, err := toPageQuery(r.URL.Query())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sl, err := s.service.GetOrphanedSnippetsPage(r.Context(), service.PageParam{
+		Page:     p.Page,
+		PageSize: p.PageSize,
+	})
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toPage(toOrphanedSnippets(sl.Items), sl.Total, p.Page, p.PageSize))
+}
+
+// @Summary		Take over snippet
+// @Description	Assigns an orphaned snippet to the caller or to another user
+// @Tags			admin
+// @Accept			json
+// @Param			SnippetID	path	int						true	"Snippet ID"
+// @Param			body		body	TakeOverSnippetRequest	false	"New owner"
+// @Security		BearerAuth
+// @Success		204
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		409	{object}	ErrorResponse
+// @Router			/api/v1/admin/snippets/{SnippetID}/take-over [post]
+func (s *server) handleAdminTakeOverSnippet(w http.ResponseWriter, r *http.Request) {
+	snippetID, err := strconv.ParseInt(r.PathValue("SnippetID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	actorID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var p TakeOverSnippetRequest
+	if r.ContentLength != 0 {
+		p, err = decodeJSONBody[TakeOverSnippetRequest](r)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if err := s.service.TakeOverSnippet(r.Context(), service.TakeOverSnippetParam{
+		ActorID:    actorID,
+		SnippetID:  snippetID,
+		ToUsername: p.Username,
+	}); err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,77 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/beavercli/beaver_api/internal/service"
+)
+
+// @Summary		List audit events
+// @Description	Returns the audit log newest first, optionally filtered. Pass the next_cursor of a page as cursor to get the following one.
+// @Tags			admin
+// @Produce		json
+// @Param			actor_id	query	int		false	"User that made the change"
+// @Param			action		query	string	false	"Action, e.g. snippet.updated"
+// @Param			target_type	query	string	false	"Type of the changed object, e.g. snippet"
+// @Param			target_id	query	int		false	"ID of the changed object"
+// @Param			token_id	query	int		false	"Service access token the change was made with"
+// @Param			since		query	string	false	"Events at or after this RFC 3339 time"
+// @Param			until		query	string	false	"Events before this RFC 3339 time"
+// @Param			cursor		query	string	false	"Cursor of the page"
+// @Param			limit		query	int		false	"Items per page"	default(50)
+// @Security		BearerAuth
+// @Success		200	{object}	AuditEventsPage
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Router			/api/v1/audit [get]
+func (s *server) handleListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+	p := service.ListAuditEventsParam{
+		Action:     service.AuditAction(v.Get("action")),
+		TargetType: v.Get("target_type"),
+		Cursor:     v.Get("cursor"),
+	}
+
+	for name, dst := range map[string]*int64{
+		"actor_id":  &p.ActorID,
+		"target_id": &p.TargetID,
+		"token_id":  &p.TokenID,
+	} {
+		id, err := parseOptionalID(name, v.Get(name))
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if id != nil {
+			*dst = *id
+		}
+	}
+
+	var err error
+	if p.Since, err = parseOptionalTime("since", v.Get("since")); err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if p.Until, err = parseOptionalTime("until", v.Get("until")); err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if raw := v.Get("limit"); raw != "" {
+		if p.Limit, err = strconv.Atoi(raw); err != nil || p.Limit <= 0 {
+			jsonError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+	}
+
+	el, err := s.service.ListAuditEvents(r.Context(), p)
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, AuditEventsPage{
+		Items:      toAuditEvents(el.Items),
+		NextCursor: el.NextCursor,
+	})
+}
@@ -3,56 +3,66 @@ package router
 import (
 	"net/http"
 	"strconv"
+
+	"github.com/beavercli/beaver_api/internal/service"
 )
 
-// @Summary		Start GitHub device login
-// @Description	Starts the GitHub device OAuth flow and returns verification URL, user code, and polling token
+// @Summary		Start device login
+// @Description	Starts the device OAuth flow of a login provider (github, gitlab or a configured OpenID Connect issuer) and returns verification URL, user code, and polling token
 // @Tags			auth
 // @Produce		json
-// @Success		200	{object}	DeviceOAuth
-// @Failure		400	{object}	ErrorResponse
-// @Failure		500	{object}	ErrorResponse
-// @Router			/auth/github/login [post]
-func (s *server) handleGithubLogin(w http.ResponseWriter, r *http.Request) {
-	dr, err := s.service.GetDeviceRequest(r.Context())
+// @Param			provider	path		string	true	"Login provider"
+// @Success		200			{object}	DeviceOAuth
+// @Failure		400			{object}	ErrorResponse
+// @Failure		404			{object}	ErrorResponse
+// @Failure		500			{object}	ErrorResponse
+// @Router			/auth/{provider}/login [post]
+func (s *server) handleDeviceLogin(w http.ResponseWriter, r *http.Request) {
+	dr, err := s.service.GetDeviceRequest(r.Context(), r.PathValue("provider"))
 	if err != nil {
-		jsonError(w, http.StatusBadRequest, err.Error())
+		s.jsonServiceError(w, r, err)
 		return
 	}
 	jsonResponse(w, http.StatusOK, toDeviceOAuth(dr))
 }
 
-// @Summary		Complete GitHub device login
-// @Description	Exchanges the device flow token for a GitHub user and upserts the user in the database
+// @Summary		Complete device login
+// @Description	Exchanges the device flow token for the provider account and signs in the user it is linked to, creating the user on first login. A provider account whose email belongs to an existing user has to be linked from a signed-in session instead.
 // @Tags			auth
 // @Accept			json
 // @Produce		json
-// @Param			request	body		GithubPullRequest	true	"Device flow token returned from /auth/github/login"
-// @Success		200		{object}	DeviceAuthResult
-// @Failure		400		{object}	ErrorResponse
-// @Failure		500		{object}	ErrorResponse
-// @Router			/auth/github/device/poll [post]
-func (s *server) handleGitHubDeviceStatus(w http.ResponseWriter, r *http.Request) {
-	p, err := toGithubPullRequest(r)
+// @Param			provider	path		string				true	"Login provider"
+// @Param			request		body		DevicePollRequest	true	"Device flow token returned from /auth/{provider}/login"
+// @Success		200			{object}	DeviceAuthResult
+// @Failure		400			{object}	ErrorResponse
+// @Failure		404			{object}	ErrorResponse
+// @Failure		409			{object}	ErrorResponse	"The email belongs to an existing user"
+// @Failure		429			{object}	ErrorResponse	"Polled faster than the interval of the login"
+// @Failure		500			{object}	ErrorResponse
+// @Router			/auth/{provider}/device/poll [post]
+func (s *server) handleDevicePoll(w http.ResponseWriter, r *http.Request) {
+	p, err := toDevicePollRequest(r)
 	if err != nil {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	ar, err := s.service.GithubDevicePoll(r.Context(), p.Token)
+	if !s.allowDevicePoll(w, r, p.Token) {
+		return
+	}
+	ar, err := s.service.DevicePoll(r.Context(), r.PathValue("provider"), p.Token, clientInfo(r, p.DeviceName))
 	if err != nil {
-		jsonError(w, http.StatusBadRequest, err.Error())
+		s.jsonServiceError(w, r, err)
 		return
 	}
 	jsonResponse(w, http.StatusOK, toDeviceAuthResult(ar))
 }
 
 // @Summary		Refresh session tokens
-// @Description	Rotates a refresh token and returns a new access/refresh pair
+// @Description	Rotates a refresh token and returns a new access/refresh pair. Replaying an already rotated refresh token revokes its session
 // @Tags			auth
 // @Accept			json
 // @Produce		json
 // @Param			request	body	RefreshToken	true	"Refresh token payload"
-// @Security		BearerAuth
 // @Success		200	{object}	TokenPair
 // @Failure		400	{object}	ErrorResponse
 // @Failure		500	{object}	ErrorResponse
@@ -64,13 +74,7 @@ func (s *server) handleTokenRotate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	uID, err := strconv.ParseInt(t.UserID, 10, 64)
-	if err != nil {
-		jsonError(w, http.StatusBadRequest, err.Error())
-		return
-
-	}
-	tp, err := s.service.RotateTokens(r.Context(), uID, t.RefreshToken)
+	tp, err := s.service.RotateTokens(r.Context(), t.RefreshToken, clientInfo(r, ""))
 	if err != nil {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
@@ -80,10 +84,12 @@ func (s *server) handleTokenRotate(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary		Logout
-// @Description	Clears user session and logs out
+// @Description	Ends the session of the access token, other devices stay logged in
 // @Tags			auth
 // @Security		BearerAuth
-// @Success		200	{object}	MessageResponse
+// @Success		204
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
 // @Failure		500	{object}	ErrorResponse
 // @Router			/auth/logout [post]
 func (s *server) handleLogout(w http.ResponseWriter, r *http.Request) {
@@ -92,23 +98,282 @@ func (s *server) handleLogout(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	sessionID, err := getSessionIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := s.service.LogoutUser(r.Context(), userID, sessionID); err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
 
-	if err := s.service.LogoutUser(r.Context(), userID); err != nil {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary		List sessions
+// @Description	Returns a paginated list of the devices the current user is logged in on
+// @Tags			auth
+// @Produce		json
+// @Param			page		query	int	false	"Page number"		default(1)
+// @Param			page_size	query	int	false	"Items per page"	default(20)
+// @Security		BearerAuth
+// @Success		200	{object}	DeviceSessionsPageResponse
+// @Failure		400	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/auth/sessions [get]
+func (s *server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	p, err := toPageQuery(r.URL.Query())
+	if err != nil {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// service access tokens have no session, nothing is marked as current then
+	currentID, _ := getSessionIDFromCtx(r.Context())
+
+	sl, err := s.service.GetSessionsPage(r.Context(), userID, service.PageParam{
+		Page:     p.Page,
+		PageSize: p.PageSize,
+	})
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
 
-	jsonResponse(w, http.StatusNoContent, nil)
+	jsonResponse(w, http.StatusOK, toPage(toDeviceSessions(sl.Items, currentID), sl.Total, p.Page, p.PageSize))
+}
+
+// @Summary		Revoke session
+// @Description	Logs the current user out of one of their devices
+// @Tags			auth
+// @Param			ID	path	int	true	"Session ID"
+// @Security		BearerAuth
+// @Success		204
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/auth/sessions/{ID} [delete]
+func (s *server) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := strconv.ParseInt(r.PathValue("ID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := s.service.RevokeSession(r.Context(), userID, sessionID); err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // @Summary		Get current user
-// @Description	Returns the currently authenticated user
+// @Description	Returns the profile of the currently authenticated user with the identity providers linked to it
 // @Tags			auth
 // @Produce		json
 // @Security		BearerAuth
-// @Success		200	{object}	User
+// @Success		200	{object}	UserProfile
 // @Failure		401	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
 // @Router			/auth/me [get]
 func (s *server) handleMe(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	u, err := s.service.GetUserProfile(r.Context(), userID)
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toUserProfile(u))
+}
+
+// @Summary		Update current user
+// @Description	Partially updates the profile of the currently authenticated user
+// @Tags			auth
+// @Accept			json
+// @Produce		json
+// @Param			body	body	UpdateUserProfileRequest	true	"Fields to update"
+// @Security		BearerAuth
+// @Success		200	{object}	UserProfile
+// @Failure		400	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Router			/auth/me [patch]
+func (s *server) handleUpdateMe(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	p, err := decodeJSONBody[UpdateUserProfileRequest](r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	u, err := s.service.UpdateUserProfile(r.Context(), service.UpdateUserProfileParam{
+		UserID:          userID,
+		DisplayName:     p.DisplayName,
+		DefaultLanguage: p.DefaultLanguage,
+	})
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toUserProfile(u))
+}
+
+// @Summary		Delete current user
+// @Description	Deletes the account of the currently authenticated user and revokes all of its tokens. Owned snippets are either deleted or transferred to another user or to an organization. A user only takes over organization snippets of organizations they are a member of, other snippets leave their organization and team ones turn private. An organization only takes over personal snippets and its own; snippets of other organizations are left without an owner.
+// @Tags			auth
+// @Accept			json
+// @Param			body	body	DeleteAccountRequest	true	"What to do with the owned snippets"
+// @Security		BearerAuth
+// @Success		204
+// @Failure		400	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		409	{object}	ErrorResponse
+// @Router			/auth/me [delete]
+func (s *server) handleDeleteMe(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	p, err := decodeJSONBody[DeleteAccountRequest](r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	dp, err := toDeleteAccountParams(p, userID)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := s.service.DeleteAccount(r.Context(), dp); err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary		List linked identities
+// @Description	Returns the login provider accounts linked to the currently authenticated user
+// @Tags			auth
+// @Produce		json
+// @Security		BearerAuth
+// @Success		200	{array}		Identity
+// @Failure		401	{object}	ErrorResponse
+// @Router			/auth/me/identities [get]
+func (s *server) handleListIdentities(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	is, err := s.service.ListIdentities(r.Context(), userID)
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
 
+	jsonResponse(w, http.StatusOK, toIdentities(is))
+}
+
+// @Summary		Link identity
+// @Description	Completes a device login started with /auth/{provider}/login and links the provider account to the currently authenticated user instead of signing in. Poll it like /auth/{provider}/device/poll until the status is done.
+// @Tags			auth
+// @Accept			json
+// @Produce		json
+// @Param			provider	path	string				true	"Login provider"
+// @Param			request		body	DevicePollRequest	true	"Device flow token returned from /auth/{provider}/login"
+// @Security		BearerAuth
+// @Success		200	{object}	DeviceLinkResult
+// @Failure		400	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		409	{object}	ErrorResponse
+// @Failure		429	{object}	ErrorResponse
+// @Router			/auth/me/identities/{provider} [post]
+func (s *server) handleLinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	p, err := toDevicePollRequest(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !s.allowDevicePoll(w, r, p.Token) {
+		return
+	}
+
+	lr, err := s.service.LinkIdentity(r.Context(), userID, r.PathValue("provider"), p.Token)
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toDeviceLinkResult(lr))
+}
+
+// @Summary		Unlink identity
+// @Description	Removes a login provider from the currently authenticated user. The last linked provider cannot be removed.
+// @Tags			auth
+// @Param			provider	path	string	true	"Login provider"
+// @Security		BearerAuth
+// @Success		204
+// @Failure		401	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		409	{object}	ErrorResponse
+// @Router			/auth/me/identities/{provider} [delete]
+func (s *server) handleUnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := s.service.UnlinkIdentity(r.Context(), userID, r.PathValue("provider")); err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary		JSON Web Key Set
+// @Description	Publishes the public keys of the ES256/EdDSA keys the tokens are signed with, so other services can verify them. HS256 keys are never published.
+// @Tags			auth
+// @Produce		json
+// @Success		200	{object}	object	"JWKS document"
+// @Router			/.well-known/jwks.json [get]
+func (s *server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	jsonResponse(w, http.StatusOK, s.service.JWKS())
 }
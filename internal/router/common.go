@@ -18,6 +18,12 @@ const (
 	defaultPage     = 1
 	defaultPageSize = 20
 	maxPageSize     = 100
+
+	maxSearchQueryLen = 256
+
+	// sizes of the session columns the client info is stored in
+	maxDeviceNameLen = 255
+	maxUserAgentLen  = 512
 )
 
 type PageQueryArg struct {
@@ -28,7 +28,7 @@ func (s *server) handleListContributors(w http.ResponseWriter, r *http.Request)
 		PageSize: page.PageSize,
 	})
 	if err != nil {
-		jsonError(w, http.StatusBadRequest, err.Error())
+		s.jsonServiceError(w, r, err)
 		return
 	}
 	jsonResponse(w, http.StatusOK, toPage(toContributors(contribList.Items), contribList.Total, page.Page, page.PageSize))
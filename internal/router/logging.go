@@ -0,0 +1,126 @@
+package router
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/beavercli/beaver_api/common/logger"
+	"github.com/beavercli/beaver_api/internal/service"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds the request IDs taken over from clients and proxies.
+const maxRequestIDLen = 128
+
+// accessLogEntry collects what the handlers learn about a request for its
+// access log line.
+type accessLogEntry struct {
+	userID  int64
+	tokenID int64
+}
+
+type accessLogKey struct{}
+
+// setAccessLogPrincipal records who made the request in its access log line.
+func setAccessLogPrincipal(ctx context.Context, p service.Principal) {
+	if e, ok := ctx.Value(accessLogKey{}).(*accessLogEntry); ok {
+		e.userID = p.UserID
+		e.tokenID = p.TokenID
+	}
+}
+
+// statusRecorder keeps the status and size of the response for the access log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// requestLogger gives every request an ID, taken from the X-Request-ID header
+// when a proxy set one, returns it in the same header and writes an access log
+// line once the request is served.
+func (s *server) requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		entry := &accessLogEntry{}
+		ctx := logger.WithRequestID(r.Context(), id)
+		ctx = context.WithValue(ctx, accessLogKey{}, entry)
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Int("bytes", rec.bytes),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("ip", clientInfo(r, "").IP),
+			slog.String("user_agent", r.UserAgent()),
+		}
+		if entry.userID != 0 {
+			attrs = append(attrs, slog.Int64("user_id", entry.userID))
+		}
+		if entry.tokenID != 0 {
+			attrs = append(attrs, slog.Int64("token_id", entry.tokenID))
+		}
+		s.log.LogAttrs(ctx, level, "request", attrs...)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -2,6 +2,7 @@ package router
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -9,42 +10,115 @@ import (
 	"github.com/beavercli/beaver_api/internal/service"
 )
 
-const UserContextKey = "UserContextKey"
+const (
+	UserContextKey      = "UserContextKey"
+	PrincipalContextKey = "PrincipalContextKey"
+)
+
+const authRealm = "beaver_api"
+
+// authSchemes maps the schemes of the Authorization header to the type of
+// token each one carries.
+var authSchemes = map[string]service.TokenType{
+	"Bearer":  service.AccessToken,
+	"Session": service.SessionToken,
+}
 
-func (s *server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// authMiddleware authenticates the request and, for service access tokens,
+// requires scope to be granted to the token.
+func (s *server) authMiddleware(scope service.Scope, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ht := r.Header.Get("Authorization")
 		if ht == "" {
+			// RFC 6750 challenges requests without credentials with no error code
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s"`, authRealm))
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Session realm="%s"`, authRealm))
 			jsonError(w, http.StatusUnauthorized, "Request is missing an Authorization header")
 			return
 		}
 
-		token := strings.Split(ht, " ")
-
-		var tokenType service.TokenType
-
-		switch token[0] {
-		case "Bearer":
-			tokenType = service.AccessToken
-			break
-		case "Session":
-			tokenType = service.SessionToken
-			break
-		default:
-			jsonError(w, http.StatusUnauthorized, fmt.Sprintf("Token type %s is not supported", token[0]))
+		scheme, token, _ := strings.Cut(ht, " ")
+		token = strings.TrimSpace(token)
+		tokenType, ok := authSchemes[scheme]
+		if !ok {
+			unauthorized(w, "Bearer", &service.AuthError{
+				Code:        service.AuthErrInvalidRequest,
+				Description: fmt.Sprintf("Authorization scheme %q is not supported", scheme),
+			})
+			return
+		}
+		if token == "" || strings.Contains(token, " ") {
+			unauthorized(w, scheme, &service.AuthError{
+				Code:        service.AuthErrInvalidRequest,
+				Description: fmt.Sprintf("Authorization header must be of the form: %s <token>", scheme),
+			})
 			return
 		}
 
-		userID, err := s.service.AuthUser(r.Context(), tokenType, token[1])
+		p, err := s.service.AuthUser(r.Context(), tokenType, token, clientInfo(r, ""))
 		if err != nil {
-			jsonError(w, http.StatusUnauthorized, err.Error())
+			var ae *service.AuthError
+			if errors.As(err, &ae) {
+				unauthorized(w, scheme, ae)
+				return
+			}
+			s.log.ErrorContext(r.Context(), "authenticating the request failed", "error", err)
+			jsonError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		setAccessLogPrincipal(r.Context(), p)
+		if !p.HasScope(scope) {
+			msg := fmt.Sprintf("Token is missing the %s scope", scope)
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`%s realm="%s", error="insufficient_scope", error_description="%s", scope="%s"`,
+				scheme, authRealm, msg, scope))
+			jsonError(w, http.StatusForbidden, msg)
+			return
+		}
+		if !s.limiter.allowPrincipal(w, r, p) {
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), UserContextKey, userID)
+		ctx := context.WithValue(r.Context(), UserContextKey, p.UserID)
+		ctx = context.WithValue(ctx, PrincipalContextKey, p)
+		ctx = service.WithActor(ctx, service.Actor{
+			UserID:    p.UserID,
+			TokenID:   p.TokenID,
+			SessionID: p.SessionID,
+			IP:        clientInfo(r, "").IP,
+		})
 		r = r.WithContext(ctx)
 
 		next(w, r)
 	}
 
 }
+
+// requirePermission rejects callers whose role does not grant perm. It runs
+// after authMiddleware, which puts the principal in the context.
+func (s *server) requirePermission(perm service.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := getPrincipalFromCtx(r.Context())
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !p.Can(perm) {
+			jsonError(w, http.StatusForbidden, fmt.Sprintf("The %s role is missing the %s permission", p.Role, perm))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// challengeQuoting drops the characters RFC 6750 does not allow in the
+// quoted values of a challenge.
+var challengeQuoting = strings.NewReplacer(`"`, `'`, `\`, "")
+
+// unauthorized rejects the credentials of the request with a 401 that carries
+// the reason both in the WWW-Authenticate challenge and in the body.
+func unauthorized(w http.ResponseWriter, scheme string, ae *service.AuthError) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`%s realm="%s", error="%s", error_description="%s"`,
+		scheme, authRealm, ae.Code, challengeQuoting.Replace(ae.Description)))
+	jsonError(w, http.StatusUnauthorized, ae.Description)
+}
@@ -1,15 +1,18 @@
 package router
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type RefreshToken struct {
-	UserID       string `json:"user_id"` // TODO: REMOVE (only for test)
 	RefreshToken string `json:"refresh_token"`
 }
 
 type Tag struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	Similarity float32 `json:"similarity,omitempty"`
 }
 
 type Language struct {
@@ -30,16 +33,54 @@ type Git struct {
 }
 
 type Snippet struct {
-	ID           string        `json:"id"`
-	Title        string        `json:"title"`
-	Code         string        `json:"code"`
-	Git          Git           `json:"git"`
-	GitPath      string        `json:"git_path"`
-	GitVersion   string        `json:"git_version"`
-	ProjectURL   string        `json:"project_url,omitempty"`
-	Language     Language      `json:"language"`
-	Tags         []Tag         `json:"tags"`
-	Contributors []Contributor `json:"contributors"`
+	ID             string        `json:"id"`
+	Title          string        `json:"title"`
+	Code           string        `json:"code"`
+	Git            Git           `json:"git"`
+	GitPath        string        `json:"git_path"`
+	GitVersion     string        `json:"git_version"`
+	ProjectURL     string        `json:"project_url,omitempty"`
+	Visibility     string        `json:"visibility"`
+	OrganizationID string        `json:"organization_id,omitempty"`
+	Language       Language      `json:"language"`
+	Tags           []Tag         `json:"tags"`
+	Contributors   []Contributor `json:"contributors"`
+}
+
+type TrashedSnippet struct {
+	ID         string   `json:"id"`
+	Title      string   `json:"title"`
+	Git        Git      `json:"git"`
+	GitPath    string   `json:"git_path"`
+	GitVersion string   `json:"git_version"`
+	Language   Language `json:"language"`
+	DeletedAt  string   `json:"deleted_at"`
+	PurgeAt    string   `json:"purge_at"` // the snippet is deleted for good after this time
+}
+
+type SnippetRevision struct {
+	Revision   int      `json:"revision"`
+	Title      string   `json:"title"`
+	Code       string   `json:"code"`
+	GitPath    string   `json:"git_path"`
+	GitVersion string   `json:"git_version"`
+	ProjectURL string   `json:"project_url,omitempty"`
+	Language   Language `json:"language"`
+	UserID     string   `json:"user_id,omitempty"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+type SnippetRevisionSummary struct {
+	Revision   int    `json:"revision"`
+	GitVersion string `json:"git_version"`
+	UserID     string `json:"user_id,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type SnippetDiff struct {
+	From int    `json:"from"`
+	To   int    `json:"to"`
+	Diff string `json:"diff"` // unified diff of the snippet code, empty when equal
 }
 
 type User struct {
@@ -48,20 +89,65 @@ type User struct {
 	Email    string `json:"email,omitempty"`
 }
 
+type UserProfile struct {
+	ID              string   `json:"id"`
+	Username        string   `json:"username"`
+	Email           string   `json:"email"`
+	DisplayName     string   `json:"display_name,omitempty"`
+	DefaultLanguage string   `json:"default_language,omitempty"`
+	Providers       []string `json:"providers"`
+	CreatedAt       string   `json:"created_at"`
+}
+
+// UpdateUserProfileRequest is a partial update; omitted fields are left
+// unchanged and an empty string clears the field.
+type UpdateUserProfileRequest struct {
+	DisplayName     *string `json:"display_name,omitempty"`
+	DefaultLanguage *string `json:"default_language,omitempty"`
+}
+
+type DeleteAccountRequest struct {
+	Snippets                 string `json:"snippets" enums:"transfer,delete"`
+	TransferToUsername       string `json:"transfer_to_username,omitempty"`
+	TransferToOrganizationID string `json:"transfer_to_organization_id,omitempty"`
+}
+
+type DeviceSession struct {
+	ID         string `json:"id"`
+	DeviceName string `json:"device_name,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	IP         string `json:"ip_address,omitempty"`
+	Current    bool   `json:"current"` // the session of the requesting access token
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at"`
+}
+
 type SnippetSummary struct {
-	ID         string   `json:"id"`
-	Title      string   `json:"title"`
-	ProjectURL string   `json:"project_url,omitempty"`
-	Git        Git      `json:"git"`
-	GitPath    string   `json:"git_path"`
-	GitVersion string   `json:"git_version"`
-	Language   Language `json:"language"`
-	Tags       []Tag    `json:"tags"`
+	ID             string   `json:"id"`
+	Title          string   `json:"title"`
+	ProjectURL     string   `json:"project_url,omitempty"`
+	Git            Git      `json:"git"`
+	GitPath        string   `json:"git_path"`
+	GitVersion     string   `json:"git_version"`
+	Visibility     string   `json:"visibility"`
+	OrganizationID string   `json:"organization_id,omitempty"`
+	Language       Language `json:"language"`
+	Tags           []Tag    `json:"tags"`
+	Rank           float32  `json:"rank,omitempty"`
+	Similarity     float32  `json:"similarity,omitempty"`
+	Highlight      string   `json:"highlight,omitempty"`
 }
 
 type SnippetListFilterArg struct {
-	LanguageID *int64  // nil or >0
-	TagIDs     []int64 // nil or all(>0)
+	OrganizationID *int64  // nil or >0
+	LanguageID     *int64  // nil or >0
+	TagIDs         []int64 // nil or all(>0)
+	Query          string  // empty or full-text search query
+	Fuzzy          bool    // trigram title match instead of full-text, requires Query
+}
+
+type TagListFilterArg struct {
+	Query string // empty or fuzzy search query
 }
 
 type DeviceOAuth struct {
@@ -91,19 +177,32 @@ type CreateGit struct {
 }
 
 type IngestSnippetRequest struct {
-	Title        string                     `json:"title"`
-	Code         string                     `json:"code"`
-	ProjectURL   string                     `json:"project_url,omitempty"`
-	Git          CreateGit                  `json:"git_repo_url"`
-	GitPath      string                     `json:"git_path"`
-	GitVersion   string                     `json:"git_version"`
-	Language     CreateLanguageRequest      `json:"language"`
-	Tags         []CreateTagRequest         `json:"tags"`
-	Contributors []CreateContributorRequest `json:"contributors"`
+	Title          string                     `json:"title"`
+	Code           string                     `json:"code"`
+	ProjectURL     string                     `json:"project_url,omitempty"`
+	Git            CreateGit                  `json:"git_repo_url"`
+	GitPath        string                     `json:"git_path"`
+	GitVersion     string                     `json:"git_version"`
+	Visibility     string                     `json:"visibility,omitempty" enums:"private,team,public"`
+	OrganizationID string                     `json:"organization_id,omitempty"`
+	Language       CreateLanguageRequest      `json:"language"`
+	Tags           []CreateTagRequest         `json:"tags"`
+	Contributors   []CreateContributorRequest `json:"contributors"`
 }
 
-type GithubPullRequest struct {
-	Token string `json:"token"`
+// UpdateSnippetRequest is a partial update; omitted fields are left unchanged.
+// An empty tags or contributors list removes all of them from the snippet.
+type UpdateSnippetRequest struct {
+	Title        *string                     `json:"title,omitempty"`
+	Code         *string                     `json:"code,omitempty"`
+	Visibility   *string                     `json:"visibility,omitempty" enums:"private,team,public"`
+	Tags         *[]CreateTagRequest         `json:"tags,omitempty"`
+	Contributors *[]CreateContributorRequest `json:"contributors,omitempty"`
+}
+
+type DevicePollRequest struct {
+	Token      string `json:"token"`
+	DeviceName string `json:"device_name,omitempty"` // shown in the session list, e.g. the hostname
 }
 type TokenPair struct {
 	AccessToken  string `json:"acess_token"`
@@ -128,29 +227,135 @@ type DeviceAuthResult struct {
 	Session *Session
 }
 
+type Identity struct {
+	Provider  string `json:"provider"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	CreatedAt string `json:"created_at"`
+}
+
+type DeviceLinkResult struct {
+	Status   DeviceAuthStatus `json:"status"`
+	Identity *Identity        `json:"identity,omitempty"`
+}
+
 type CreateServiceAccessTokenRequest struct {
-	Name      string    `json:"name"`
-	ExpiresAt time.Time `json:"expires_at"`
+	Name           string    `json:"name"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	OrganizationID string    `json:"organization_id,omitempty"`
+	Scopes         []string  `json:"scopes"` // snippets:read, snippets:write, tokens:manage or admin
 }
 
 type ServiceAccessToken struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	Token     string `json:"token,omitempty"` // Secret token value; returned only at creation time
-	ExpiresAt string `json:"expires_at,omitempty"`
-	CreatedAt string `json:"created_at"`
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	Token          string   `json:"token,omitempty"` // Secret token value; returned only at creation time
+	OrganizationID string   `json:"organization_id,omitempty"`
+	Scopes         []string `json:"scopes"`
+	ExpiresAt      string   `json:"expires_at,omitempty"`
+	CreatedAt      string   `json:"created_at"`
 }
 
 type ServiceAccessTokenSummary struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	OrganizationID string   `json:"organization_id,omitempty"`
+	Scopes         []string `json:"scopes"`
+	ExpiresAt      string   `json:"expires_at,omitempty"`
+	CreatedAt      string   `json:"created_at"`
+	LastUsedAt     string   `json:"last_used_at,omitempty"` // empty when the token was never used
+	LastUsedIP     string   `json:"last_used_ip,omitempty"`
+	UsageCount     int64    `json:"usage_count"`
+}
+
+type Organization struct {
 	ID        string `json:"id"`
 	Name      string `json:"name"`
-	ExpiresAt string `json:"expires_at,omitempty"`
+	Role      string `json:"role"` // role of the caller
 	CreatedAt string `json:"created_at"`
 }
 
+type OrganizationMember struct {
+	User     User   `json:"user"`
+	Role     string `json:"role"`
+	JoinedAt string `json:"joined_at"`
+}
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+type UpdateOrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+type AddOrganizationMemberRequest struct {
+	Username string `json:"username"`
+	Role     string `json:"role" enums:"owner,admin,member"`
+}
+
+type UpdateOrganizationMemberRequest struct {
+	Role string `json:"role" enums:"owner,admin,member"`
+}
+
+type AdminUser struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	Email      string `json:"email"`
+	Role       string `json:"role" enums:"admin,maintainer,member,read_only"`
+	DisabledAt string `json:"disabled_at,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type UpdateUserRoleRequest struct {
+	Role string `json:"role" enums:"admin,maintainer,member,read_only"`
+}
+
+type ForceLogoutResponse struct {
+	RevokedSessions int64 `json:"revoked_sessions"`
+}
+
+type OrphanedSnippet struct {
+	ID             string `json:"id"`
+	Title          string `json:"title"`
+	OrganizationID string `json:"organization_id,omitempty"`
+	CreatedAt      string `json:"created_at"`
+}
+
+type TakeOverSnippetRequest struct {
+	Username string `json:"username,omitempty"` // new owner, the caller when empty
+}
+
+type AuditEvent struct {
+	ID         string          `json:"id"`
+	ActorID    string          `json:"actor_id,omitempty"`
+	TokenID    string          `json:"token_id,omitempty"`
+	SessionID  string          `json:"session_id,omitempty"`
+	IP         string          `json:"ip,omitempty"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id,omitempty"`
+	Data       json.RawMessage `json:"data,omitempty" swaggertype:"object"`
+	Before     json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After      json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+	CreatedAt  string          `json:"created_at"`
+}
+
+type AuditEventsPage struct {
+	Items      []AuditEvent `json:"items"`
+	NextCursor string       `json:"next_cursor,omitempty"` // empty on the last page
+}
+
 // Type aliases for Swagger documentation
 type SnippetsPageResponse = PageResponse[SnippetSummary]
 type TagsPageResponse = PageResponse[Tag]
 type LanguagesPageResponse = PageResponse[Language]
 type ContributorsPageResponse = PageResponse[Contributor]
 type ServiceAccessTokensPageResponse = PageResponse[ServiceAccessTokenSummary]
+type SnippetRevisionsPageResponse = PageResponse[SnippetRevisionSummary]
+type TrashPageResponse = PageResponse[TrashedSnippet]
+type OrganizationsPageResponse = PageResponse[Organization]
+type DeviceSessionsPageResponse = PageResponse[DeviceSession]
+type OrganizationMembersPageResponse = PageResponse[OrganizationMember]
+type AdminUsersPageResponse = PageResponse[AdminUser]
+type OrphanedSnippetsPageResponse = PageResponse[OrphanedSnippet]
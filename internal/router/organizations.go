@@ -0,0 +1,392 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/beavercli/beaver_api/internal/service"
+)
+
+// @Summary		Create organization
+// @Description	Creates an organization with the caller as its owner
+// @Tags			organizations
+// @Accept			json
+// @Produce		json
+// @Param			body	body	CreateOrganizationRequest	true	"Organization data"
+// @Security		BearerAuth
+// @Success		201	{object}	Organization
+// @Failure		400	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/orgs [post]
+func (s *server) handleCreateOrganization(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	p, err := decodeJSONBody[CreateOrganizationRequest](r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	o, err := s.service.CreateOrganization(r.Context(), userID, p.Name)
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusCreated, toOrganization(o))
+}
+
+// @Summary		List organizations
+// @Description	Returns a paginated list of the organizations the caller is a member of
+// @Tags			organizations
+// @Produce		json
+// @Param			page		query	int	false	"Page number"		default(1)
+// @Param			page_size	query	int	false	"Items per page"	default(20)
+// @Security		BearerAuth
+// @Success		200	{object}	OrganizationsPageResponse
+// @Failure		400	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/orgs [get]
+func (s *server) handleListOrganizations(w http.ResponseWriter, r *http.Request) {
+	p, err := toPageQuery(r.URL.Query())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	orgs, err := s.service.GetOrganizationsPage(r.Context(), userID, service.PageParam{
+		Page:     p.Page,
+		PageSize: p.PageSize,
+	})
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toPage(toOrganizations(orgs.Items), orgs.Total, p.Page, p.PageSize))
+}
+
+// @Summary		Get organization
+// @Description	Returns an organization the caller is a member of
+// @Tags			organizations
+// @Produce		json
+// @Param			OrgID	path	int	true	"Organization ID"
+// @Security		BearerAuth
+// @Success		200	{object}	Organization
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/orgs/{OrgID} [get]
+func (s *server) handleGetOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID, err := strconv.ParseInt(r.PathValue("OrgID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	o, err := s.service.GetOrganization(r.Context(), orgID, userID)
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toOrganization(o))
+}
+
+// @Summary		Rename organization
+// @Description	Renames an organization. Requires the admin role.
+// @Tags			organizations
+// @Accept			json
+// @Produce		json
+// @Param			OrgID	path	int							true	"Organization ID"
+// @Param			body	body	UpdateOrganizationRequest	true	"New organization data"
+// @Security		BearerAuth
+// @Success		200	{object}	Organization
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/orgs/{OrgID} [patch]
+func (s *server) handleUpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID, err := strconv.ParseInt(r.PathValue("OrgID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	p, err := decodeJSONBody[UpdateOrganizationRequest](r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	o, err := s.service.RenameOrganization(r.Context(), orgID, userID, p.Name)
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toOrganization(o))
+}
+
+// @Summary		Delete organization
+// @Description	Deletes an organization with its memberships and tokens. Its snippets stay with their owners and team snippets become private. Requires the owner role.
+// @Tags			organizations
+// @Param			OrgID	path	int	true	"Organization ID"
+// @Security		BearerAuth
+// @Success		204
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/orgs/{OrgID} [delete]
+func (s *server) handleDeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID, err := strconv.ParseInt(r.PathValue("OrgID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := s.service.DeleteOrganization(r.Context(), orgID, userID); err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary		List organization members
+// @Description	Returns a paginated list of the members of an organization
+// @Tags			organizations
+// @Produce		json
+// @Param			OrgID		path	int	true	"Organization ID"
+// @Param			page		query	int	false	"Page number"		default(1)
+// @Param			page_size	query	int	false	"Items per page"	default(20)
+// @Security		BearerAuth
+// @Success		200	{object}	OrganizationMembersPageResponse
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/orgs/{OrgID}/members [get]
+func (s *server) handleListOrganizationMembers(w http.ResponseWriter, r *http.Request) {
+	orgID, err := strconv.ParseInt(r.PathValue("OrgID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err := toPageQuery(r.URL.Query())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	members, err := s.service.GetOrganizationMembersPage(r.Context(), orgID, userID, service.PageParam{
+		Page:     p.Page,
+		PageSize: p.PageSize,
+	})
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toPage(toOrganizationMembers(members.Items), members.Total, p.Page, p.PageSize))
+}
+
+// @Summary		Add organization member
+// @Description	Adds an existing user to an organization. Requires the admin role; only owners can add owners.
+// @Tags			organizations
+// @Accept			json
+// @Param			OrgID	path	int								true	"Organization ID"
+// @Param			body	body	AddOrganizationMemberRequest	true	"Member data"
+// @Security		BearerAuth
+// @Success		204
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/orgs/{OrgID}/members [post]
+func (s *server) handleAddOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	orgID, err := strconv.ParseInt(r.PathValue("OrgID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	p, err := decodeJSONBody[AddOrganizationMemberRequest](r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := s.service.AddOrganizationMember(r.Context(), service.AddOrganizationMemberParam{
+		OrganizationID: orgID,
+		ActorID:        userID,
+		Username:       p.Username,
+		Role:           service.OrgRole(p.Role),
+	}); err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary		Change organization member role
+// @Description	Changes the role of a member. Requires the admin role; only owners can grant or revoke the owner role.
+// @Tags			organizations
+// @Accept			json
+// @Param			OrgID	path	int								true	"Organization ID"
+// @Param			UserID	path	int								true	"User ID"
+// @Param			body	body	UpdateOrganizationMemberRequest	true	"New role"
+// @Security		BearerAuth
+// @Success		204
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/orgs/{OrgID}/members/{UserID} [patch]
+func (s *server) handleUpdateOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	orgID, err := strconv.ParseInt(r.PathValue("OrgID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	memberID, err := strconv.ParseInt(r.PathValue("UserID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	p, err := decodeJSONBody[UpdateOrganizationMemberRequest](r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := s.service.UpdateOrganizationMemberRole(r.Context(), service.UpdateOrganizationMemberParam{
+		OrganizationID: orgID,
+		ActorID:        userID,
+		UserID:         memberID,
+		Role:           service.OrgRole(p.Role),
+	}); err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary		Remove organization member
+// @Description	Removes a member from an organization. Members can remove themselves; removing others requires the admin role.
+// @Tags			organizations
+// @Param			OrgID	path	int	true	"Organization ID"
+// @Param			UserID	path	int	true	"User ID"
+// @Security		BearerAuth
+// @Success		204
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/orgs/{OrgID}/members/{UserID} [delete]
+func (s *server) handleRemoveOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	orgID, err := strconv.ParseInt(r.PathValue("OrgID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	memberID, err := strconv.ParseInt(r.PathValue("UserID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := s.service.RemoveOrganizationMember(r.Context(), orgID, userID, memberID); err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary		List organization service access tokens
+// @Description	Returns a paginated list of the service access tokens issued for an organization. Requires the admin role.
+// @Tags			organizations
+// @Produce		json
+// @Param			OrgID		path	int	true	"Organization ID"
+// @Param			page		query	int	false	"Page number"		default(1)
+// @Param			page_size	query	int	false	"Items per page"	default(20)
+// @Security		BearerAuth
+// @Success		200	{object}	ServiceAccessTokensPageResponse
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/orgs/{OrgID}/service-access-tokens [get]
+func (s *server) handleListOrganizationServiceAccessTokens(w http.ResponseWriter, r *http.Request) {
+	orgID, err := strconv.ParseInt(r.PathValue("OrgID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err := toPageQuery(r.URL.Query())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	sl, err := s.service.ListOrganizationServiceAccessTokens(r.Context(), orgID, userID, service.PageParam{
+		Page:     p.Page,
+		PageSize: p.PageSize,
+	})
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toPage(toServiceAccessTokenSummaries(sl.Items), sl.Total, p.Page, p.PageSize))
+}
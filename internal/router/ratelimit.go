@@ -0,0 +1,109 @@
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/beavercli/beaver_api/common/config"
+	"github.com/beavercli/beaver_api/internal/service"
+)
+
+// rateLimiter limits the requests of a key, such as a user, with a token
+// bucket in store.
+type rateLimiter struct {
+	store  service.RateLimitStore
+	limits config.RateLimit
+	log    *slog.Logger
+}
+
+// allow takes a token from the bucket of key and sets the RateLimit headers.
+// Rejected requests get a 429 with a Retry-After header and false is
+// returned. A limit of zero requests turns limiting off.
+func (rl rateLimiter) allow(w http.ResponseWriter, r *http.Request, key string, limit config.Rate) bool {
+	if rl.store == nil || limit.Requests <= 0 {
+		return true
+	}
+
+	res, err := rl.store.Take(r.Context(), key, service.RateLimit{Requests: limit.Requests, Per: limit.Per})
+	if err != nil {
+		// an unavailable store must not take the API down with it
+		rl.log.WarnContext(r.Context(), "rate limit store failed, request let through", "key", key, "error", err)
+		return true
+	}
+
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, ceilSeconds(limit.Per)))
+	if res.Allowed {
+		return true
+	}
+
+	retryAfter := ceilSeconds(res.RetryAfter)
+	h.Set("Retry-After", strconv.Itoa(retryAfter))
+	jsonError(w, http.StatusTooManyRequests, fmt.Sprintf("Too many requests, retry in %d seconds", retryAfter))
+	return false
+}
+
+// allowPrincipal limits authenticated requests per user, and service access
+// tokens on their own so a busy CI job does not lock its owner out. Reads and
+// writes are limited separately.
+func (rl rateLimiter) allowPrincipal(w http.ResponseWriter, r *http.Request, p service.Principal) bool {
+	write := r.Method != http.MethodGet && r.Method != http.MethodHead
+	group, limit := "read", rl.limits.Read
+	switch {
+	case p.TokenID != 0 && write:
+		group, limit = "write", rl.limits.TokenWrite
+	case p.TokenID != 0:
+		limit = rl.limits.TokenRead
+	case write:
+		group, limit = "write", rl.limits.Write
+	}
+
+	key := fmt.Sprintf("user:%d:%s", p.UserID, group)
+	if p.TokenID != 0 {
+		key = fmt.Sprintf("token:%d:%s", p.TokenID, group)
+	}
+	return rl.allow(w, r, key, limit)
+}
+
+// allowDevicePoll holds the polls of a device login to the interval the
+// provider asked for, one poll per interval.
+func (rl rateLimiter) allowDevicePoll(w http.ResponseWriter, r *http.Request, token string, interval time.Duration) bool {
+	sum := sha256.Sum256([]byte(token))
+	key := "device:" + hex.EncodeToString(sum[:16])
+	return rl.allow(w, r, key, config.Rate{Requests: 1, Per: interval})
+}
+
+// allowDevicePoll limits the polls of the device login in token, an invalid
+// token is left for the handler to reject.
+func (s *server) allowDevicePoll(w http.ResponseWriter, r *http.Request, token string) bool {
+	interval, err := s.service.DevicePollInterval(token)
+	if err != nil {
+		return true
+	}
+	return s.limiter.allowDevicePoll(w, r, token, interval)
+}
+
+// rateLimitByIP limits the requests of unauthenticated routes per client IP,
+// group separates the buckets of routes with different limits.
+func (s *server) rateLimitByIP(group string, limit config.Rate, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := fmt.Sprintf("ip:%s:%s", clientInfo(r, "").IP, group)
+		if !s.limiter.allow(w, r, key, limit) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -1,6 +1,7 @@
 package router
 
 import (
+	"log/slog"
 	"net/http"
 
 	"github.com/beavercli/beaver_api/common/config"
@@ -10,39 +11,86 @@ import (
 
 type server struct {
 	service *service.Service
+	limiter rateLimiter
+	log     *slog.Logger
 }
 
-func New(cfg config.Server, service *service.Service) *http.Server {
+// New builds the API server. Requests are rate limited with the buckets in
+// limitStore, nil turns rate limiting off.
+func New(cfg config.Server, limits config.RateLimit, svc *service.Service, limitStore service.RateLimitStore, log *slog.Logger) *http.Server {
 	mux := http.NewServeMux()
 
 	s := &server{
-		service: service,
+		service: svc,
+		limiter: rateLimiter{store: limitStore, limits: limits, log: log},
+		log:     log,
 	}
 
 	mux.HandleFunc("GET /health", s.handleHealth)
 	mux.HandleFunc("GET /swagger/", httpSwagger.WrapHandler)
+	mux.HandleFunc("GET /.well-known/jwks.json", s.handleJWKS)
 
-	mux.HandleFunc("GET /api/v1/snippets/{SnippetID}", s.authMiddleware(s.handleGetSnippet))
-	mux.HandleFunc("GET /api/v1/snippets", s.authMiddleware(s.handleListSnippets))
-	mux.HandleFunc("POST /api/v1/snippets", s.authMiddleware(s.handleIngestSnippet))
+	mux.HandleFunc("GET /api/v1/snippets/{SnippetID}", s.authMiddleware(service.ScopeSnippetsRead, s.handleGetSnippet))
+	mux.HandleFunc("GET /api/v1/snippets", s.authMiddleware(service.ScopeSnippetsRead, s.handleListSnippets))
+	mux.HandleFunc("POST /api/v1/snippets", s.authMiddleware(service.ScopeSnippetsWrite, s.requirePermission(service.PermSnippetsWrite, s.handleIngestSnippet)))
+	mux.HandleFunc("PATCH /api/v1/snippets/{SnippetID}", s.authMiddleware(service.ScopeSnippetsWrite, s.requirePermission(service.PermSnippetsWrite, s.handleUpdateSnippet)))
+	mux.HandleFunc("DELETE /api/v1/snippets/{SnippetID}", s.authMiddleware(service.ScopeSnippetsWrite, s.requirePermission(service.PermSnippetsWrite, s.handleDeleteSnippet)))
+	mux.HandleFunc("POST /api/v1/snippets/{SnippetID}/restore", s.authMiddleware(service.ScopeSnippetsWrite, s.requirePermission(service.PermSnippetsWrite, s.handleRestoreSnippet)))
+	mux.HandleFunc("GET /api/v1/trash", s.authMiddleware(service.ScopeSnippetsRead, s.handleListTrash))
+	mux.HandleFunc("GET /api/v1/snippets/{SnippetID}/revisions", s.authMiddleware(service.ScopeSnippetsRead, s.handleListSnippetRevisions))
+	mux.HandleFunc("GET /api/v1/snippets/{SnippetID}/revisions/diff", s.authMiddleware(service.ScopeSnippetsRead, s.handleDiffSnippetRevisions))
+	mux.HandleFunc("GET /api/v1/snippets/{SnippetID}/revisions/{Revision}", s.authMiddleware(service.ScopeSnippetsRead, s.handleGetSnippetRevision))
 
-	mux.HandleFunc("GET /api/v1/tags", s.authMiddleware(s.handleListTags))
-	mux.HandleFunc("GET /api/v1/languages", s.authMiddleware(s.handleListLanguages))
-	mux.HandleFunc("GET /api/v1/contributors", s.authMiddleware(s.handleListContributors))
+	mux.HandleFunc("GET /api/v1/public/snippets/{SnippetID}", s.rateLimitByIP("public", limits.Public, s.handleGetPublicSnippet))
+	mux.HandleFunc("GET /api/v1/public/snippets", s.rateLimitByIP("public", limits.Public, s.handleListPublicSnippets))
 
-	mux.HandleFunc("POST /api/v1/service-access-tokens", s.authMiddleware(s.handleCreateServiceAccessToken))
-	mux.HandleFunc("GET /api/v1/service-access-tokens", s.authMiddleware(s.handleGetServiceAccessTokens))
-	mux.HandleFunc("DELETE /api/v1/service-access-tokens/{ID}", s.authMiddleware(s.handleDeleteServiceAccessToken))
+	mux.HandleFunc("GET /api/v1/tags", s.authMiddleware(service.ScopeSnippetsRead, s.handleListTags))
+	mux.HandleFunc("GET /api/v1/languages", s.authMiddleware(service.ScopeSnippetsRead, s.handleListLanguages))
+	mux.HandleFunc("GET /api/v1/contributors", s.authMiddleware(service.ScopeSnippetsRead, s.handleListContributors))
 
-	mux.HandleFunc("POST /auth/github/login", s.handleGithubLogin)
-	mux.HandleFunc("POST /auth/github/device/poll", s.handleGitHubDeviceStatus)
-	mux.HandleFunc("POST /auth/refresh", s.authMiddleware(s.handleTokenRotate))
-	mux.HandleFunc("POST /auth/logout", s.authMiddleware(s.handleLogout)) // TODO
-	// mux.HandleFunc("GET /auth/me", s.authMiddleware(s.handleMe))          // TODO
+	mux.HandleFunc("POST /api/v1/service-access-tokens", s.authMiddleware(service.ScopeTokensManage, s.handleCreateServiceAccessToken))
+	mux.HandleFunc("GET /api/v1/service-access-tokens", s.authMiddleware(service.ScopeTokensManage, s.handleGetServiceAccessTokens))
+	mux.HandleFunc("GET /api/v1/service-access-tokens/stale", s.authMiddleware(service.ScopeTokensManage, s.handleListStaleServiceAccessTokens))
+	mux.HandleFunc("DELETE /api/v1/service-access-tokens/{ID}", s.authMiddleware(service.ScopeTokensManage, s.handleRevokeServiceAccessToken))
+	mux.HandleFunc("POST /api/v1/service-access-tokens/{ID}/rotate", s.authMiddleware(service.ScopeTokensManage, s.handleRotateServiceAccessToken))
+
+	mux.HandleFunc("POST /api/v1/orgs", s.authMiddleware(service.ScopeAdmin, s.handleCreateOrganization))
+	mux.HandleFunc("GET /api/v1/orgs", s.authMiddleware(service.ScopeSnippetsRead, s.handleListOrganizations))
+	mux.HandleFunc("GET /api/v1/orgs/{OrgID}", s.authMiddleware(service.ScopeSnippetsRead, s.handleGetOrganization))
+	mux.HandleFunc("PATCH /api/v1/orgs/{OrgID}", s.authMiddleware(service.ScopeAdmin, s.handleUpdateOrganization))
+	mux.HandleFunc("DELETE /api/v1/orgs/{OrgID}", s.authMiddleware(service.ScopeAdmin, s.handleDeleteOrganization))
+	mux.HandleFunc("GET /api/v1/orgs/{OrgID}/members", s.authMiddleware(service.ScopeSnippetsRead, s.handleListOrganizationMembers))
+	mux.HandleFunc("POST /api/v1/orgs/{OrgID}/members", s.authMiddleware(service.ScopeAdmin, s.handleAddOrganizationMember))
+	mux.HandleFunc("PATCH /api/v1/orgs/{OrgID}/members/{UserID}", s.authMiddleware(service.ScopeAdmin, s.handleUpdateOrganizationMember))
+	mux.HandleFunc("DELETE /api/v1/orgs/{OrgID}/members/{UserID}", s.authMiddleware(service.ScopeAdmin, s.handleRemoveOrganizationMember))
+	mux.HandleFunc("GET /api/v1/orgs/{OrgID}/service-access-tokens", s.authMiddleware(service.ScopeTokensManage, s.handleListOrganizationServiceAccessTokens))
+
+	mux.HandleFunc("GET /api/v1/admin/users", s.authMiddleware(service.ScopeAdmin, s.requirePermission(service.PermUsersManage, s.handleAdminListUsers)))
+	mux.HandleFunc("PATCH /api/v1/admin/users/{UserID}", s.authMiddleware(service.ScopeAdmin, s.requirePermission(service.PermUsersManage, s.handleAdminUpdateUserRole)))
+	mux.HandleFunc("POST /api/v1/admin/users/{UserID}/disable", s.authMiddleware(service.ScopeAdmin, s.requirePermission(service.PermUsersManage, s.handleAdminDisableUser)))
+	mux.HandleFunc("POST /api/v1/admin/users/{UserID}/enable", s.authMiddleware(service.ScopeAdmin, s.requirePermission(service.PermUsersManage, s.handleAdminEnableUser)))
+	mux.HandleFunc("POST /api/v1/admin/users/{UserID}/logout", s.authMiddleware(service.ScopeAdmin, s.requirePermission(service.PermUsersManage, s.handleAdminForceLogout)))
+	mux.HandleFunc("GET /api/v1/admin/snippets/orphaned", s.authMiddleware(service.ScopeAdmin, s.requirePermission(service.PermSnippetsModerate, s.handleAdminListOrphanedSnippets)))
+	mux.HandleFunc("POST /api/v1/admin/snippets/{SnippetID}/take-over", s.authMiddleware(service.ScopeAdmin, s.requirePermission(service.PermSnippetsModerate, s.handleAdminTakeOverSnippet)))
+	mux.HandleFunc("GET /api/v1/audit", s.authMiddleware(service.ScopeAdmin, s.requirePermission(service.PermAuditRead, s.handleListAuditEvents)))
+
+	mux.HandleFunc("POST /auth/{provider}/login", s.rateLimitByIP("auth", limits.Auth, s.handleDeviceLogin))
+	mux.HandleFunc("POST /auth/{provider}/device/poll", s.rateLimitByIP("auth", limits.Auth, s.handleDevicePoll))
+	mux.HandleFunc("POST /auth/refresh", s.rateLimitByIP("auth", limits.Auth, s.handleTokenRotate))
+	mux.HandleFunc("POST /auth/logout", s.authMiddleware(service.ScopeAdmin, s.handleLogout))
+	mux.HandleFunc("GET /auth/sessions", s.authMiddleware(service.ScopeAdmin, s.handleListSessions))
+	mux.HandleFunc("DELETE /auth/sessions/{ID}", s.authMiddleware(service.ScopeAdmin, s.handleRevokeSession))
+	mux.HandleFunc("GET /auth/me", s.authMiddleware(service.ScopeAdmin, s.handleMe))
+	mux.HandleFunc("PATCH /auth/me", s.authMiddleware(service.ScopeAdmin, s.handleUpdateMe))
+	mux.HandleFunc("DELETE /auth/me", s.authMiddleware(service.ScopeAdmin, s.handleDeleteMe))
+	mux.HandleFunc("GET /auth/me/identities", s.authMiddleware(service.ScopeAdmin, s.handleListIdentities))
+	mux.HandleFunc("POST /auth/me/identities/{provider}", s.authMiddleware(service.ScopeAdmin, s.handleLinkIdentity))
+	mux.HandleFunc("DELETE /auth/me/identities/{provider}", s.authMiddleware(service.ScopeAdmin, s.handleUnlinkIdentity))
 
 	return &http.Server{
 		Addr:         cfg.Addr,
-		Handler:      mux,
+		Handler:      s.requestLogger(mux),
+		ErrorLog:     slog.NewLogLogger(log.Handler(), slog.LevelError),
 		ReadTimeout:  cfg.ReadTimeout,
 		WriteTimeout: cfg.WriteTimeout,
 	}
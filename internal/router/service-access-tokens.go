@@ -8,15 +8,17 @@ import (
 )
 
 // @Summary		Issue service access token
-// @Description	Creates a long-lived token for third-party integrations. The token secret is returned only at creation time.
+// @Description	Creates a long-lived token for third-party integrations. The token secret is returned only at creation time. Tokens for an organization require the admin role in it, only read and ingest snippets of that organization, and stop working once their creator is no longer an admin of it. A service access token can only grant scopes it has itself.
 // @Tags			service-access-tokens
 // @Accept			json
 // @Produce		json
-// @Param			request	body	CreateServiceAccessTokenRequest	true	"Token label and optional expiry"
+// @Param			request	body	CreateServiceAccessTokenRequest	true	"Token label, scopes and expiry"
 // @Security		BearerAuth
 // @Success		201	{object}	ServiceAccessToken
 // @Failure		400	{object}	ErrorResponse
 // @Failure		401	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
 // @Failure		500	{object}	ErrorResponse
 // @Router			/api/v1/service-access-tokens [post]
 func (s *server) handleCreateServiceAccessToken(w http.ResponseWriter, r *http.Request) {
@@ -26,20 +28,20 @@ func (s *server) handleCreateServiceAccessToken(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	userID, err := getUserIDFromCtx(r.Context())
+	principal, err := getPrincipalFromCtx(r.Context())
 	if err != nil {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
 
 	}
-	serviceTokenArgs, err := toServiceCreateServiceAccessToken(p, userID)
+	serviceTokenArgs, err := toServiceCreateServiceAccessToken(p, principal)
 	if err != nil {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 	t, err := s.service.CreateServceAccessToken(r.Context(), serviceTokenArgs)
 	if err != nil {
-		jsonError(w, http.StatusBadRequest, err.Error())
+		s.jsonServiceError(w, r, err)
 		return
 	}
 
@@ -74,36 +76,132 @@ func (s *server) handleGetServiceAccessTokens(w http.ResponseWriter, r *http.Req
 		Page:     pq.Page,
 		PageSize: pq.PageSize,
 	})
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toPage(toServiceAccessTokenSummaries(sl.Items), sl.Total, pq.Page, pq.PageSize))
+}
+
+// @Summary		List stale service access tokens
+// @Description	Returns the caller's tokens that were not used for unused_days or expire within expiring_days. Usage is stored periodically, so very recent uses can be missing.
+// @Tags			service-access-tokens
+// @Produce		json
+// @Param			unused_days		query	int	false	"Tokens not used for this many days"
+// @Param			expiring_days	query	int	false	"Tokens expiring within this many days"
+// @Param			page			query	int	false	"Page number"		default(1)
+// @Param			page_size		query	int	false	"Items per page"	default(20)
+// @Security		BearerAuth
+// @Success		200	{object}	ServiceAccessTokensPageResponse
+// @Failure		400	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/service-access-tokens/stale [get]
+func (s *server) handleListStaleServiceAccessTokens(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+	pq, err := toPageQuery(v)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	unusedDays, err := parseOptionalDays("unused_days", v.Get("unused_days"))
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	expiringDays, err := parseOptionalDays("expiring_days", v.Get("expiring_days"))
 	if err != nil {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	jsonResponse(w, http.StatusOK, toPage(sl.Items, sl.Total, pq.Page, pq.PageSize))
+	sl, err := s.service.ListStaleServiceAccessTokens(r.Context(), service.ListStaleServiceAccessTokensParam{
+		UserID:       userID,
+		UnusedDays:   unusedDays,
+		ExpiringDays: expiringDays,
+		Page: service.PageParam{
+			Page:     pq.Page,
+			PageSize: pq.PageSize,
+		},
+	})
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toPage(toServiceAccessTokenSummaries(sl.Items), sl.Total, pq.Page, pq.PageSize))
 }
 
 // @Summary		Revoke service access token
-// @Description	Revokes an existing service access token by ID.
+// @Description	Revokes a service access token of the caller, or of an organization the caller is an admin of. Revoked tokens are kept for auditing and stop authenticating immediately.
 // @Tags			service-access-tokens
 // @Param			ID path	int	true	"Service access token ID"
 // @Security		BearerAuth
 // @Success		204
 // @Failure		400	{object}	ErrorResponse
 // @Failure		401	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
 // @Failure		404	{object}	ErrorResponse
 // @Failure		500	{object}	ErrorResponse
 // @Router			/api/v1/service-access-tokens/{ID} [delete]
-func (s *server) handleDeleteServiceAccessToken(w http.ResponseWriter, r *http.Request) {
+func (s *server) handleRevokeServiceAccessToken(w http.ResponseWriter, r *http.Request) {
 	tokenID, err := strconv.ParseInt(r.PathValue("ID"), 10, 64)
 	if err != nil {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	if err := s.service.DeleteServiceAccessToken(r.Context(), tokenID); err != nil {
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := s.service.RevokeServiceAccessToken(r.Context(), tokenID, userID); err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary		Rotate service access token
+// @Description	Issues a new secret for the token with the same name, scopes and expiry. The previous secret keeps working for a configured grace period. The new secret is returned only once.
+// @Tags			service-access-tokens
+// @Produce		json
+// @Param			ID path	int	true	"Service access token ID"
+// @Security		BearerAuth
+// @Success		200	{object}	ServiceAccessToken
+// @Failure		400	{object}	ErrorResponse
+// @Failure		401	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/service-access-tokens/{ID}/rotate [post]
+func (s *server) handleRotateServiceAccessToken(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := strconv.ParseInt(r.PathValue("ID"), 10, 64)
+	if err != nil {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	principal, err := getPrincipalFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	t, err := s.service.RotateServiceAccessToken(r.Context(), tokenID, principal)
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
 
-	jsonResponse(w, http.StatusCreated, nil)
+	jsonResponse(w, http.StatusOK, toServiceAccessToken(t))
 }
@@ -0,0 +1,119 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/beavercli/beaver_api/internal/service"
+)
+
+// @Summary		List snippet revisions
+// @Description	Returns a paginated list of revisions of a snippet, newest first. A revision is recorded every time an ingest changes the snippet.
+// @Tags			snippets
+// @Produce		json
+// @Param			SnippetID	path	int	true	"Snippet ID"
+// @Param			page		query	int	false	"Page number"		default(1)
+// @Param			page_size	query	int	false	"Items per page"	default(20)
+// @Security		BearerAuth
+// @Success		200	{object}	SnippetRevisionsPageResponse
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/snippets/{SnippetID}/revisions [get]
+func (s *server) handleListSnippetRevisions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("SnippetID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err := toPageQuery(r.URL.Query())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	revs, err := s.service.GetSnippetRevisionsPage(r.Context(), id, getViewerIDFromCtx(r.Context()), service.PageParam{
+		Page:     p.Page,
+		PageSize: p.PageSize,
+	})
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toPage(toSnippetRevisionSummaries(revs.Items), revs.Total, p.Page, p.PageSize))
+}
+
+// @Summary		Get snippet revision
+// @Description	Returns the full content of a snippet at the given revision
+// @Tags			snippets
+// @Produce		json
+// @Param			SnippetID	path	int	true	"Snippet ID"
+// @Param			Revision	path	int	true	"Revision number"
+// @Security		BearerAuth
+// @Success		200	{object}	SnippetRevision
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/snippets/{SnippetID}/revisions/{Revision} [get]
+func (s *server) handleGetSnippetRevision(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("SnippetID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rev, err := parseRevision(r.PathValue("Revision"))
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sr, err := s.service.GetSnippetRevision(r.Context(), id, rev, getViewerIDFromCtx(r.Context()))
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toSnippetRevision(sr))
+}
+
+// @Summary		Diff snippet revisions
+// @Description	Returns a unified diff of the snippet code between two revisions. Revisions of more than 10000 lines cannot be diffed.
+// @Tags			snippets
+// @Produce		json
+// @Param			SnippetID	path	int	true	"Snippet ID"
+// @Param			from		query	int	true	"Base revision"
+// @Param			to			query	int	true	"Target revision"
+// @Security		BearerAuth
+// @Success		200	{object}	SnippetDiff
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		422	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/snippets/{SnippetID}/revisions/diff [get]
+func (s *server) handleDiffSnippetRevisions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("SnippetID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	v := r.URL.Query()
+	from, err := parseRevision(v.Get("from"))
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, "from: "+err.Error())
+		return
+	}
+	to, err := parseRevision(v.Get("to"))
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, "to: "+err.Error())
+		return
+	}
+
+	d, err := s.service.DiffSnippetRevisions(r.Context(), id, from, to, getViewerIDFromCtx(r.Context()))
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toSnippetDiff(d))
+}
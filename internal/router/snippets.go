@@ -1,6 +1,7 @@
 package router
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -8,7 +9,7 @@ import (
 )
 
 // @Summary		Get snippet by ID
-// @Description	Returns a code snippet by its ID
+// @Description	Returns a code snippet by its ID. Private snippets are only visible to their owner.
 // @Tags			snippets
 // @Produce		json
 // @Param			SnippetID	path	int	true	"Snippet ID"
@@ -25,9 +26,13 @@ func (s *server) handleGetSnippet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	snippet, err := s.service.GetSnippet(r.Context(), id)
+	snippet, err := s.service.GetSnippet(r.Context(), id, getViewerIDFromCtx(r.Context()))
 	if err != nil {
-		jsonError(w, http.StatusBadRequest, err.Error())
+		s.jsonServiceError(w, r, err)
+		return
+	}
+	if orgID := tokenOrganizationID(r.Context()); orgID != 0 && snippet.OrganizationID != orgID {
+		s.jsonServiceError(w, r, fmt.Errorf("snippet %d: %w", id, service.ErrNotFound))
 		return
 	}
 
@@ -35,15 +40,20 @@ func (s *server) handleGetSnippet(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary		List snippets
-// @Description	Returns a paginated list of snippets with tags and languages
+// @Description	Returns a paginated list of snippets visible to the caller with tags and languages. When q is set, results are ranked by relevance and include a highlighted code fragment.
 // @Tags			snippets
 // @Produce		json
 // @Param			page		query	int		false	"Page number"		default(1)
 // @Param			page_size	query	int		false	"Items per page"	default(20)
 // @Param			language_id	query	int		false	"Filter by language ID"
+// @Param			organization_id	query	int	false	"Filter by organization ID"
 // @Param			tag_id		query	[]int	false	"Filter by tag IDs (repeat: tag_id=1&tag_id=2)"	collectionFormat(multi)
+// @Param			q			query	string	false	"Full-text search over snippet title and code"
+// @Param			fuzzy		query	bool	false	"Match q against titles by trigram similarity (tolerates typos)"
 // @Security		BearerAuth
 // @Success		200	{object}	SnippetsPageResponse
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
 // @Failure		500	{object}	ErrorResponse
 // @Router			/api/v1/snippets [get]
 func (s *server) handleListSnippets(w http.ResponseWriter, r *http.Request) {
@@ -59,17 +69,26 @@ func (s *server) handleListSnippets(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	f.OrganizationID, err = limitToTokenOrganization(r.Context(), f.OrganizationID)
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
 
 	snippetList, err := s.service.GetSnippetsPage(r.Context(), service.ListSnippetsParams{
 		PageParam: service.PageParam{
 			Page:     p.Page,
 			PageSize: p.PageSize,
 		},
-		LanguageID: f.LanguageID,
-		TagIDs:     f.TagIDs,
+		ViewerID:       getViewerIDFromCtx(r.Context()),
+		OrganizationID: f.OrganizationID,
+		LanguageID:     f.LanguageID,
+		TagIDs:         f.TagIDs,
+		Query:          f.Query,
+		Fuzzy:          f.Fuzzy,
 	})
 	if err != nil {
-		jsonError(w, http.StatusBadRequest, err.Error())
+		s.jsonServiceError(w, r, err)
 		return
 	}
 
@@ -78,8 +97,40 @@ func (s *server) handleListSnippets(w http.ResponseWriter, r *http.Request) {
 	jsonResponse(w, http.StatusOK, snippetPage)
 }
 
+// @Summary		Get public snippet by ID
+// @Description	Returns a public code snippet without authentication
+// @Tags			public
+// @Produce		json
+// @Param			SnippetID	path	int	true	"Snippet ID"
+// @Success		200	{object}	Snippet
+// @Failure		400	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/public/snippets/{SnippetID} [get]
+func (s *server) handleGetPublicSnippet(w http.ResponseWriter, r *http.Request) {
+	s.handleGetSnippet(w, r)
+}
+
+// @Summary		List public snippets
+// @Description	Returns a paginated list of public snippets without authentication. Accepts the same filters as the authenticated list.
+// @Tags			public
+// @Produce		json
+// @Param			page		query	int		false	"Page number"		default(1)
+// @Param			page_size	query	int		false	"Items per page"	default(20)
+// @Param			language_id	query	int		false	"Filter by language ID"
+// @Param			organization_id	query	int	false	"Filter by organization ID"
+// @Param			tag_id		query	[]int	false	"Filter by tag IDs (repeat: tag_id=1&tag_id=2)"	collectionFormat(multi)
+// @Param			q			query	string	false	"Full-text search over snippet title and code"
+// @Param			fuzzy		query	bool	false	"Match q against titles by trigram similarity (tolerates typos)"
+// @Success		200	{object}	SnippetsPageResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/public/snippets [get]
+func (s *server) handleListPublicSnippets(w http.ResponseWriter, r *http.Request) {
+	s.handleListSnippets(w, r)
+}
+
 // @Summary		Create snippet
-// @Description	Creates a new code snippet
+// @Description	Creates a new code snippet, or updates the snippet of the same repo file. Only the owner of that snippet can update it, and not while it is in the trash.
 // @Tags			snippets
 // @Accept			json
 // @Produce		json
@@ -87,6 +138,9 @@ func (s *server) handleListSnippets(w http.ResponseWriter, r *http.Request) {
 // @Security		BearerAuth
 // @Success		201
 // @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		409	{object}	ErrorResponse
 // @Failure		500	{object}	ErrorResponse
 // @Router			/api/v1/snippets [post]
 func (s *server) handleIngestSnippet(w http.ResponseWriter, r *http.Request) {
@@ -103,9 +157,91 @@ func (s *server) handleIngestSnippet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.service.IngestSnippet(r.Context(), toCreateSnippetParams(p, userID)); err != nil {
+	csp, err := toCreateSnippetParams(p, userID)
+	if err != nil {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	csp.OrganizationID, err = limitToTokenOrganization(r.Context(), csp.OrganizationID)
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	if err := s.service.IngestSnippet(r.Context(), csp); err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
 	w.WriteHeader(http.StatusCreated)
 }
+
+// @Summary		Update snippet
+// @Description	Partially updates a snippet. Only the owner of the snippet, a maintainer or an admin can update it, and only the owner can change its visibility.
+// @Tags			snippets
+// @Accept			json
+// @Produce		json
+// @Param			SnippetID	path	int						true	"Snippet ID"
+// @Param			body		body	UpdateSnippetRequest	true	"Fields to update"
+// @Security		BearerAuth
+// @Success		200	{object}	Snippet
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/snippets/{SnippetID} [patch]
+func (s *server) handleUpdateSnippet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("SnippetID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	p, err := toUpdateSnippetRequestBody(r)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	snippet, err := s.service.UpdateSnippet(r.Context(), toUpdateSnippetParams(p, id, userID))
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toSnippet(snippet))
+}
+
+// @Summary		Delete snippet
+// @Description	Moves a snippet to the trash. Only the owner of the snippet can delete it.
+// @Tags			snippets
+// @Param			SnippetID	path	int	true	"Snippet ID"
+// @Security		BearerAuth
+// @Success		204
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/snippets/{SnippetID} [delete]
+func (s *server) handleDeleteSnippet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("SnippetID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := s.service.DeleteSnippet(r.Context(), id, userID); err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
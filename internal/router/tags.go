@@ -7,11 +7,12 @@ import (
 )
 
 // @Summary		List tags
-// @Description	Returns a paginated list of tags
+// @Description	Returns a paginated list of tags. When q is set, tags are matched by trigram similarity and ordered by score.
 // @Tags			tags
 // @Produce		json
-// @Param			page		query	int	false	"Page number"		default(1)
-// @Param			page_size	query	int	false	"Items per page"	default(20)
+// @Param			page		query	int		false	"Page number"		default(1)
+// @Param			page_size	query	int		false	"Items per page"	default(20)
+// @Param			q			query	string	false	"Fuzzy search over tag names"
 // @Security		BearerAuth
 // @Success		200	{object}	TagsPageResponse
 // @Failure		500	{object}	ErrorResponse
@@ -23,14 +24,22 @@ func (s *server) handleListTags(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	tags, err := s.service.GetTagsPage(r.Context(), service.PageParam{
-		Page:     page.Page,
-		PageSize: page.PageSize,
-	})
+	f, err := toTagListFilterArg(query)
 	if err != nil {
 		jsonError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	tags, err := s.service.GetTagsPage(r.Context(), service.ListTagsParams{
+		PageParam: service.PageParam{
+			Page:     page.Page,
+			PageSize: page.PageSize,
+		},
+		Query: f.Query,
+	})
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
 
 	jsonResponse(w, http.StatusOK, toPage(toTags(tags.Items), tags.Total, page.Page, page.PageSize))
 }
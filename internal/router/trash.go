@@ -0,0 +1,76 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/beavercli/beaver_api/internal/service"
+)
+
+// @Summary		List trash
+// @Description	Returns a paginated list of the caller's deleted snippets. They are purged for good once the retention period is over.
+// @Tags			snippets
+// @Produce		json
+// @Param			page		query	int	false	"Page number"		default(1)
+// @Param			page_size	query	int	false	"Items per page"	default(20)
+// @Security		BearerAuth
+// @Success		200	{object}	TrashPageResponse
+// @Failure		400	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/trash [get]
+func (s *server) handleListTrash(w http.ResponseWriter, r *http.Request) {
+	p, err := toPageQuery(r.URL.Query())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	trash, err := s.service.GetTrashPage(r.Context(), userID, service.PageParam{
+		Page:     p.Page,
+		PageSize: p.PageSize,
+	})
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toPage(toTrashedSnippets(trash.Items), trash.Total, p.Page, p.PageSize))
+}
+
+// @Summary		Restore snippet
+// @Description	Moves a deleted snippet out of the trash. Only the owner of the snippet can restore it.
+// @Tags			snippets
+// @Produce		json
+// @Param			SnippetID	path	int	true	"Snippet ID"
+// @Security		BearerAuth
+// @Success		200	{object}	Snippet
+// @Failure		400	{object}	ErrorResponse
+// @Failure		403	{object}	ErrorResponse
+// @Failure		404	{object}	ErrorResponse
+// @Failure		500	{object}	ErrorResponse
+// @Router			/api/v1/snippets/{SnippetID}/restore [post]
+func (s *server) handleRestoreSnippet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("SnippetID"), 10, 64)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := getUserIDFromCtx(r.Context())
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	snippet, err := s.service.RestoreSnippet(r.Context(), id, userID)
+	if err != nil {
+		s.jsonServiceError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, toSnippet(snippet))
+}
@@ -3,11 +3,15 @@ package router
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/beavercli/beaver_api/internal/service"
 )
@@ -24,18 +28,40 @@ func jsonError(w http.ResponseWriter, status int, message string) {
 	json.NewEncoder(w).Encode(ErrorResponse{Error: message})
 }
 
+// jsonServiceError maps the service error kinds to their HTTP status codes,
+// any other error is logged and answered with a generic 500.
+func (s *server) jsonServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		jsonError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, service.ErrForbidden):
+		jsonError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, service.ErrConflict):
+		jsonError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrTooLarge):
+		jsonError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, service.ErrInvalid):
+		jsonError(w, http.StatusBadRequest, err.Error())
+	default:
+		s.log.ErrorContext(r.Context(), "request failed", "error", err)
+		jsonError(w, http.StatusInternalServerError, "internal server error")
+	}
+}
+
 func toSnippet(s service.Snippet) Snippet {
 	return Snippet{
-		ID:           strconv.FormatInt(s.ID, 10),
-		Title:        s.Title,
-		Code:         s.Code,
-		Git:          toGit(s.Git),
-		GitPath:      s.GitPath,
-		GitVersion:   s.GitVersion,
-		ProjectURL:   s.ProjectURL,
-		Language:     toLanguage(s.Language),
-		Tags:         toTags(s.Tags),
-		Contributors: toContributors(s.Contributors),
+		ID:             strconv.FormatInt(s.ID, 10),
+		Title:          s.Title,
+		Code:           s.Code,
+		Git:            toGit(s.Git),
+		GitPath:        s.GitPath,
+		GitVersion:     s.GitVersion,
+		ProjectURL:     s.ProjectURL,
+		Visibility:     string(s.Visibility),
+		OrganizationID: formatOptionalID(s.OrganizationID),
+		Language:       toLanguage(s.Language),
+		Tags:           toTags(s.Tags),
+		Contributors:   toContributors(s.Contributors),
 	}
 }
 
@@ -80,8 +106,9 @@ func toLanguages(ls []service.Language) []Language {
 
 func toTag(t service.Tag) Tag {
 	return Tag{
-		ID:   strconv.FormatInt(t.ID, 10),
-		Name: t.Name,
+		ID:         strconv.FormatInt(t.ID, 10),
+		Name:       t.Name,
+		Similarity: t.Similarity,
 	}
 }
 
@@ -95,14 +122,19 @@ func toTags(ts []service.Tag) []Tag {
 
 func toSnippetSummary(s service.SnippetSummary) SnippetSummary {
 	return SnippetSummary{
-		ID:         strconv.FormatInt(s.ID, 10),
-		Title:      s.Title,
-		ProjectURL: s.ProjectURL,
-		GitPath:    s.GitPath,
-		GitVersion: s.GitVersion,
-		Git:        toGit(s.Git),
-		Language:   toLanguage(s.Language),
-		Tags:       toTags(s.Tags),
+		ID:             strconv.FormatInt(s.ID, 10),
+		Title:          s.Title,
+		ProjectURL:     s.ProjectURL,
+		GitPath:        s.GitPath,
+		GitVersion:     s.GitVersion,
+		Visibility:     string(s.Visibility),
+		OrganizationID: formatOptionalID(s.OrganizationID),
+		Git:            toGit(s.Git),
+		Language:       toLanguage(s.Language),
+		Tags:           toTags(s.Tags),
+		Rank:           s.Rank,
+		Similarity:     s.Similarity,
+		Highlight:      s.Highlight,
 	}
 }
 
@@ -115,6 +147,11 @@ func toSnippetSummaries(ss []service.SnippetSummary) []SnippetSummary {
 }
 
 func toSnippetListFilterArg(v url.Values) (SnippetListFilterArg, error) {
+	orgID, err := parseOptionalID("organization_id", v.Get("organization_id"))
+	if err != nil {
+		return SnippetListFilterArg{}, err
+	}
+
 	var langID *int64
 	if raw := v.Get("language_id"); raw != "" {
 		val, err := strconv.ParseInt(raw, 10, 64)
@@ -140,12 +177,47 @@ func toSnippetListFilterArg(v url.Values) (SnippetListFilterArg, error) {
 		tags = append(tags, val)
 	}
 
+	query, err := toSearchQuery(v)
+	if err != nil {
+		return SnippetListFilterArg{}, err
+	}
+
+	var fuzzy bool
+	if raw := v.Get("fuzzy"); raw != "" {
+		fuzzy, err = strconv.ParseBool(raw)
+		if err != nil {
+			return SnippetListFilterArg{}, fmt.Errorf("fuzzy: %w", err)
+		}
+		if fuzzy && query == "" {
+			return SnippetListFilterArg{}, fmt.Errorf("fuzzy requires q")
+		}
+	}
+
 	return SnippetListFilterArg{
-		LanguageID: langID,
-		TagIDs:     tags,
+		OrganizationID: orgID,
+		LanguageID:     langID,
+		TagIDs:         tags,
+		Query:          query,
+		Fuzzy:          fuzzy,
 	}, nil
 }
 
+func toTagListFilterArg(v url.Values) (TagListFilterArg, error) {
+	query, err := toSearchQuery(v)
+	if err != nil {
+		return TagListFilterArg{}, err
+	}
+	return TagListFilterArg{Query: query}, nil
+}
+
+func toSearchQuery(v url.Values) (string, error) {
+	query := strings.TrimSpace(v.Get("q"))
+	if len(query) > maxSearchQueryLen {
+		return "", fmt.Errorf("q must be <=%d characters", maxSearchQueryLen)
+	}
+	return query, nil
+}
+
 func toCreateSnippetRequestBody(r *http.Request) (IngestSnippetRequest, error) {
 	defer r.Body.Close()
 
@@ -159,35 +231,85 @@ func toCreateSnippetRequestBody(r *http.Request) (IngestSnippetRequest, error) {
 	return p, nil
 }
 
-func toCreateSnippetParams(sr IngestSnippetRequest, userID int64) service.CreateSnippetParam {
-	ts := make([]service.CreateTagParam, len(sr.Tags))
-	for i, t := range sr.Tags {
+func toCreateTagParams(tags []CreateTagRequest) []service.CreateTagParam {
+	ts := make([]service.CreateTagParam, len(tags))
+	for i, t := range tags {
 		ts[i] = service.CreateTagParam{
 			Name: t.Name,
 		}
 	}
+	return ts
+}
 
-	cs := make([]service.CreateContributorParam, len(sr.Contributors))
-	for i, c := range sr.Contributors {
+func toCreateContributorParams(contributors []CreateContributorRequest) []service.CreateContributorParam {
+	cs := make([]service.CreateContributorParam, len(contributors))
+	for i, c := range contributors {
 		cs[i] = service.CreateContributorParam{
 			FirstName: c.FirstName,
 			LastName:  c.LastName,
 			Email:     c.Email,
 		}
 	}
+	return cs
+}
+
+func toCreateSnippetParams(sr IngestSnippetRequest, userID int64) (service.CreateSnippetParam, error) {
+	orgID, err := parseOptionalID("organization_id", sr.OrganizationID)
+	if err != nil {
+		return service.CreateSnippetParam{}, err
+	}
+	ts := toCreateTagParams(sr.Tags)
+	cs := toCreateContributorParams(sr.Contributors)
 
 	return service.CreateSnippetParam{
-		Title:        sr.Title,
-		Code:         sr.Code,
-		ProjectURL:   sr.ProjectURL,
-		Git:          service.CreateGitParam{URL: sr.Git.URL},
-		GitPath:      sr.GitPath,
-		GitVersion:   sr.GitVersion,
-		UserID:       userID,
-		Language:     service.CreateLanguageParam{Name: sr.Language.Name},
-		Tags:         ts,
-		Contributors: cs,
+		Title:          sr.Title,
+		Code:           sr.Code,
+		ProjectURL:     sr.ProjectURL,
+		Git:            service.CreateGitParam{URL: sr.Git.URL},
+		GitPath:        sr.GitPath,
+		GitVersion:     sr.GitVersion,
+		Visibility:     service.Visibility(sr.Visibility),
+		UserID:         userID,
+		OrganizationID: orgID,
+		Language:       service.CreateLanguageParam{Name: sr.Language.Name},
+		Tags:           ts,
+		Contributors:   cs,
+	}, nil
+}
+
+func toUpdateSnippetRequestBody(r *http.Request) (UpdateSnippetRequest, error) {
+	defer r.Body.Close()
+
+	var p UpdateSnippetRequest
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	if err := d.Decode(&p); err != nil {
+		return UpdateSnippetRequest{}, err
+	}
+	return p, nil
+}
+
+func toUpdateSnippetParams(ur UpdateSnippetRequest, snippetID, userID int64) service.UpdateSnippetParam {
+	p := service.UpdateSnippetParam{
+		ID:     snippetID,
+		UserID: userID,
+		Title:  ur.Title,
+		Code:   ur.Code,
+	}
+	if ur.Visibility != nil {
+		v := service.Visibility(*ur.Visibility)
+		p.Visibility = &v
 	}
+	if ur.Tags != nil {
+		ts := toCreateTagParams(*ur.Tags)
+		p.Tags = &ts
+	}
+	if ur.Contributors != nil {
+		cs := toCreateContributorParams(*ur.Contributors)
+		p.Contributors = &cs
+	}
+	return p
 }
 
 func toDeviceOAuth(r service.OAuthRedirect) DeviceOAuth {
@@ -200,15 +322,15 @@ func toDeviceOAuth(r service.OAuthRedirect) DeviceOAuth {
 	}
 }
 
-func toGithubPullRequest(r *http.Request) (GithubPullRequest, error) {
+func toDevicePollRequest(r *http.Request) (DevicePollRequest, error) {
 	defer r.Body.Close()
 
-	var p GithubPullRequest
+	var p DevicePollRequest
 	d := json.NewDecoder(r.Body)
 	d.DisallowUnknownFields()
 
 	if err := d.Decode(&p); err != nil {
-		return GithubPullRequest{}, err
+		return DevicePollRequest{}, err
 	}
 	return p, nil
 }
@@ -253,6 +375,35 @@ func toDeviceAuthResult(ar service.DeviceAuthResult) DeviceAuthResult {
 	}
 }
 
+func toIdentity(i service.Identity) Identity {
+	return Identity{
+		Provider:  i.Provider,
+		Username:  i.Username,
+		Email:     i.Email,
+		CreatedAt: i.CreatedAt.String(),
+	}
+}
+
+func toIdentities(is []service.Identity) []Identity {
+	identities := make([]Identity, len(is))
+	for i, ident := range is {
+		identities[i] = toIdentity(ident)
+	}
+	return identities
+}
+
+func toDeviceLinkResult(lr service.DeviceLinkResult) DeviceLinkResult {
+	var identity *Identity
+	if lr.Identity != nil {
+		i := toIdentity(*lr.Identity)
+		identity = &i
+	}
+	return DeviceLinkResult{
+		Status:   DeviceAuthStatus(lr.Status),
+		Identity: identity,
+	}
+}
+
 func getUserIDFromCtx(ctx context.Context) (int64, error) {
 	v := ctx.Value(UserContextKey)
 
@@ -264,6 +415,79 @@ func getUserIDFromCtx(ctx context.Context) (int64, error) {
 	return userID, nil
 }
 
+// getSessionIDFromCtx returns the login session of the access token the
+// request was authenticated with.
+func getPrincipalFromCtx(ctx context.Context) (service.Principal, error) {
+	p, ok := ctx.Value(PrincipalContextKey).(service.Principal)
+	if !ok {
+		return service.Principal{}, fmt.Errorf("Principal is not found in the context")
+	}
+	return p, nil
+}
+
+func getSessionIDFromCtx(ctx context.Context) (int64, error) {
+	p, ok := ctx.Value(PrincipalContextKey).(service.Principal)
+	if !ok || p.SessionID == 0 {
+		return 0, fmt.Errorf("The request is not authenticated with a login session")
+	}
+	return p.SessionID, nil
+}
+
+// tokenOrganizationID returns the organization an organization service access
+// token is limited to, 0 for every other caller.
+func tokenOrganizationID(ctx context.Context) int64 {
+	p, _ := ctx.Value(PrincipalContextKey).(service.Principal)
+	return p.OrganizationID
+}
+
+// limitToTokenOrganization narrows orgID to the organization of the token the
+// request is authenticated with. Asking for another organization is forbidden.
+func limitToTokenOrganization(ctx context.Context, orgID *int64) (*int64, error) {
+	tokenOrgID := tokenOrganizationID(ctx)
+	if tokenOrgID == 0 {
+		return orgID, nil
+	}
+	if orgID != nil && *orgID != tokenOrgID {
+		return nil, fmt.Errorf("the token is limited to organization %d: %w", tokenOrgID, service.ErrForbidden)
+	}
+	return &tokenOrgID, nil
+}
+
+// clientInfo describes the device a request comes from. The IP is the peer
+// address, proxies in front of the API are not taken into account. The device
+// name and user agent are cut to the length they are stored with.
+func clientInfo(r *http.Request, deviceName string) service.ClientInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return service.ClientInfo{
+		DeviceName: clientText(deviceName, maxDeviceNameLen),
+		UserAgent:  clientText(r.UserAgent(), maxUserAgentLen),
+		IP:         ip,
+	}
+}
+
+// clientText cuts s to at most n characters and drops what Postgres does not
+// store in text columns: invalid UTF-8 and NUL characters.
+func clientText(s string, n int) string {
+	s = strings.ReplaceAll(strings.ToValidUTF8(s, "\uFFFD"), "\x00", "")
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// getViewerIDFromCtx returns the authenticated user ID, or nil on routes that
+// are served without authMiddleware.
+func getViewerIDFromCtx(ctx context.Context) *int64 {
+	userID, ok := ctx.Value(UserContextKey).(int64)
+	if !ok {
+		return nil
+	}
+	return &userID
+}
+
 func getCreateServiceAccessTokenRequest(r *http.Request) (CreateServiceAccessTokenRequest, error) {
 	defer r.Body.Close()
 
@@ -277,23 +501,331 @@ func getCreateServiceAccessTokenRequest(r *http.Request) (CreateServiceAccessTok
 	return p, nil
 }
 
-func toServiceCreateServiceAccessToken(csat CreateServiceAccessTokenRequest, userID int64) (service.CreateServiceAccessTokenArgs, error) {
+// decodeJSONBody decodes a request body and rejects unknown fields.
+func decodeJSONBody[T any](r *http.Request) (T, error) {
+	defer r.Body.Close()
+
+	var p T
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&p); err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return p, nil
+}
+
+func toServiceCreateServiceAccessToken(csat CreateServiceAccessTokenRequest, grantor service.Principal) (service.CreateServiceAccessTokenArgs, error) {
 	if csat.ExpiresAt.Before(time.Now()) {
 		return service.CreateServiceAccessTokenArgs{}, fmt.Errorf("Invalid ExpiresAt value. The cannot be earlier than now")
 	}
 
+	orgID, err := parseOptionalID("organization_id", csat.OrganizationID)
+	if err != nil {
+		return service.CreateServiceAccessTokenArgs{}, err
+	}
+
+	scopes := make([]service.Scope, len(csat.Scopes))
+	for i, sc := range csat.Scopes {
+		scopes[i] = service.Scope(sc)
+	}
+
 	return service.CreateServiceAccessTokenArgs{
-		UserID:    userID,
-		Name:      csat.Name,
-		ExpiresAt: csat.ExpiresAt.Sub(time.Now()),
+		UserID:         grantor.UserID,
+		OrganizationID: orgID,
+		Name:           csat.Name,
+		ExpiresAt:      csat.ExpiresAt.Sub(time.Now()),
+		Scopes:         scopes,
+		Grantor:        grantor,
 	}, nil
 }
 func toServiceAccessToken(st service.ServiceAccessToken) ServiceAccessToken {
 	return ServiceAccessToken{
-		ID:        strconv.FormatInt(st.ID, 10),
-		Name:      st.Name,
-		Token:     st.Token,
-		ExpiresAt: st.ExpiresAt.String(),
-		CreatedAt: st.IssuedAT.String(),
+		ID:             strconv.FormatInt(st.ID, 10),
+		Name:           st.Name,
+		Token:          st.Token,
+		OrganizationID: formatOptionalID(st.OrganizationID),
+		Scopes:         fromScopes(st.Scopes),
+		ExpiresAt:      st.ExpiresAt.String(),
+		CreatedAt:      st.IssuedAT.String(),
+	}
+}
+
+func toServiceAccessTokenSummaries(ts []service.ServiceAccessTokenSum) []ServiceAccessTokenSummary {
+	tokens := make([]ServiceAccessTokenSummary, len(ts))
+	for i, t := range ts {
+		tokens[i] = ServiceAccessTokenSummary{
+			ID:             strconv.FormatInt(t.ID, 10),
+			Name:           t.Name,
+			OrganizationID: formatOptionalID(t.OrganizationID),
+			Scopes:         fromScopes(t.Scopes),
+			ExpiresAt:      t.ExpiresAt.String(),
+			CreatedAt:      t.IssuedAT.String(),
+			LastUsedAt:     formatOptionalTime(t.LastUsedAt),
+			LastUsedIP:     t.LastUsedIP,
+			UsageCount:     t.UsageCount,
+		}
+	}
+	return tokens
+}
+
+// parseOptionalDays parses a positive number of days, an empty value is 0.
+func parseOptionalDays(name, raw string) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	days, err := strconv.Atoi(raw)
+	if err != nil || days <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+	return days, nil
+}
+
+func fromScopes(scopes []service.Scope) []string {
+	ss := make([]string, len(scopes))
+	for i, sc := range scopes {
+		ss[i] = string(sc)
+	}
+	return ss
+}
+
+func toUserProfile(u service.UserProfile) UserProfile {
+	return UserProfile{
+		ID:              strconv.FormatInt(u.ID, 10),
+		Username:        u.Username,
+		Email:           u.Email,
+		DisplayName:     u.DisplayName,
+		DefaultLanguage: u.DefaultLanguage,
+		Providers:       u.Providers,
+		CreatedAt:       u.CreatedAt.String(),
+	}
+}
+
+func toDeleteAccountParams(dr DeleteAccountRequest, userID int64) (service.DeleteAccountParam, error) {
+	orgID, err := parseOptionalID("transfer_to_organization_id", dr.TransferToOrganizationID)
+	if err != nil {
+		return service.DeleteAccountParam{}, err
+	}
+	return service.DeleteAccountParam{
+		UserID:                   userID,
+		Snippets:                 service.SnippetDisposition(dr.Snippets),
+		TransferToUsername:       dr.TransferToUsername,
+		TransferToOrganizationID: orgID,
+	}, nil
+}
+
+func toDeviceSessions(ss []service.DeviceSession, currentID int64) []DeviceSession {
+	sessions := make([]DeviceSession, len(ss))
+	for i, s := range ss {
+		sessions[i] = DeviceSession{
+			ID:         strconv.FormatInt(s.ID, 10),
+			DeviceName: s.DeviceName,
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			Current:    s.ID == currentID,
+			CreatedAt:  s.CreatedAt.String(),
+			LastUsedAt: s.LastUsedAt.String(),
+		}
+	}
+	return sessions
+}
+
+func toAdminUser(u service.AdminUser) AdminUser {
+	au := AdminUser{
+		ID:        strconv.FormatInt(u.ID, 10),
+		Username:  u.Username,
+		Email:     u.Email,
+		Role:      string(u.Role),
+		CreatedAt: u.CreatedAt.String(),
+	}
+	if u.DisabledAt != nil {
+		au.DisabledAt = u.DisabledAt.String()
+	}
+	return au
+}
+
+func toAdminUsers(us []service.AdminUser) []AdminUser {
+	users := make([]AdminUser, len(us))
+	for i, u := range us {
+		users[i] = toAdminUser(u)
+	}
+	return users
+}
+
+func toOrphanedSnippets(ss []service.OrphanedSnippet) []OrphanedSnippet {
+	snippets := make([]OrphanedSnippet, len(ss))
+	for i, s := range ss {
+		snippets[i] = OrphanedSnippet{
+			ID:             strconv.FormatInt(s.ID, 10),
+			Title:          s.Title,
+			OrganizationID: formatOptionalID(s.OrganizationID),
+			CreatedAt:      s.CreatedAt.String(),
+		}
+	}
+	return snippets
+}
+
+func toOrganization(o service.Organization) Organization {
+	return Organization{
+		ID:        strconv.FormatInt(o.ID, 10),
+		Name:      o.Name,
+		Role:      string(o.Role),
+		CreatedAt: o.CreatedAt.String(),
+	}
+}
+
+func toOrganizations(os []service.Organization) []Organization {
+	orgs := make([]Organization, len(os))
+	for i, o := range os {
+		orgs[i] = toOrganization(o)
+	}
+	return orgs
+}
+
+func toOrganizationMembers(ms []service.OrganizationMember) []OrganizationMember {
+	members := make([]OrganizationMember, len(ms))
+	for i, m := range ms {
+		members[i] = OrganizationMember{
+			User:     toUser(m.User),
+			Role:     string(m.Role),
+			JoinedAt: m.JoinedAt.String(),
+		}
+	}
+	return members
+}
+
+func toTrashedSnippets(ts []service.TrashedSnippet) []TrashedSnippet {
+	snippets := make([]TrashedSnippet, len(ts))
+	for i, t := range ts {
+		snippets[i] = TrashedSnippet{
+			ID:         strconv.FormatInt(t.ID, 10),
+			Title:      t.Title,
+			Git:        toGit(t.Git),
+			GitPath:    t.GitPath,
+			GitVersion: t.GitVersion,
+			Language:   toLanguage(t.Language),
+			DeletedAt:  t.DeletedAt.String(),
+			PurgeAt:    t.PurgeAt.String(),
+		}
+	}
+	return snippets
+}
+
+func toSnippetRevision(r service.SnippetRevision) SnippetRevision {
+	return SnippetRevision{
+		Revision:   int(r.Revision),
+		Title:      r.Title,
+		Code:       r.Code,
+		GitPath:    r.GitPath,
+		GitVersion: r.GitVersion,
+		ProjectURL: r.ProjectURL,
+		Language:   toLanguage(r.Language),
+		UserID:     formatOptionalID(r.UserID),
+		CreatedAt:  r.CreatedAt.String(),
+	}
+}
+
+func toSnippetRevisionSummaries(rs []service.SnippetRevisionSum) []SnippetRevisionSummary {
+	revs := make([]SnippetRevisionSummary, len(rs))
+	for i, r := range rs {
+		revs[i] = SnippetRevisionSummary{
+			Revision:   int(r.Revision),
+			GitVersion: r.GitVersion,
+			UserID:     formatOptionalID(r.UserID),
+			CreatedAt:  r.CreatedAt.String(),
+		}
+	}
+	return revs
+}
+
+func toSnippetDiff(d service.SnippetDiff) SnippetDiff {
+	return SnippetDiff{
+		From: int(d.From),
+		To:   int(d.To),
+		Diff: d.Diff,
+	}
+}
+
+// formatOptionalID formats IDs of nullable references, 0 meaning unset.
+func formatOptionalID(id int64) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// formatOptionalTime formats nullable timestamps, the zero time meaning unset.
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.String()
+}
+
+// parseOptionalID parses a positive ID, an empty value yields nil.
+func parseOptionalID(name, raw string) (*int64, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	val, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if val <= 0 {
+		return nil, fmt.Errorf("%s must be positive", name)
+	}
+	return &val, nil
+}
+
+func parseOptionalTime(name, raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 time", name)
+	}
+	return t, nil
+}
+
+func toAuditEvents(es []service.AuditEvent) []AuditEvent {
+	events := make([]AuditEvent, len(es))
+	for i, e := range es {
+		ae := AuditEvent{
+			ID:         strconv.FormatInt(e.ID, 10),
+			Action:     string(e.Action),
+			TargetType: e.TargetType,
+			IP:         e.IP,
+			Data:       e.Data,
+			Before:     e.Before,
+			After:      e.After,
+			CreatedAt:  e.CreatedAt.String(),
+		}
+		if e.ActorID != 0 {
+			ae.ActorID = strconv.FormatInt(e.ActorID, 10)
+		}
+		if e.TargetID != 0 {
+			ae.TargetID = strconv.FormatInt(e.TargetID, 10)
+		}
+		if e.TokenID != 0 {
+			ae.TokenID = strconv.FormatInt(e.TokenID, 10)
+		}
+		if e.SessionID != 0 {
+			ae.SessionID = strconv.FormatInt(e.SessionID, 10)
+		}
+		events[i] = ae
+	}
+	return events
+}
+
+func parseRevision(raw string) (int32, error) {
+	val, err := strconv.ParseInt(raw, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("revision %q: %w", raw, err)
+	}
+	if val <= 0 {
+		return 0, fmt.Errorf("revision must be positive")
 	}
+	return int32(val), nil
 }
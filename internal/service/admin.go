@@ -0,0 +1,316 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/beavercli/beaver_api/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/sync/errgroup"
+)
+
+// userRole returns the role the tokens of userID act with, disabled and
+// deleted users are rejected.
+func (s *Service) userRole(ctx context.Context, userID int64) (Role, error) {
+	a, err := s.db.GetUserAccess(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", invalidToken("User of the token no longer exists")
+	}
+	if err != nil {
+		return "", err
+	}
+	if a.DisabledAt.Valid {
+		return "", invalidToken("Account is disabled")
+	}
+	return Role(a.Role), nil
+}
+
+func (s *Service) GetUsersPage(ctx context.Context, p PageParam) (AdminUserList, error) {
+	var rows []storage.User
+	var cnt int64
+
+	g, dbCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		rows, err = s.db.ListUsers(dbCtx, storage.ListUsersParams{
+			Offset: int32(p.Offset()),
+			Limit:  int32(p.Limit()),
+		})
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		cnt, err = s.db.CountUsers(dbCtx)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return AdminUserList{}, err
+	}
+
+	items := make([]AdminUser, len(rows))
+	for i, r := range rows {
+		items[i] = toAdminUser(r)
+	}
+	return AdminUserList{Items: items, Total: int(cnt)}, nil
+}
+
+// SetUserRole changes the role of userID. The last active admin cannot be
+// demoted, nobody could manage the users anymore.
+func (s *Service) SetUserRole(ctx context.Context, actorID, userID int64, role Role) (AdminUser, error) {
+	if !role.Valid() {
+		return AdminUser{}, fmt.Errorf("invalid role %q: %w", role, ErrInvalid)
+	}
+
+	var u storage.User
+	err := s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		var err error
+		u, err = getUser(ctx, db, userID)
+		if err != nil {
+			return err
+		}
+		if Role(u.Role) == role {
+			return nil
+		}
+		if Role(u.Role) == RoleAdmin && !u.DisabledAt.Valid {
+			if err := ensureAnotherAdmin(ctx, db); err != nil {
+				return err
+			}
+		}
+
+		if _, err := db.UpdateUserRole(ctx, storage.UpdateUserRoleParams{ID: userID, Role: string(role)}); err != nil {
+			return err
+		}
+		from := u.Role
+		u.Role = string(role)
+
+		return writeAuditEvent(ctx, db, auditEvent{
+			ActorID:    actorID,
+			Action:     AuditUserRoleChanged,
+			TargetType: "user",
+			TargetID:   userID,
+			Before:     map[string]any{"role": from},
+			After:      map[string]any{"role": role},
+		})
+	})
+	if err != nil {
+		return AdminUser{}, err
+	}
+	return toAdminUser(u), nil
+}
+
+// DisableUser blocks the user from signing in and ends their sessions. Their
+// service access tokens are rejected while the account stays disabled.
+func (s *Service) DisableUser(ctx context.Context, actorID, userID int64) error {
+	if actorID == userID {
+		return fmt.Errorf("cannot disable your own account: %w", ErrInvalid)
+	}
+
+	return s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		u, err := getUser(ctx, db, userID)
+		if err != nil {
+			return err
+		}
+		if u.DisabledAt.Valid {
+			return nil
+		}
+		if Role(u.Role) == RoleAdmin {
+			if err := ensureAnotherAdmin(ctx, db); err != nil {
+				return err
+			}
+		}
+
+		if _, err := db.SetUserDisabledAt(ctx, storage.SetUserDisabledAtParams{
+			ID:         userID,
+			DisabledAt: pgtype.Timestamptz{Time: time.Now(), Valid: true},
+		}); err != nil {
+			return err
+		}
+		n, err := db.DeleteSessionsByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		return writeAuditEvent(ctx, db, auditEvent{
+			ActorID:    actorID,
+			Action:     AuditUserDisabled,
+			TargetType: "user",
+			TargetID:   userID,
+			Data:       map[string]any{"revoked_sessions": n},
+		})
+	})
+}
+
+func (s *Service) EnableUser(ctx context.Context, actorID, userID int64) error {
+	return s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		u, err := getUser(ctx, db, userID)
+		if err != nil {
+			return err
+		}
+		if !u.DisabledAt.Valid {
+			return nil
+		}
+
+		if _, err := db.SetUserDisabledAt(ctx, storage.SetUserDisabledAtParams{ID: userID}); err != nil {
+			return err
+		}
+
+		return writeAuditEvent(ctx, db, auditEvent{
+			ActorID:    actorID,
+			Action:     AuditUserEnabled,
+			TargetType: "user",
+			TargetID:   userID,
+		})
+	})
+}
+
+// ForceLogoutUser ends every session of the user, their refresh tokens go
+// with them and the access tokens are rejected from then on. It returns the
+// number of ended sessions.
+func (s *Service) ForceLogoutUser(ctx context.Context, actorID, userID int64) (int64, error) {
+	var n int64
+	err := s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		if _, err := getUser(ctx, db, userID); err != nil {
+			return err
+		}
+
+		var err error
+		n, err = db.DeleteSessionsByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		return writeAuditEvent(ctx, db, auditEvent{
+			ActorID:    actorID,
+			Action:     AuditUserLoggedOut,
+			TargetType: "user",
+			TargetID:   userID,
+			Data:       map[string]any{"revoked_sessions": n},
+		})
+	})
+	return n, err
+}
+
+// GetOrphanedSnippetsPage lists the snippets left without an owner, e.g. by
+// users deleted before account deletion handled their snippets.
+func (s *Service) GetOrphanedSnippetsPage(ctx context.Context, p PageParam) (OrphanedSnippetList, error) {
+	var rows []storage.ListOrphanedSnippetsRow
+	var cnt int64
+
+	g, dbCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		rows, err = s.db.ListOrphanedSnippets(dbCtx, storage.ListOrphanedSnippetsParams{
+			Offset: int32(p.Offset()),
+			Limit:  int32(p.Limit()),
+		})
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		cnt, err = s.db.CountOrphanedSnippets(dbCtx)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return OrphanedSnippetList{}, err
+	}
+
+	items := make([]OrphanedSnippet, len(rows))
+	for i, r := range rows {
+		items[i] = OrphanedSnippet{
+			ID:             r.ID,
+			Title:          r.Title.String,
+			OrganizationID: r.OrganizationID.Int64,
+			CreatedAt:      r.CreatedAt.Time,
+		}
+	}
+	return OrphanedSnippetList{Items: items, Total: int(cnt)}, nil
+}
+
+type TakeOverSnippetParam struct {
+	ActorID   int64
+	SnippetID int64
+	// the snippet goes to this user, to the actor when empty
+	ToUsername string
+}
+
+// TakeOverSnippet assigns an orphaned snippet to a new owner.
+func (s *Service) TakeOverSnippet(ctx context.Context, p TakeOverSnippetParam) error {
+	return s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		toID := p.ActorID
+		if p.ToUsername != "" {
+			var err error
+			toID, err = db.GetUserIDByUsername(ctx, p.ToUsername)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("user %q: %w", p.ToUsername, ErrNotFound)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		n, err := db.TakeOverSnippet(ctx, storage.TakeOverSnippetParams{
+			ID:     p.SnippetID,
+			UserID: pgtype.Int8{Int64: toID, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			sn, err := db.GetSnippetOwnerForUpdate(ctx, p.SnippetID)
+			if errors.Is(err, pgx.ErrNoRows) || (err == nil && sn.DeletedAt.Valid) {
+				return fmt.Errorf("snippet %d: %w", p.SnippetID, ErrNotFound)
+			}
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("snippet %d has an owner: %w", p.SnippetID, ErrConflict)
+		}
+
+		return writeAuditEvent(ctx, db, auditEvent{
+			ActorID:    p.ActorID,
+			Action:     AuditSnippetTakenOver,
+			TargetType: "snippet",
+			TargetID:   p.SnippetID,
+			Data:       map[string]any{"to_user_id": toID},
+		})
+	})
+}
+
+func getUser(ctx context.Context, db *storage.Queries, userID int64) (storage.User, error) {
+	u, err := db.GetUserByID(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return storage.User{}, fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	return u, err
+}
+
+// ensureAnotherAdmin checks an active admin is left when one is demoted or
+// disabled. It locks the admins until the transaction ends.
+func ensureAnotherAdmin(ctx context.Context, db *storage.Queries) error {
+	admins, err := db.LockActiveAdmins(ctx)
+	if err != nil {
+		return err
+	}
+	if len(admins) < 2 {
+		return fmt.Errorf("cannot remove the last active admin: %w", ErrConflict)
+	}
+	return nil
+}
+
+func toAdminUser(u storage.User) AdminUser {
+	var disabledAt *time.Time
+	if u.DisabledAt.Valid {
+		disabledAt = &u.DisabledAt.Time
+	}
+	return AdminUser{
+		ID:         u.ID,
+		Username:   u.Username,
+		Email:      u.Email,
+		Role:       Role(u.Role),
+		DisabledAt: disabledAt,
+		CreatedAt:  u.CreatedAt.Time,
+	}
+}
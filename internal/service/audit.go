@@ -0,0 +1,297 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/beavercli/beaver_api/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type AuditAction string
+
+const (
+	AuditLogin                  AuditAction = "auth.login"
+	AuditSessionRevoked         AuditAction = "auth.session_revoked"
+	AuditAccountDeleted         AuditAction = "account.deleted"
+	AuditProfileUpdated         AuditAction = "account.profile_updated"
+	AuditIdentityLinked         AuditAction = "identity.linked"
+	AuditIdentityUnlinked       AuditAction = "identity.unlinked"
+	AuditTokenCreated           AuditAction = "token.created"
+	AuditTokenRotated           AuditAction = "token.rotated"
+	AuditTokenRevoked           AuditAction = "token.revoked"
+	AuditSnippetCreated         AuditAction = "snippet.created"
+	AuditSnippetUpdated         AuditAction = "snippet.updated"
+	AuditSnippetDeleted         AuditAction = "snippet.deleted"
+	AuditSnippetRestored        AuditAction = "snippet.restored"
+	AuditSnippetTagsChanged     AuditAction = "snippet.tags_changed"
+	AuditSnippetLanguageChanged AuditAction = "snippet.language_changed"
+	AuditUserRoleChanged        AuditAction = "user.role_changed"
+	AuditUserDisabled           AuditAction = "user.disabled"
+	AuditUserEnabled            AuditAction = "user.enabled"
+	AuditUserLoggedOut          AuditAction = "user.logged_out"
+	AuditSnippetTakenOver       AuditAction = "snippet.taken_over"
+	AuditRefreshTokenReused     AuditAction = "security.refresh_token_reused"
+)
+
+// Actor is who makes a change, recorded with the audit events of the change.
+type Actor struct {
+	UserID    int64
+	TokenID   int64 // service access token the request is authenticated with
+	SessionID int64 // login session the request is authenticated with
+	IP        string
+}
+
+type actorContextKey struct{}
+
+// WithActor returns a copy of ctx that attributes the audit events written
+// with it to a.
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, a)
+}
+
+func actorFromContext(ctx context.Context) Actor {
+	a, _ := ctx.Value(actorContextKey{}).(Actor)
+	return a
+}
+
+type auditEvent struct {
+	ActorID    int64 // the actor of ctx when zero
+	Action     AuditAction
+	TargetType string
+	TargetID   int64
+	Data       any // marshalled to JSON, nil for no data
+	Before     any // state of the target before the change, nil when it did not exist
+	After      any // state of the target after the change, nil when it is gone
+}
+
+// writeAuditEvent records e with db, so it commits or rolls back together with
+// the change it describes. The token and IP of the actor are taken from ctx.
+func writeAuditEvent(ctx context.Context, db *storage.Queries, e auditEvent) error {
+	data, err := marshalAuditData(e.Data)
+	if err != nil {
+		return err
+	}
+	before, err := marshalAuditData(e.Before)
+	if err != nil {
+		return err
+	}
+	after, err := marshalAuditData(e.After)
+	if err != nil {
+		return err
+	}
+
+	a := actorFromContext(ctx)
+	if e.ActorID == 0 {
+		e.ActorID = a.UserID
+	}
+
+	return db.CreateAuditEvent(ctx, storage.CreateAuditEventParams{
+		ActorID:    pgtype.Int8{Int64: e.ActorID, Valid: e.ActorID != 0},
+		Action:     string(e.Action),
+		TargetType: e.TargetType,
+		TargetID:   pgtype.Int8{Int64: e.TargetID, Valid: e.TargetID != 0},
+		Data:       data,
+		TokenID:    pgtype.Int8{Int64: a.TokenID, Valid: a.TokenID != 0},
+		SessionID:  pgtype.Int8{Int64: a.SessionID, Valid: a.SessionID != 0},
+		IpAddress:  pgtype.Text{String: a.IP, Valid: a.IP != ""},
+		BeforeData: before,
+		AfterData:  after,
+	})
+}
+
+func marshalAuditData(v any) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+// snippetAuditState is what the audit log keeps of a snippet, the code itself
+// is in the revisions.
+type snippetAuditState struct {
+	Title          string   `json:"title"`
+	CodeSHA256     string   `json:"code_sha256"`
+	Visibility     string   `json:"visibility"`
+	OwnerID        int64    `json:"owner_id,omitempty"`
+	OrganizationID int64    `json:"organization_id,omitempty"`
+	Language       string   `json:"language,omitempty"`
+	Tags           []string `json:"tags"`
+	Deleted        bool     `json:"deleted,omitempty"`
+}
+
+// loadSnippetAuditState returns nil for a snippet that does not exist.
+func loadSnippetAuditState(ctx context.Context, db *storage.Queries, snippetID int64) (*snippetAuditState, error) {
+	sn, err := db.GetSnippetAuditState(ctx, snippetID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tags, err := db.GetTagsBySnippetID(ctx, snippetID)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(sn.Code.String))
+	st := &snippetAuditState{
+		Title:          sn.Title.String,
+		CodeSHA256:     hex.EncodeToString(sum[:]),
+		Visibility:     sn.Visibility,
+		OwnerID:        sn.UserID.Int64,
+		OrganizationID: sn.OrganizationID.Int64,
+		Language:       sn.LanguageName.String,
+		Tags:           make([]string, len(tags)),
+		Deleted:        sn.DeletedAt.Valid,
+	}
+	for i, t := range tags {
+		st.Tags[i] = t.Name.String
+	}
+	slices.Sort(st.Tags)
+	return st, nil
+}
+
+// writeSnippetAuditEvents records a change of the snippet from before to
+// after, with separate events for changed tags and language so they can be
+// filtered on.
+func writeSnippetAuditEvents(ctx context.Context, db *storage.Queries, action AuditAction, snippetID int64, before, after *snippetAuditState) error {
+	e := auditEvent{Action: action, TargetType: "snippet", TargetID: snippetID}
+	if before != nil {
+		e.Before = before
+	}
+	if after != nil {
+		e.After = after
+	}
+	if err := writeAuditEvent(ctx, db, e); err != nil {
+		return err
+	}
+	if before == nil || after == nil {
+		return nil
+	}
+
+	if !slices.Equal(before.Tags, after.Tags) {
+		if err := writeAuditEvent(ctx, db, auditEvent{
+			Action:     AuditSnippetTagsChanged,
+			TargetType: "snippet",
+			TargetID:   snippetID,
+			Before:     before.Tags,
+			After:      after.Tags,
+		}); err != nil {
+			return err
+		}
+	}
+	if before.Language != after.Language {
+		return writeAuditEvent(ctx, db, auditEvent{
+			Action:     AuditSnippetLanguageChanged,
+			TargetType: "snippet",
+			TargetID:   snippetID,
+			Before:     before.Language,
+			After:      after.Language,
+		})
+	}
+	return nil
+}
+
+const (
+	DefaultAuditPageSize = 50
+	MaxAuditPageSize     = 200
+)
+
+// ListAuditEventsParam filters the audit log, zero fields match everything.
+type ListAuditEventsParam struct {
+	ActorID    int64
+	Action     AuditAction
+	TargetType string
+	TargetID   int64
+	TokenID    int64
+	Since      time.Time
+	Until      time.Time
+	Cursor     string // NextCursor of the previous page, empty for the newest events
+	Limit      int
+}
+
+// ListAuditEvents returns the matching events newest first. The log is
+// appended to while it is paged through, so pages are cut by a cursor on the
+// event ID instead of an offset.
+func (s *Service) ListAuditEvents(ctx context.Context, p ListAuditEventsParam) (AuditEventList, error) {
+	if p.Limit <= 0 {
+		p.Limit = DefaultAuditPageSize
+	}
+	if p.Limit > MaxAuditPageSize {
+		return AuditEventList{}, fmt.Errorf("limit must be <=%d: %w", MaxAuditPageSize, ErrInvalid)
+	}
+
+	arg := storage.ListAuditEventsParams{
+		ActorID:    pgtype.Int8{Int64: p.ActorID, Valid: p.ActorID != 0},
+		Action:     pgtype.Text{String: string(p.Action), Valid: p.Action != ""},
+		TargetType: pgtype.Text{String: p.TargetType, Valid: p.TargetType != ""},
+		TargetID:   pgtype.Int8{Int64: p.TargetID, Valid: p.TargetID != 0},
+		TokenID:    pgtype.Int8{Int64: p.TokenID, Valid: p.TokenID != 0},
+		Since:      pgtype.Timestamptz{Time: p.Since, Valid: !p.Since.IsZero()},
+		Until:      pgtype.Timestamptz{Time: p.Until, Valid: !p.Until.IsZero()},
+		// one more to know whether there is a next page
+		Limit: int32(p.Limit + 1),
+	}
+	if p.Cursor != "" {
+		id, err := decodeAuditCursor(p.Cursor)
+		if err != nil {
+			return AuditEventList{}, err
+		}
+		arg.BeforeID = pgtype.Int8{Int64: id, Valid: true}
+	}
+
+	rows, err := s.db.ListAuditEvents(ctx, arg)
+	if err != nil {
+		return AuditEventList{}, err
+	}
+
+	var next string
+	if len(rows) > p.Limit {
+		rows = rows[:p.Limit]
+		next = encodeAuditCursor(rows[len(rows)-1].ID)
+	}
+
+	items := make([]AuditEvent, len(rows))
+	for i, r := range rows {
+		items[i] = AuditEvent{
+			ID:         r.ID,
+			CreatedAt:  r.CreatedAt.Time,
+			ActorID:    r.ActorID.Int64,
+			TokenID:    r.TokenID.Int64,
+			SessionID:  r.SessionID.Int64,
+			IP:         r.IpAddress.String,
+			Action:     AuditAction(r.Action),
+			TargetType: r.TargetType,
+			TargetID:   r.TargetID.Int64,
+			Data:       r.Data,
+			Before:     r.BeforeData,
+			After:      r.AfterData,
+		}
+	}
+	return AuditEventList{Items: items, NextCursor: next}, nil
+}
+
+func encodeAuditCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeAuditCursor(c string) (int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(c)
+	if err == nil {
+		var id int64
+		if id, err = strconv.ParseInt(string(b), 10, 64); err == nil {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid cursor %q: %w", c, ErrInvalid)
+}
@@ -0,0 +1,258 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+const diffContextLines = 3
+
+// maxDiffLines bounds the lines of each side of a diff, the time to diff
+// grows with their product.
+const maxDiffLines = 10000
+
+type diffKind byte
+
+const (
+	diffEqual  diffKind = ' '
+	diffDelete diffKind = '-'
+	diffInsert diffKind = '+'
+)
+
+type diffOp struct {
+	kind diffKind
+	line string
+}
+
+// unifiedDiff renders the line diff between a and b in the unified format
+// used by diff -u and git. It returns an empty string when a and b are equal.
+func unifiedDiff(fromName, toName, a, b string) string {
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	var sb strings.Builder
+	for _, h := range toHunks(ops, diffContextLines) {
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(h.aStart, h.aLen), hunkRange(h.bStart, h.bLen))
+		for _, op := range h.ops {
+			sb.WriteByte(byte(op.kind))
+			sb.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes the shortest edit script between a and b with the linear
+// space variant of the Myers algorithm.
+func diffLines(a, b []string) []diffOp {
+	return appendDiffLines(make([]diffOp, 0, len(a)+len(b)), a, b)
+}
+
+// appendDiffLines appends the edit script between a and b to ops. The common
+// prefix and suffix are stripped first, which keeps the search small for the
+// usual case of a few edited lines in a larger snippet. The rest is split at
+// the middle of a shortest path and both halves are diffed the same way.
+func appendDiffLines(ops []diffOp, a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	for _, l := range a[:prefix] {
+		ops = append(ops, diffOp{kind: diffEqual, line: l})
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if x, y, ok := middleSnake(midA, midB); ok {
+		ops = appendDiffLines(ops, midA[:x], midB[:y])
+		ops = appendDiffLines(ops, midA[x:], midB[y:])
+	} else {
+		for _, l := range midA {
+			ops = append(ops, diffOp{kind: diffDelete, line: l})
+		}
+		for _, l := range midB {
+			ops = append(ops, diffOp{kind: diffInsert, line: l})
+		}
+	}
+	for _, l := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{kind: diffEqual, line: l})
+	}
+	return ops
+}
+
+// middleSnake searches a shortest path from both ends at once and returns a
+// point where the two searches meet. It keeps only the furthest reaching
+// paths of the current round, so it needs O(len(a)+len(b)) memory. It reports
+// false when a and b have nothing in common or one of them is empty.
+func middleSnake(a, b []string) (int, int, bool) {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return 0, 0, false
+	}
+
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	fwd := make([]int, 2*maxD+2)
+	bwd := make([]int, 2*maxD+2)
+	for i := range fwd {
+		fwd[i], bwd[i] = -1, -1
+	}
+	fwd[offset+1], bwd[offset+1] = 0, 0
+
+	delta := n - m
+	odd := delta%2 != 0
+	// diagonals that ran off the grid are skipped in later rounds
+	var fwdStart, fwdEnd, bwdStart, bwdEnd int
+
+	for d := 0; d < maxD; d++ {
+		for k := -d + fwdStart; k <= d-fwdEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && fwd[offset+k-1] < fwd[offset+k+1]) {
+				x = fwd[offset+k+1]
+			} else {
+				x = fwd[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			fwd[offset+k] = x
+			switch {
+			case x > n:
+				fwdEnd += 2
+			case y > m:
+				fwdStart += 2
+			case odd:
+				if i := offset + delta - k; i >= 0 && i < len(bwd) && bwd[i] != -1 && x >= n-bwd[i] {
+					return x, y, true
+				}
+			}
+		}
+
+		// the backward search walks a and b from their ends
+		for k := -d + bwdStart; k <= d-bwdEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && bwd[offset+k-1] < bwd[offset+k+1]) {
+				x = bwd[offset+k+1]
+			} else {
+				x = bwd[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			bwd[offset+k] = x
+			switch {
+			case x > n:
+				bwdEnd += 2
+			case y > m:
+				bwdStart += 2
+			case !odd:
+				if i := offset + delta - k; i >= 0 && i < len(fwd) && fwd[i] != -1 && fwd[i] >= n-x {
+					fx := fwd[i]
+					return fx, fx - (i - offset), true
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+type hunk struct {
+	aStart, aLen int
+	bStart, bLen int
+	ops          []diffOp
+}
+
+// toHunks groups changes that are at most 2*context lines apart and
+// surrounds every group with up to context unchanged lines.
+func toHunks(ops []diffOp, context int) []hunk {
+	var hunks []hunk
+	aLine, bLine := 0, 0
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == diffEqual {
+			aLine++
+			bLine++
+			i++
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		lead := i - start
+
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != diffEqual {
+				end = j + 1
+				continue
+			}
+			if j-end >= 2*context {
+				break
+			}
+		}
+		stop := end + context
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		h := hunk{
+			aStart: aLine - lead,
+			bStart: bLine - lead,
+			ops:    ops[start:stop],
+		}
+		for _, op := range h.ops {
+			if op.kind != diffInsert {
+				h.aLen++
+			}
+			if op.kind != diffDelete {
+				h.bLen++
+			}
+		}
+		hunks = append(hunks, h)
+
+		for _, op := range ops[i:stop] {
+			if op.kind != diffInsert {
+				aLine++
+			}
+			if op.kind != diffDelete {
+				bLine++
+			}
+		}
+		i = stop
+	}
+	return hunks
+}
+
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
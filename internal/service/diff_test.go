@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n"
+	b := "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello, world\")\n\tfmt.Println(\"bye\")\n}\n"
+
+	expected := "--- main.go@1\n+++ main.go@2\n" +
+		"@@ -3,5 +3,6 @@\n" +
+		" import \"fmt\"\n" +
+		" \n" +
+		" func main() {\n" +
+		"-\tfmt.Println(\"hello\")\n" +
+		"+\tfmt.Println(\"hello, world\")\n" +
+		"+\tfmt.Println(\"bye\")\n" +
+		" }\n"
+
+	assert.Equal(t, expected, unifiedDiff("main.go@1", "main.go@2", a, b))
+}
+
+func TestUnifiedDiffEqual(t *testing.T) {
+	assert.Equal(t, "", unifiedDiff("a", "b", "same\n", "same\n"))
+}
+
+func TestUnifiedDiffSeparateHunks(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	b := "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve"
+
+	expected := "--- a\n+++ b\n" +
+		"@@ -1,4 +1,4 @@\n" +
+		"-1\n" +
+		"+one\n" +
+		" 2\n" +
+		" 3\n" +
+		" 4\n" +
+		"@@ -9,4 +9,4 @@\n" +
+		" 9\n" +
+		" 10\n" +
+		" 11\n" +
+		"-12\n" +
+		"+twelve\n" +
+		"\\ No newline at end of file\n"
+
+	assert.Equal(t, expected, unifiedDiff("a", "b", a, b))
+}
+
+func TestUnifiedDiffFromEmpty(t *testing.T) {
+	assert.Equal(t, "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n", unifiedDiff("a", "b", "", "x\ny\n"))
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrNotFound is returned when the requested object does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrForbidden is returned when the caller is not allowed to act on the object.
+	ErrForbidden = errors.New("permission denied")
+	// ErrConflict is returned when the change clashes with the current state.
+	ErrConflict = errors.New("conflict")
+	// ErrTooLarge is returned when the input is too large to be processed.
+	ErrTooLarge = errors.New("too large")
+	// ErrInvalid is returned when the request itself is malformed.
+	ErrInvalid = errors.New("invalid request")
+)
+
+// isUniqueViolation reports whether err is a unique constraint violation
+// (SQLSTATE 23505).
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// Error codes of rejected credentials, as sent in the WWW-Authenticate
+// challenge (RFC 6750).
+const (
+	AuthErrInvalidRequest = "invalid_request"
+	AuthErrInvalidToken   = "invalid_token"
+)
+
+// AuthError is returned when a credential is rejected, Description tells the
+// client why.
+type AuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *AuthError) Error() string {
+	return e.Description
+}
+
+func invalidToken(description string) error {
+	return &AuthError{Code: AuthErrInvalidToken, Description: description}
+}
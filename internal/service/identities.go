@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/beavercli/beaver_api/internal/storage"
+	"github.com/jackc/pgx/v5"
+)
+
+// signIn resolves the user of an account at a login provider. Identities are
+// matched on the stable user ID of the provider, so a changed email or
+// username keeps signing in to the same user. An email match is only trusted
+// for GitHub, the only provider before identities, and only for accounts that
+// have none linked yet. Any other user, and any other provider, has to sign in
+// and link the provider explicitly: the emails of a self-hosted GitLab or an
+// arbitrary OIDC issuer vouch for nothing. Users with one of the configured
+// admin emails are made admins.
+func (s *Service) signIn(ctx context.Context, provider string, id ExternalIdentity) (storage.User, error) {
+	var u storage.User
+	err := s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		ident, err := db.GetUserIdentity(ctx, storage.GetUserIdentityParams{
+			Provider:       provider,
+			ProviderUserID: id.Subject,
+		})
+		switch {
+		case err == nil:
+			if err := db.UpdateUserIdentity(ctx, storage.UpdateUserIdentityParams{
+				ID:       ident.ID,
+				Username: id.Username,
+				Email:    id.Email,
+			}); err != nil {
+				return err
+			}
+			u, err = db.GetUserByID(ctx, ident.UserID)
+			return err
+		case !errors.Is(err, pgx.ErrNoRows):
+			return err
+		}
+
+		userID, err := db.GetUserIDByEmail(ctx, id.Email)
+		switch {
+		case err == nil:
+			n, err := db.CountUserIdentities(ctx, userID)
+			if err != nil {
+				return err
+			}
+			if n > 0 || provider != ProviderGitHub {
+				return fmt.Errorf("An account with the email %s already exists, sign in to it and link your %s account: %w", id.Email, provider, ErrConflict)
+			}
+		case errors.Is(err, pgx.ErrNoRows):
+			userID, err = createUser(ctx, db, id)
+			if err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		if _, err := db.CreateUserIdentity(ctx, storage.CreateUserIdentityParams{
+			UserID:         userID,
+			Provider:       provider,
+			ProviderUserID: id.Subject,
+			Username:       id.Username,
+			Email:          id.Email,
+		}); err != nil {
+			return err
+		}
+		u, err = db.GetUserByID(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return storage.User{}, err
+	}
+	if u.DisabledAt.Valid {
+		return storage.User{}, fmt.Errorf("Account is disabled: %w", ErrForbidden)
+	}
+
+	if Role(u.Role) != RoleAdmin && slices.ContainsFunc(s.conf.AdminEmails, func(e string) bool {
+		return strings.EqualFold(e, u.Email)
+	}) {
+		if _, err := s.db.UpdateUserRole(ctx, storage.UpdateUserRoleParams{ID: u.ID, Role: string(RoleAdmin)}); err != nil {
+			return storage.User{}, err
+		}
+		u.Role = string(RoleAdmin)
+	}
+	return u, nil
+}
+
+const (
+	maxUsernameLen      = 255 // size of the username column
+	maxUsernameAttempts = 20  // numbered variants of a taken username createUser tries
+)
+
+// createUser creates the user of a new identity. Usernames of different
+// providers can clash, so a taken one gets a number appended: alice-2,
+// alice-3 and so on.
+func createUser(ctx context.Context, db *storage.Queries, id ExternalIdentity) (int64, error) {
+	pwd, err := generateRandomPwd()
+	if err != nil {
+		return 0, err
+	}
+	for i := 1; i <= maxUsernameAttempts; i++ {
+		userID, err := db.UpsertUser(ctx, storage.UpsertUserParams{
+			Username:     numberedUsername(id.Username, i),
+			Email:        id.Email,
+			PasswordHash: pwd,
+		})
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return userID, err
+		}
+	}
+	return 0, fmt.Errorf("The username %s is taken: %w", id.Username, ErrConflict)
+}
+
+// numberedUsername returns the n-th variant of username, the first being the
+// username itself.
+func numberedUsername(username string, n int) string {
+	suffix := ""
+	if n > 1 {
+		suffix = fmt.Sprintf("-%d", n)
+	}
+	runes := []rune(username)
+	if limit := maxUsernameLen - len(suffix); len(runes) > limit {
+		runes = runes[:limit]
+	}
+	return string(runes) + suffix
+}
+
+func (s *Service) ListIdentities(ctx context.Context, userID int64) ([]Identity, error) {
+	rows, err := s.db.ListUserIdentities(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return toIdentities(rows), nil
+}
+
+// LinkIdentity polls the device login started for provider, like DevicePoll,
+// and links the approved account to the user instead of signing in.
+func (s *Service) LinkIdentity(ctx context.Context, userID int64, provider, jwe string) (DeviceLinkResult, error) {
+	id, status, err := s.authenticateDevice(ctx, provider, jwe)
+	if err != nil || status != DeviceAuthDone {
+		return DeviceLinkResult{Status: status, Identity: nil}, err
+	}
+
+	var ident storage.UserIdentity
+	err = s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		existing, err := db.GetUserIdentity(ctx, storage.GetUserIdentityParams{
+			Provider:       provider,
+			ProviderUserID: id.Subject,
+		})
+		switch {
+		case err == nil && existing.UserID == userID:
+			ident = existing
+			return nil
+		case err == nil:
+			return fmt.Errorf("The %s account is linked to another user: %w", provider, ErrConflict)
+		case !errors.Is(err, pgx.ErrNoRows):
+			return err
+		}
+
+		linked, err := db.ListUserIdentities(ctx, userID)
+		if err != nil {
+			return err
+		}
+		for _, l := range linked {
+			if l.Provider == provider {
+				return fmt.Errorf("Another %s account is linked already, unlink it first: %w", provider, ErrConflict)
+			}
+		}
+
+		ident, err = db.CreateUserIdentity(ctx, storage.CreateUserIdentityParams{
+			UserID:         userID,
+			Provider:       provider,
+			ProviderUserID: id.Subject,
+			Username:       id.Username,
+			Email:          id.Email,
+		})
+		if err != nil {
+			return err
+		}
+
+		return writeAuditEvent(ctx, db, auditEvent{
+			ActorID:    userID,
+			Action:     AuditIdentityLinked,
+			TargetType: "user",
+			TargetID:   userID,
+			Data:       map[string]any{"provider": provider, "provider_user_id": id.Subject},
+		})
+	})
+	if err != nil {
+		return DeviceLinkResult{}, err
+	}
+
+	i := toIdentity(ident)
+	return DeviceLinkResult{Status: DeviceAuthDone, Identity: &i}, nil
+}
+
+// UnlinkIdentity removes the provider from the logins of the user. The last
+// one cannot be removed, the account could not be signed in to anymore.
+func (s *Service) UnlinkIdentity(ctx context.Context, userID int64, provider string) error {
+	return s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		n, err := db.DeleteUserIdentity(ctx, storage.DeleteUserIdentityParams{
+			UserID:   userID,
+			Provider: provider,
+		})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("%s identity: %w", provider, ErrNotFound)
+		}
+
+		left, err := db.CountUserIdentities(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if left == 0 {
+			return fmt.Errorf("Cannot unlink the only login provider of the account: %w", ErrConflict)
+		}
+
+		return writeAuditEvent(ctx, db, auditEvent{
+			ActorID:    userID,
+			Action:     AuditIdentityUnlinked,
+			TargetType: "user",
+			TargetID:   userID,
+			Data:       map[string]any{"provider": provider},
+		})
+	})
+}
+
+func toIdentity(i storage.UserIdentity) Identity {
+	return Identity{
+		Provider:  i.Provider,
+		Username:  i.Username,
+		Email:     i.Email,
+		CreatedAt: i.CreatedAt.Time,
+	}
+}
+
+func toIdentities(rows []storage.UserIdentity) []Identity {
+	identities := make([]Identity, len(rows))
+	for i, r := range rows {
+		identities[i] = toIdentity(r)
+	}
+	return identities
+}
@@ -1,10 +1,14 @@
 package service
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
 	"strconv"
 	"time"
 
-	"github.com/beavercli/beaver_api/internal/integrations/github"
 	"github.com/go-jose/go-jose/v4"
 	"github.com/go-jose/go-jose/v4/jwt"
 	"github.com/google/uuid"
@@ -18,19 +22,72 @@ const (
 	AccessToken     TokenType     = "access"
 	RefreshToken    TokenType     = "refresh"
 	SessionToken    TokenType     = "session"
+
+	TokenIssuer   = "beaver_api"
+	TokenAudience = "beaver_api"
 )
 
 type JWTClaims struct {
 	jwt.Claims
-	Type TokenType `json:"token_type"`
+	Type      TokenType `json:"token_type"`
+	SessionID int64     `json:"sid,omitempty"`
+	Scopes    []Scope   `json:"scopes,omitempty"`
+}
+
+// SigningKey is a JWT signing key, identified by the kid header of the
+// tokens it signs. Either Secret or Private is set.
+type SigningKey struct {
+	ID      string
+	Secret  []byte        // HS256 key
+	Private crypto.Signer // ES256 (*ecdsa.PrivateKey on P-256) or EdDSA (ed25519.PrivateKey) key
+	Retired bool          // tokens it signed are no longer accepted
+}
+
+func (k SigningKey) algorithm() jose.SignatureAlgorithm {
+	switch k.Private.(type) {
+	case *ecdsa.PrivateKey:
+		return jose.ES256
+	case ed25519.PrivateKey:
+		return jose.EdDSA
+	}
+	return jose.HS256
+}
+
+// signingKey is the key the tokens are signed with.
+func (k SigningKey) signingKey() any {
+	if k.Private != nil {
+		return k.Private
+	}
+	return k.Secret
+}
+
+// verificationKey is the key the token signatures are checked with.
+func (k SigningKey) verificationKey() any {
+	if k.Private != nil {
+		return k.Private.Public()
+	}
+	return k.Secret
 }
 
-func (s *Service) IssueJWT(tt TokenType, userID int64, ttl time.Duration) (string, error) {
+// TokenSubject is who a token is issued for.
+type TokenSubject struct {
+	UserID    int64
+	SessionID int64   // login session of access and refresh tokens, zero for service access tokens
+	Scopes    []Scope // scopes of service access tokens
+}
+
+func (s *Service) IssueJWT(tt TokenType, sub TokenSubject, ttl time.Duration) (string, error) {
+	key, err := s.signingKey(s.conf.ActiveKeyID)
+	if err != nil {
+		return "", err
+	}
+
 	signerOpts := jose.SignerOptions{}
 	signerOpts.WithType("JWT")
+	signerOpts.WithHeader("kid", key.ID)
 
 	signer, err := jose.NewSigner(
-		jose.SigningKey{Algorithm: jose.HS256, Key: s.conf.Secret},
+		jose.SigningKey{Algorithm: key.algorithm(), Key: key.signingKey()},
 		&signerOpts,
 	)
 	if err != nil {
@@ -41,33 +98,127 @@ func (s *Service) IssueJWT(tt TokenType, userID int64, ttl time.Duration) (strin
 	claims := JWTClaims{
 		Claims: jwt.Claims{
 			ID:        uuid.New().String(),
-			Subject:   strconv.FormatInt(userID, 10),
-			Issuer:    "beaver_api",
+			Subject:   strconv.FormatInt(sub.UserID, 10),
+			Issuer:    TokenIssuer,
+			Audience:  jwt.Audience{TokenAudience},
 			IssuedAt:  jwt.NewNumericDate(now),
 			NotBefore: jwt.NewNumericDate(now),
 			Expiry:    jwt.NewNumericDate(now.Add(ttl)),
 		},
-		Type: tt,
+		Type:      tt,
+		SessionID: sub.SessionID,
+		Scopes:    sub.Scopes,
 	}
 
 	return jwt.Signed(signer).Claims(claims).Serialize()
 }
 
 func (s *Service) ParseJWT(t string) (JWTClaims, error) {
-	token, err := jwt.ParseSigned(t, []jose.SignatureAlgorithm{jose.HS256})
+	token, err := jwt.ParseSigned(t, []jose.SignatureAlgorithm{jose.HS256, jose.ES256, jose.EdDSA})
+	if err != nil {
+		return JWTClaims{}, err
+	}
+
+	// tokens signed before the keyring existed carry no kid and use HS256
+	kid := token.Headers[0].KeyID
+	if kid == "" {
+		for _, k := range s.conf.SigningKeys {
+			claims := JWTClaims{}
+			if !k.Retired && k.Private == nil && token.Claims(k.Secret, &claims) == nil {
+				return claims, nil
+			}
+		}
+		return JWTClaims{}, fmt.Errorf("Token signature is invalid")
+	}
+
+	key, err := s.signingKey(kid)
 	if err != nil {
 		return JWTClaims{}, err
 	}
+	if token.Headers[0].Algorithm != string(key.algorithm()) {
+		return JWTClaims{}, fmt.Errorf("Token algorithm does not match its key %q", kid)
+	}
 	claims := JWTClaims{}
-	if err := token.Claims(s.conf.Secret, &claims); err != nil {
+	if err := token.Claims(key.verificationKey(), &claims); err != nil {
 		return JWTClaims{}, err
 	}
 	return claims, nil
 }
 
-func (s *Service) generateJWE(g github.GithubDevicePayload) (string, error) {
+// VerifyJWT parses t and checks that it is a current token of type tt issued
+// by this API for itself. Failures are returned as *AuthError.
+func (s *Service) VerifyJWT(t string, tt TokenType) (JWTClaims, error) {
+	c, err := s.ParseJWT(t)
+	if err != nil {
+		return JWTClaims{}, invalidToken(err.Error())
+	}
+	if c.Type != tt {
+		return JWTClaims{}, invalidToken(fmt.Sprintf("Token type is %q, expected %q", c.Type, tt))
+	}
+	if c.Expiry == nil {
+		return JWTClaims{}, invalidToken("Token has no expiry")
+	}
+
+	expected := jwt.Expected{Issuer: TokenIssuer, Time: time.Now()}
+	// service access tokens issued before the audience claim was added are
+	// still valid, they are looked up in the database on every use anyway
+	if tt != SessionToken || len(c.Audience) > 0 {
+		expected.AnyAudience = jwt.Audience{TokenAudience}
+	}
+	err = c.Claims.ValidateWithLeeway(expected, 0)
+	switch {
+	case err == nil:
+		return c, nil
+	case errors.Is(err, jwt.ErrInvalidIssuer):
+		return JWTClaims{}, invalidToken(fmt.Sprintf("Token is issued by %q, expected %q", c.Issuer, TokenIssuer))
+	case errors.Is(err, jwt.ErrInvalidAudience):
+		return JWTClaims{}, invalidToken("Token is not issued for this API")
+	case errors.Is(err, jwt.ErrNotValidYet):
+		return JWTClaims{}, invalidToken("Token is not valid yet")
+	case errors.Is(err, jwt.ErrIssuedInTheFuture):
+		return JWTClaims{}, invalidToken("Token is issued in the future")
+	case errors.Is(err, jwt.ErrExpired):
+		return JWTClaims{}, invalidToken("Token is expired")
+	default:
+		return JWTClaims{}, invalidToken(err.Error())
+	}
+}
+
+// signingKey returns the key kid of the keyring unless it is retired.
+func (s *Service) signingKey(kid string) (SigningKey, error) {
+	for _, k := range s.conf.SigningKeys {
+		if k.ID != kid {
+			continue
+		}
+		if k.Retired {
+			return SigningKey{}, fmt.Errorf("Token is signed with the retired key %q", kid)
+		}
+		return k, nil
+	}
+	return SigningKey{}, fmt.Errorf("Token is signed with the unknown key %q", kid)
+}
+
+// JWKS returns the public keys of the asymmetric signing keys that are not
+// retired, HS256 secrets are never published.
+func (s *Service) JWKS() jose.JSONWebKeySet {
+	set := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}}
+	for _, k := range s.conf.SigningKeys {
+		if k.Private == nil || k.Retired {
+			continue
+		}
+		set.Keys = append(set.Keys, jose.JSONWebKey{
+			Key:       k.Private.Public(),
+			KeyID:     k.ID,
+			Algorithm: string(k.algorithm()),
+			Use:       "sig",
+		})
+	}
+	return set
+}
+
+func (s *Service) generateJWE(provider string, dc DeviceCode) (string, error) {
 	r := jose.Recipient{
-		Key:       s.conf.Secret,
+		Key:       s.conf.JWEKey,
 		Algorithm: jose.DIRECT,
 	}
 	opts := jose.EncrypterOptions{}
@@ -78,7 +229,7 @@ func (s *Service) generateJWE(g github.GithubDevicePayload) (string, error) {
 		return "", err
 	}
 
-	token, err := jwt.Encrypted(enc).Claims(toOAuthGithubJWE(g)).Serialize()
+	token, err := jwt.Encrypted(enc).Claims(toOAuthDeviceJWE(provider, dc)).Serialize()
 	if err != nil {
 		return "", err
 	}
@@ -86,21 +237,23 @@ func (s *Service) generateJWE(g github.GithubDevicePayload) (string, error) {
 	return token, nil
 }
 
-func (s *Service) decryptJWE(token string) (OAuthGithubJWE, error) {
+func (s *Service) decryptJWE(token string) (OAuthDeviceJWE, error) {
 	enc, err := jwt.ParseEncrypted(token, []jose.KeyAlgorithm{jose.DIRECT}, []jose.ContentEncryption{jose.A256GCM})
 	if err != nil {
-		return OAuthGithubJWE{}, err
+		return OAuthDeviceJWE{}, err
 	}
-	var claims OAuthGithubJWE
-	if err := enc.Claims(s.conf.Secret, &claims); err != nil {
-		return OAuthGithubJWE{}, err
+	var claims OAuthDeviceJWE
+	if err := enc.Claims(s.conf.JWEKey, &claims); err != nil {
+		return OAuthDeviceJWE{}, err
 	}
 	return claims, nil
 }
 
-func toOAuthGithubJWE(g github.GithubDevicePayload) OAuthGithubJWE {
-	return OAuthGithubJWE{
-		DeviceCode: g.DeviceCode,
-		ExpiresIn:  time.Now().Add(time.Duration(g.ExpiresIn) * time.Second).Unix(),
+func toOAuthDeviceJWE(provider string, dc DeviceCode) OAuthDeviceJWE {
+	return OAuthDeviceJWE{
+		Provider:   provider,
+		DeviceCode: dc.DeviceCode,
+		ExpiresIn:  time.Now().Add(time.Duration(dc.ExpiresIn) * time.Second).Unix(),
+		Interval:   dc.Interval,
 	}
 }
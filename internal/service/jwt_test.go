@@ -0,0 +1,125 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWTKeyRotation(t *testing.T) {
+	oldKey := SigningKey{ID: "2025-11", Secret: bytes.Repeat([]byte{1}, 32)}
+	newKey := SigningKey{ID: "2025-12", Secret: bytes.Repeat([]byte{2}, 32)}
+
+	s := &Service{conf: Config{SigningKeys: []SigningKey{oldKey}, ActiveKeyID: oldKey.ID}}
+	token, err := s.IssueJWT(AccessToken, TokenSubject{UserID: 7, SessionID: 3}, time.Hour)
+	require.NoError(t, err)
+
+	// the new key signs, tokens of the old one are still accepted
+	s.conf = Config{SigningKeys: []SigningKey{oldKey, newKey}, ActiveKeyID: newKey.ID}
+	c, err := s.ParseJWT(token)
+	require.NoError(t, err)
+	assert.Equal(t, "7", c.Subject)
+	assert.Equal(t, int64(3), c.SessionID)
+
+	fresh, err := s.IssueJWT(AccessToken, TokenSubject{UserID: 7}, time.Hour)
+	require.NoError(t, err)
+
+	oldKey.Retired = true
+	s.conf.SigningKeys = []SigningKey{oldKey, newKey}
+	_, err = s.ParseJWT(token)
+	assert.ErrorContains(t, err, "retired")
+	_, err = s.ParseJWT(fresh)
+	assert.NoError(t, err)
+
+	s.conf.SigningKeys = []SigningKey{newKey}
+	_, err = s.ParseJWT(token)
+	assert.ErrorContains(t, err, "unknown")
+}
+
+func TestJWTAsymmetricKeys(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	hsKey := SigningKey{ID: "hs", Secret: bytes.Repeat([]byte{1}, 32)}
+	s := &Service{conf: Config{
+		SigningKeys: []SigningKey{hsKey, {ID: "ed", Private: edKey}, {ID: "ec", Private: ecKey}},
+	}}
+
+	for _, kid := range []string{"hs", "ed", "ec"} {
+		s.conf.ActiveKeyID = kid
+		token, err := s.IssueJWT(AccessToken, TokenSubject{UserID: 7}, time.Hour)
+		require.NoError(t, err)
+		c, err := s.ParseJWT(token)
+		require.NoError(t, err, kid)
+		assert.Equal(t, "7", c.Subject)
+	}
+
+	jwks := s.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, "ed", jwks.Keys[0].KeyID)
+	assert.Equal(t, "EdDSA", jwks.Keys[0].Algorithm)
+	assert.True(t, jwks.Keys[1].IsPublic())
+}
+
+func TestVerifyJWT(t *testing.T) {
+	key := SigningKey{ID: "hs", Secret: bytes.Repeat([]byte{1}, 32)}
+	s := &Service{conf: Config{SigningKeys: []SigningKey{key}, ActiveKeyID: key.ID}}
+
+	access, err := s.IssueJWT(AccessToken, TokenSubject{UserID: 7, SessionID: 3}, time.Hour)
+	require.NoError(t, err)
+	refresh, err := s.IssueJWT(RefreshToken, TokenSubject{UserID: 7, SessionID: 3}, time.Hour)
+	require.NoError(t, err)
+
+	c, err := s.VerifyJWT(access, AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "7", c.Subject)
+
+	_, err = s.VerifyJWT(refresh, AccessToken)
+	assert.ErrorContains(t, err, `expected "access"`)
+
+	sign := func(claims jwt.Claims) string {
+		signerOpts := jose.SignerOptions{}
+		signerOpts.WithHeader("kid", key.ID)
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: key.Secret}, &signerOpts)
+		require.NoError(t, err)
+		token, err := jwt.Signed(signer).Claims(JWTClaims{Claims: claims, Type: AccessToken}).Serialize()
+		require.NoError(t, err)
+		return token
+	}
+	now := time.Now()
+	valid := jwt.Claims{
+		Subject:   "7",
+		Issuer:    TokenIssuer,
+		Audience:  jwt.Audience{TokenAudience},
+		NotBefore: jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+
+	cases := map[string]func(c *jwt.Claims){
+		"issued by":      func(c *jwt.Claims) { c.Issuer = "someone_else" },
+		"not issued for": func(c *jwt.Claims) { c.Audience = nil },
+		"not valid yet":  func(c *jwt.Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Hour)) },
+		"expired":        func(c *jwt.Claims) { c.Expiry = jwt.NewNumericDate(now.Add(-time.Minute)) },
+		"has no expiry":  func(c *jwt.Claims) { c.Expiry = nil },
+	}
+	for want, change := range cases {
+		claims := valid
+		change(&claims)
+		_, err := s.VerifyJWT(sign(claims), AccessToken)
+		var ae *AuthError
+		require.ErrorAs(t, err, &ae, want)
+		assert.Equal(t, AuthErrInvalidToken, ae.Code)
+		assert.Contains(t, ae.Description, want)
+	}
+}
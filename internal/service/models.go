@@ -1,10 +1,15 @@
 package service
 
-import "time"
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
 
 type Tag struct {
-	ID   int64
-	Name string
+	ID         int64
+	Name       string
+	Similarity float32 // trigram similarity to the search query, 0 when not searching
 }
 
 type TagList struct {
@@ -38,35 +43,104 @@ type ContributorList struct {
 	Total int
 }
 
+type Visibility string
+
+const (
+	VisibilityPrivate Visibility = "private" // only the owner
+	VisibilityTeam    Visibility = "team"    // every authenticated user
+	VisibilityPublic  Visibility = "public"  // anyone, including anonymous callers
+)
+
+func (v Visibility) Valid() bool {
+	switch v {
+	case VisibilityPrivate, VisibilityTeam, VisibilityPublic:
+		return true
+	}
+	return false
+}
+
 type Snippet struct {
-	ID           int64
-	Title        string
-	Code         string
-	ProjectURL   string
-	GitPath      string
-	GitVersion   string
-	Git          Git
-	Language     Language
-	Tags         []Tag
-	Contributors []Contributor
+	ID             int64
+	Title          string
+	Code           string
+	ProjectURL     string
+	GitPath        string
+	GitVersion     string
+	Visibility     Visibility
+	OrganizationID int64 // zero when the snippet is not scoped to an organization
+	Git            Git
+	Language       Language
+	Tags           []Tag
+	Contributors   []Contributor
 }
 
 type SnippetSummary struct {
+	ID             int64
+	Title          string
+	ProjectURL     string
+	GitPath        string
+	GitVersion     string
+	Visibility     Visibility
+	OrganizationID int64
+	Git            Git
+	Language       Language
+	Tags           []Tag
+	Rank           float32 // search relevance, 0 when no query is given
+	Similarity     float32 // trigram similarity between the query and the title
+	Highlight      string  // code fragment with the matched terms wrapped in <mark>
+}
+
+type SnippetsList struct {
+	Items []SnippetSummary
+	Total int
+}
+
+type TrashedSnippet struct {
 	ID         int64
 	Title      string
-	ProjectURL string
 	GitPath    string
 	GitVersion string
 	Git        Git
 	Language   Language
-	Tags       []Tag
+	DeletedAt  time.Time
+	PurgeAt    time.Time
 }
 
-type SnippetsList struct {
-	Items []SnippetSummary
+type TrashedSnippetList struct {
+	Items []TrashedSnippet
+	Total int
+}
+
+type SnippetRevision struct {
+	Revision   int32
+	Title      string
+	Code       string
+	ProjectURL string
+	GitPath    string
+	GitVersion string
+	Language   Language
+	UserID     int64
+	CreatedAt  time.Time
+}
+
+type SnippetRevisionSum struct {
+	Revision   int32
+	GitVersion string
+	UserID     int64
+	CreatedAt  time.Time
+}
+
+type SnippetRevisionList struct {
+	Items []SnippetRevisionSum
 	Total int
 }
 
+type SnippetDiff struct {
+	From int32
+	To   int32
+	Diff string
+}
+
 type User struct {
 	ID       int64
 	Username string
@@ -80,9 +154,30 @@ type OAuthRedirect struct {
 	ExpiresIn int
 	Interval  int
 }
-type OAuthGithubJWE struct {
+
+// OAuthDeviceJWE is the device flow state handed to the client between the
+// login and poll calls, encrypted so the device code stays secret.
+type OAuthDeviceJWE struct {
+	Provider   string
 	DeviceCode string
 	ExpiresIn  int64
+	Interval   int // seconds the provider asks to wait between polls
+}
+
+// DeviceCode is a pending device authorization at a login provider.
+type DeviceCode struct {
+	DeviceCode      string
+	UserCode        string
+	VerificationURL string
+	ExpiresIn       int // seconds
+	Interval        int // seconds to wait between polls
+}
+
+// ExternalIdentity is the provider account a user signed in with.
+type ExternalIdentity struct {
+	Subject  string // stable account ID at the provider
+	Username string
+	Email    string // verified email
 }
 
 type TokenPair struct {
@@ -108,22 +203,246 @@ type DeviceAuthResult struct {
 	Session *Session
 }
 
+// Identity is an account at a login provider linked to a user.
+type Identity struct {
+	Provider  string
+	Username  string
+	Email     string
+	CreatedAt time.Time
+}
+
+type DeviceLinkResult struct {
+	Status   DeviceAuthStatus
+	Identity *Identity
+}
+
 type ServiceAccessToken struct {
-	ID        int64
-	Name      string
-	Token     string
-	ExpiresAt time.Time
-	IssuedAT  time.Time
+	ID             int64
+	Name           string
+	Token          string
+	OrganizationID int64
+	Scopes         []Scope
+	ExpiresAt      time.Time
+	IssuedAT       time.Time
 }
 
 type ServiceAccessTokenSum struct {
-	ID        int64
-	Name      string
-	ExpiresAt time.Time
-	IssuedAT  time.Time
+	ID             int64
+	Name           string
+	OrganizationID int64
+	Scopes         []Scope
+	ExpiresAt      time.Time
+	IssuedAT       time.Time
+	LastUsedAt     time.Time // zero when the token was never used
+	LastUsedIP     string
+	UsageCount     int64
+}
+
+// Scope limits what a service access token may do.
+type Scope string
+
+const (
+	ScopeSnippetsRead  Scope = "snippets:read"  // reads snippets, tags, languages and organizations
+	ScopeSnippetsWrite Scope = "snippets:write" // ingests, updates, deletes and restores snippets
+	ScopeTokensManage  Scope = "tokens:manage"  // issues, lists and revokes service access tokens
+	ScopeAdmin         Scope = "admin"          // every scope, plus the account and organizations
+)
+
+func (s Scope) Valid() bool {
+	switch s {
+	case ScopeSnippetsRead, ScopeSnippetsWrite, ScopeTokensManage, ScopeAdmin:
+		return true
+	}
+	return false
 }
 
 type ServiceAccessTokenList struct {
 	Items []ServiceAccessTokenSum
 	Total int
 }
+
+// Role is the site-wide role of a user, OrgRole only applies within an
+// organization.
+type Role string
+
+const (
+	RoleAdmin      Role = "admin"      // manages users and moderates every snippet
+	RoleMaintainer Role = "maintainer" // moderates the snippets of other users
+	RoleMember     Role = "member"     // reads and writes their own snippets
+	RoleReadOnly   Role = "read_only"  // reads snippets only
+)
+
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Permission is an action a role may be granted.
+type Permission string
+
+const (
+	PermSnippetsRead     Permission = "snippets:read"
+	PermSnippetsWrite    Permission = "snippets:write"    // ingests, updates and deletes own snippets
+	PermSnippetsModerate Permission = "snippets:moderate" // updates and deletes snippets of other users, takes over orphaned ones
+	PermUsersManage      Permission = "users:manage"      // lists, disables and logs out users, changes their role
+	PermAuditRead        Permission = "audit:read"        // reads the audit log
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin:      {PermSnippetsRead, PermSnippetsWrite, PermSnippetsModerate, PermUsersManage, PermAuditRead},
+	RoleMaintainer: {PermSnippetsRead, PermSnippetsWrite, PermSnippetsModerate},
+	RoleMember:     {PermSnippetsRead, PermSnippetsWrite},
+	RoleReadOnly:   {PermSnippetsRead},
+}
+
+// Can reports whether r grants perm.
+func (r Role) Can(perm Permission) bool {
+	return slices.Contains(rolePermissions[r], perm)
+}
+
+type AdminUser struct {
+	ID         int64
+	Username   string
+	Email      string
+	Role       Role
+	DisabledAt *time.Time
+	CreatedAt  time.Time
+}
+
+type AdminUserList struct {
+	Items []AdminUser
+	Total int
+}
+
+type OrphanedSnippet struct {
+	ID             int64
+	Title          string
+	OrganizationID int64
+	CreatedAt      time.Time
+}
+
+type OrphanedSnippetList struct {
+	Items []OrphanedSnippet
+	Total int
+}
+
+type AuditEvent struct {
+	ID         int64
+	CreatedAt  time.Time
+	ActorID    int64
+	TokenID    int64
+	SessionID  int64
+	IP         string
+	Action     AuditAction
+	TargetType string
+	TargetID   int64
+	Data       json.RawMessage
+	Before     json.RawMessage
+	After      json.RawMessage
+}
+
+type AuditEventList struct {
+	Items      []AuditEvent
+	NextCursor string // empty on the last page
+}
+
+type OrgRole string
+
+const (
+	OrgRoleOwner  OrgRole = "owner"  // manages the organization, its owners and can delete it
+	OrgRoleAdmin  OrgRole = "admin"  // manages members and organization tokens
+	OrgRoleMember OrgRole = "member" // reads and ingests organization snippets
+)
+
+func (r OrgRole) Valid() bool {
+	switch r {
+	case OrgRoleOwner, OrgRoleAdmin, OrgRoleMember:
+		return true
+	}
+	return false
+}
+
+// atLeast reports whether r grants every permission of min.
+func (r OrgRole) atLeast(min OrgRole) bool {
+	rank := map[OrgRole]int{OrgRoleMember: 1, OrgRoleAdmin: 2, OrgRoleOwner: 3}
+	return rank[r] >= rank[min]
+}
+
+type Organization struct {
+	ID        int64
+	Name      string
+	Role      OrgRole // role of the caller in the organization
+	CreatedAt time.Time
+}
+
+type OrganizationList struct {
+	Items []Organization
+	Total int
+}
+
+type OrganizationMember struct {
+	User     User
+	Role     OrgRole
+	JoinedAt time.Time
+}
+
+type OrganizationMemberList struct {
+	Items []OrganizationMember
+	Total int
+}
+
+type UserProfile struct {
+	ID              int64
+	Username        string
+	Email           string
+	DisplayName     string
+	DefaultLanguage string
+	Providers       []string // identity providers the account can sign in with
+	CreatedAt       time.Time
+}
+
+// ClientInfo describes the device a request comes from.
+type ClientInfo struct {
+	DeviceName string
+	UserAgent  string
+	IP         string
+}
+
+// Principal is the caller authenticated by AuthUser.
+type Principal struct {
+	UserID    int64
+	SessionID int64   // set for access tokens
+	TokenID   int64   // set for service access tokens
+	Scopes    []Scope // set for service access tokens, user logins are not limited
+	Role      Role    // role of the user, service access tokens act with the role of their owner
+	// OrganizationID is set for organization service access tokens, which
+	// only act within that organization.
+	OrganizationID int64
+}
+
+// Can reports whether the role of p grants perm.
+func (p Principal) Can(perm Permission) bool {
+	return p.Role.Can(perm)
+}
+
+// HasScope reports whether p may act within sc.
+func (p Principal) HasScope(sc Scope) bool {
+	if p.Scopes == nil {
+		return true
+	}
+	return slices.Contains(p.Scopes, sc) || slices.Contains(p.Scopes, ScopeAdmin)
+}
+
+type DeviceSession struct {
+	ID         int64
+	DeviceName string
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+type DeviceSessionList struct {
+	Items []DeviceSession
+	Total int
+}
@@ -5,132 +5,139 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
 
-	"github.com/beavercli/beaver_api/internal/integrations/github"
 	"github.com/beavercli/beaver_api/internal/storage"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
-	"golang.org/x/sync/errgroup"
 )
 
-func (s *Service) GetDeviceRequest(ctx context.Context) (OAuthRedirect, error) {
-	g, err := s.github.GetDeviceCode(ctx)
+func (s *Service) GetDeviceRequest(ctx context.Context, provider string) (OAuthRedirect, error) {
+	p, err := s.loginProvider(provider)
+	if err != nil {
+		return OAuthRedirect{}, err
+	}
+
+	dc, err := p.GetDeviceCode(ctx)
+	if err != nil {
+		return OAuthRedirect{}, err
+	}
 
-	token, err := s.generateJWE(g)
+	token, err := s.generateJWE(provider, dc)
 	if err != nil {
 		return OAuthRedirect{}, err
 	}
 
 	return OAuthRedirect{
-		URL:       g.VerificationURL,
-		UserCode:  g.UserCode,
-		ExpiresIn: g.ExpiresIn,
-		Interval:  g.Interval,
+		URL:       dc.VerificationURL,
+		UserCode:  dc.UserCode,
+		ExpiresIn: dc.ExpiresIn,
+		Interval:  dc.Interval,
 		Token:     token,
 	}, nil
 }
 
-func (s *Service) GithubDevicePoll(ctx context.Context, jwe string) (DeviceAuthResult, error) {
-	dc, err := s.decryptJWE(jwe)
-	if err != nil {
-		return DeviceAuthResult{}, err
+func (s *Service) DevicePoll(ctx context.Context, provider, jwe string, client ClientInfo) (DeviceAuthResult, error) {
+	id, status, err := s.authenticateDevice(ctx, provider, jwe)
+	if err != nil || status != DeviceAuthDone {
+		return DeviceAuthResult{Status: status, Session: nil}, err
 	}
 
-	if time.Now().Unix() > dc.ExpiresIn {
-		return DeviceAuthResult{Status: DeviceAuthExpired, Session: nil}, nil
+	u, err := s.signIn(ctx, provider, id)
+	if err != nil {
+		return DeviceAuthResult{}, err
 	}
 
-	at, err := s.github.GetAccessToken(ctx, dc.DeviceCode)
+	tp, err := s.startSession(ctx, u.ID, provider, client)
 	if err != nil {
 		return DeviceAuthResult{}, err
 	}
-	if at.Error != "" {
-		return DeviceAuthResult{Status: DeviceAuthPending, Session: nil}, nil
-	}
-
-	g, ctxG := errgroup.WithContext(ctx)
+	s.log.InfoContext(ctx, "user signed in", "user_id", u.ID, "provider", provider)
 
-	var githubUser github.GithubUserPayload
-	g.Go(func() error {
-		var err error
-		githubUser, err = s.github.GetUser(ctxG, at)
-		return err
-	})
-
-	var githubUserEmail github.GithubUserEmailPayload
-	g.Go(func() error {
-		var err error
-		githubUserEmail, err = s.github.GetUserEmail(ctxG, at)
-		return err
-	})
+	return DeviceAuthResult{
+		Status: DeviceAuthDone,
+		Session: &Session{
+			User: User{
+				ID:       u.ID,
+				Email:    u.Email,
+				Username: u.Username,
+			},
+			TokenPair: tp,
+		}}, nil
+}
 
-	if err := g.Wait(); err != nil {
-		return DeviceAuthResult{}, err
-	}
+// defaultDevicePollInterval is the poll interval of RFC 8628 for providers
+// that do not set one.
+const defaultDevicePollInterval = 5 * time.Second
 
-	pwd, err := generateRandomPwd()
+// DevicePollInterval returns how long a client has to wait between polls of
+// the device login in jwe, providers answer faster polls with slow_down.
+func (s *Service) DevicePollInterval(jwe string) (time.Duration, error) {
+	dc, err := s.decryptJWE(jwe)
 	if err != nil {
-		return DeviceAuthResult{}, err
+		return 0, err
+	}
+	if dc.Interval <= 0 {
+		return defaultDevicePollInterval, nil
 	}
+	return time.Duration(dc.Interval) * time.Second, nil
+}
 
-	userID, err := s.db.UpsertUser(ctx, storage.UpsertUserParams{
-		Username:     githubUser.Login,
-		Email:        githubUserEmail.Email,
-		PasswordHash: pwd,
-	})
+// authenticateDevice polls the provider with the device code sealed in jwe.
+// The identity is only set once the status is DeviceAuthDone.
+func (s *Service) authenticateDevice(ctx context.Context, provider, jwe string) (ExternalIdentity, DeviceAuthStatus, error) {
+	p, err := s.loginProvider(provider)
 	if err != nil {
-		return DeviceAuthResult{}, err
+		return ExternalIdentity{}, "", err
 	}
 
-	accessToken, err := s.IssueJWT(AccessToken, userID, AccessTokenTTL)
+	dc, err := s.decryptJWE(jwe)
 	if err != nil {
-		return DeviceAuthResult{}, err
+		return ExternalIdentity{}, "", fmt.Errorf("Device token is invalid: %w", ErrInvalid)
+	}
+	if dc.Provider != provider {
+		return ExternalIdentity{}, "", fmt.Errorf("Device token was issued for another login provider: %w", ErrInvalid)
 	}
 
-	refreshToken, err := s.IssueJWT(RefreshToken, userID, RefreshTokenTTL)
+	if time.Now().Unix() > dc.ExpiresIn {
+		return ExternalIdentity{}, DeviceAuthExpired, nil
+	}
 
-	_, err = s.db.CreateRefreshToken(ctx, storage.CreateRefreshTokenParams{
-		UserID:    pgtype.Int8{Int64: userID, Valid: true},
-		TokenHash: computeHash(refreshToken),
-		IssuedAt:  pgtype.Timestamptz{Time: time.Now(), Valid: true},
-		ExpiresAt: pgtype.Timestamptz{Time: time.Now().Add(RefreshTokenTTL), Valid: true},
-	})
+	id, err := p.Authenticate(ctx, dc.DeviceCode)
 	if err != nil {
-		return DeviceAuthResult{}, err
+		if errors.Is(err, ErrAuthorizationPending) {
+			return ExternalIdentity{}, DeviceAuthPending, nil
+		}
+		return ExternalIdentity{}, "", err
 	}
-
-	return DeviceAuthResult{
-		Status: DeviceAuthDone,
-		Session: &Session{
-			User: User{
-				ID:       userID,
-				Email:    githubUserEmail.Email,
-				Username: githubUser.Login,
-			},
-			TokenPair: TokenPair{
-				AccessToken:  accessToken,
-				RefreshToken: refreshToken,
-			},
-		}}, nil
+	return id, DeviceAuthDone, nil
 }
 
-func (s *Service) RotateTokens(ctx context.Context, userID int64, refreshToken string) (TokenPair, error) {
-	c, err := s.ParseJWT(refreshToken)
+// RotateTokens exchanges a refresh token for a new token pair of the same
+// session. The session is the family of every refresh token rotated from its
+// login: a rotated token stays on record, and presenting one again means it
+// leaked, so the whole family is revoked.
+func (s *Service) RotateTokens(ctx context.Context, refreshToken string, client ClientInfo) (TokenPair, error) {
+	c, err := s.VerifyJWT(refreshToken, RefreshToken)
 	if err != nil {
 		return TokenPair{}, err
 	}
-	// check ExpiredAt in the JWT token
 	tn := time.Now().Unix()
-	if c.Expiry.Time().Unix() < tn {
-		return TokenPair{}, fmt.Errorf("Refresh token expired")
+
+	userID, err := strconv.ParseInt(c.Subject, 10, 64)
+	if err != nil {
+		return TokenPair{}, err
 	}
 
 	// check the token exist in the issued refresh tokens
 	t, err := s.db.GetRefreshTokenByHash(ctx, computeHash(refreshToken))
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return TokenPair{}, fmt.Errorf("Refresh token is revoked")
+		}
 		return TokenPair{}, err
 	}
 	// there is an edge case when we need to update the TTL for the
@@ -139,15 +146,25 @@ func (s *Service) RotateTokens(ctx context.Context, userID int64, refreshToken s
 	if t.ExpiresAt.Time.Unix() < tn {
 		return TokenPair{}, fmt.Errorf("Refresh token expired")
 	}
+	if t.UserID.Int64 != userID || t.SessionID != c.SessionID {
+		return TokenPair{}, fmt.Errorf("Refresh token was issued to another user")
+	}
+	if t.RotatedAt.Valid {
+		return TokenPair{}, s.revokeTokenFamily(ctx, t, client)
+	}
+	if _, err := s.userRole(ctx, userID); err != nil {
+		return TokenPair{}, err
+	}
 
-	// all is good we can issue a new token pair
+	// all is good we can issue a new token pair for the same session
+	sub := TokenSubject{UserID: userID, SessionID: t.SessionID}
 
-	at, err := s.IssueJWT(AccessToken, userID, AccessTokenTTL)
+	at, err := s.IssueJWT(AccessToken, sub, AccessTokenTTL)
 	if err != nil {
 		return TokenPair{}, err
 	}
 
-	rt, err := s.IssueJWT(RefreshToken, userID, RefreshTokenTTL)
+	rt, err := s.IssueJWT(RefreshToken, sub, RefreshTokenTTL)
 	if err != nil {
 		return TokenPair{}, err
 	}
@@ -156,13 +173,21 @@ func (s *Service) RotateTokens(ctx context.Context, userID int64, refreshToken s
 		IsoLevel:   pgx.ReadCommitted,
 		AccessMode: pgx.ReadWrite,
 	}
+	reused := false
 	err = s.inTx(ctx, txOpts, func(db *storage.Queries) error {
-		if err := s.db.DeleteRefreshTokenByID(ctx, t.ID); err != nil {
+		// a concurrent rotation of the same token got here first
+		n, err := db.MarkRefreshTokenRotated(ctx, t.ID)
+		if err != nil {
 			return err
 		}
+		if n == 0 {
+			reused = true
+			return nil
+		}
 
-		if _, err := s.db.CreateRefreshToken(ctx, storage.CreateRefreshTokenParams{
+		if _, err := db.CreateRefreshToken(ctx, storage.CreateRefreshTokenParams{
 			UserID:    pgtype.Int8{Int64: userID, Valid: true},
+			SessionID: t.SessionID,
 			TokenHash: computeHash(rt),
 			IssuedAt:  pgtype.Timestamptz{Time: time.Now(), Valid: true},
 			ExpiresAt: pgtype.Timestamptz{Time: time.Now().Add(RefreshTokenTTL), Valid: true},
@@ -170,11 +195,18 @@ func (s *Service) RotateTokens(ctx context.Context, userID int64, refreshToken s
 			return err
 		}
 
-		return nil
+		return db.TouchSession(ctx, storage.TouchSessionParams{
+			ID:        t.SessionID,
+			UserAgent: pgtype.Text{String: client.UserAgent, Valid: client.UserAgent != ""},
+			IpAddress: pgtype.Text{String: client.IP, Valid: client.IP != ""},
+		})
 	})
 	if err != nil {
 		return TokenPair{}, err
 	}
+	if reused {
+		return TokenPair{}, s.revokeTokenFamily(ctx, t, client)
+	}
 
 	return TokenPair{
 		AccessToken:  at,
@@ -182,69 +214,135 @@ func (s *Service) RotateTokens(ctx context.Context, userID int64, refreshToken s
 	}, nil
 }
 
-func (s *Service) AuthUser(ctx context.Context, tokenType TokenType, token string) (int64, error) {
+// revokeTokenFamily ends the session of a replayed refresh token, which drops
+// every refresh token of the family, and records the security event. The
+// returned error is meant for the client that presented the token.
+func (s *Service) revokeTokenFamily(ctx context.Context, t storage.RefreshToken, client ClientInfo) error {
+	ctx = WithActor(ctx, Actor{UserID: t.UserID.Int64, SessionID: t.SessionID, IP: client.IP})
+	txOpts := pgx.TxOptions{
+		IsoLevel:   pgx.ReadCommitted,
+		AccessMode: pgx.ReadWrite,
+	}
+	err := s.inTx(ctx, txOpts, func(db *storage.Queries) error {
+		if err := db.RevokeSessionByID(ctx, t.SessionID); err != nil {
+			return err
+		}
+
+		return writeAuditEvent(ctx, db, auditEvent{
+			ActorID:    t.UserID.Int64,
+			Action:     AuditRefreshTokenReused,
+			TargetType: "session",
+			TargetID:   t.SessionID,
+			Data: map[string]any{
+				"refresh_token_id": t.ID,
+				"rotated_at":       t.RotatedAt.Time,
+				"user_agent":       client.UserAgent,
+				"ip":               client.IP,
+			},
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	s.log.WarnContext(ctx, "refresh token reused, session revoked",
+		"user_id", t.UserID.Int64,
+		"session_id", t.SessionID,
+		"ip", client.IP,
+	)
+	return fmt.Errorf("Refresh token was already used, the session has been revoked")
+}
+
+func (s *Service) AuthUser(ctx context.Context, tokenType TokenType, token string, client ClientInfo) (Principal, error) {
 	switch tokenType {
 	case AccessToken:
-		return s.handleAccessToken(ctx, token)
+		return s.handleAccessToken(ctx, token, client)
 	case SessionToken:
-		return s.handleSessionToken(ctx, token)
+		return s.handleSessionToken(ctx, token, client)
 	default:
-		return 0, fmt.Errorf("Provided token is not supported yet: %s", tokenType)
+		return Principal{}, &AuthError{
+			Code:        AuthErrInvalidRequest,
+			Description: fmt.Sprintf("Provided token is not supported yet: %s", tokenType),
+		}
 	}
 }
 
-// TODO: Rewrite it to delete refresh token related to the issued access token
-// so in case user have serveral devices we logout them only from the device we got request from.
-func (s *Service) LogoutUser(ctx context.Context, userID int64) error {
-	if err := s.db.DeleteRefreshTokensByUserID(ctx, pgtype.Int8{Int64: userID, Valid: true}); err != nil {
-		return err
-	}
-	return nil
+// LogoutUser ends the session the access token belongs to, other devices
+// stay logged in.
+func (s *Service) LogoutUser(ctx context.Context, userID, sessionID int64) error {
+	return s.RevokeSession(ctx, userID, sessionID)
 }
 
-func (s *Service) handleAccessToken(_ context.Context, token string) (int64, error) {
-	c, err := s.ParseJWT(token)
+func (s *Service) handleAccessToken(ctx context.Context, token string, client ClientInfo) (Principal, error) {
+	c, err := s.VerifyJWT(token, AccessToken)
 	if err != nil {
-		return 0, err
-	}
-
-	if time.Now().After(c.Expiry.Time()) {
-		return 0, fmt.Errorf("Token is expired")
+		return Principal{}, err
 	}
 
 	userID, err := strconv.ParseInt(c.Subject, 10, 64)
 	if err != nil {
-		return 0, err
+		return Principal{}, invalidToken("Token subject is not a user ID")
 	}
 
-	return userID, nil
-}
-func (s *Service) handleSessionToken(ctx context.Context, token string) (int64, error) {
-	c, err := s.ParseJWT(token)
+	if err := s.checkSession(ctx, userID, c.SessionID, client); err != nil {
+		return Principal{}, err
+	}
+	role, err := s.userRole(ctx, userID)
 	if err != nil {
-		return 0, err
+		return Principal{}, err
 	}
 
-	if time.Now().After(c.Expiry.Time()) {
-		return 0, fmt.Errorf("Session token is expired")
+	return Principal{UserID: userID, SessionID: c.SessionID, Role: role}, nil
+}
+func (s *Service) handleSessionToken(ctx context.Context, token string, client ClientInfo) (Principal, error) {
+	c, err := s.VerifyJWT(token, SessionToken)
+	if err != nil {
+		return Principal{}, err
 	}
 
 	userID, err := strconv.ParseInt(c.Subject, 10, 64)
 	if err != nil {
-		return 0, err
+		return Principal{}, invalidToken("Token subject is not a user ID")
 	}
 
 	t, err := s.db.GetServiceAccessTokenByHash(ctx, computeHash(token))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Principal{}, invalidToken("Session token is unknown")
+	}
 	if err != nil {
-		return 0, err
+		return Principal{}, err
 	}
 
 	if time.Now().After(t.ExpiresAt.Time) {
-		return 0, fmt.Errorf("Session token is expired based on the udpated expiry")
+		return Principal{}, invalidToken("Session token is expired based on the updated expiry")
+	}
+	if t.RevokedAt.Valid {
+		return Principal{}, invalidToken("Session token has been revoked")
+	}
+	if t.OrganizationID.Valid {
+		// organization tokens work only while their creator administers it
+		_, err := checkOrgRole(ctx, s.db, t.OrganizationID.Int64, userID, OrgRoleAdmin)
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrForbidden) {
+			return Principal{}, invalidToken("Session token creator is no longer an admin of its organization")
+		}
+		if err != nil {
+			return Principal{}, err
+		}
+	}
+	role, err := s.userRole(ctx, userID)
+	if err != nil {
+		return Principal{}, err
 	}
 
-	return userID, nil
+	s.usage.record(t.ID, client.IP)
 
+	return Principal{
+		UserID:         userID,
+		TokenID:        t.ID,
+		Scopes:         toScopes(t.Scopes),
+		Role:           role,
+		OrganizationID: t.OrganizationID.Int64,
+	}, nil
 }
 
 func computeHash(t string) string {
@@ -0,0 +1,330 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/beavercli/beaver_api/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/sync/errgroup"
+)
+
+// CreateOrganization creates an organization with userID as its first owner.
+func (s *Service) CreateOrganization(ctx context.Context, userID int64, name string) (Organization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Organization{}, fmt.Errorf("organization name is required: %w", ErrInvalid)
+	}
+
+	var o storage.Organization
+	err := s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		var err error
+		o, err = db.CreateOrganization(ctx, name)
+		if err != nil {
+			return err
+		}
+		_, err = db.AddOrganizationMember(ctx, storage.AddOrganizationMemberParams{
+			OrganizationID: o.ID,
+			UserID:         userID,
+			Role:           string(OrgRoleOwner),
+		})
+		return err
+	})
+	if err != nil {
+		return Organization{}, err
+	}
+
+	return toOrganization(o, OrgRoleOwner), nil
+}
+
+func (s *Service) GetOrganization(ctx context.Context, orgID, userID int64) (Organization, error) {
+	role, err := checkOrgRole(ctx, s.db, orgID, userID, OrgRoleMember)
+	if err != nil {
+		return Organization{}, err
+	}
+
+	o, err := s.db.GetOrganizationByID(ctx, orgID)
+	if err != nil {
+		return Organization{}, err
+	}
+	return toOrganization(o, role), nil
+}
+
+// GetOrganizationsPage lists the organizations userID is a member of.
+func (s *Service) GetOrganizationsPage(ctx context.Context, userID int64, p PageParam) (OrganizationList, error) {
+	var rows []storage.ListOrganizationsByUserIDRow
+	var cnt int64
+
+	g, dbCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		rows, err = s.db.ListOrganizationsByUserID(dbCtx, storage.ListOrganizationsByUserIDParams{
+			UserID: userID,
+			Offset: int32(p.Offset()),
+			Limit:  int32(p.Limit()),
+		})
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		cnt, err = s.db.CountOrganizationsByUserID(dbCtx, userID)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return OrganizationList{}, err
+	}
+
+	items := make([]Organization, len(rows))
+	for i, r := range rows {
+		items[i] = Organization{
+			ID:        r.ID,
+			Name:      r.Name,
+			Role:      OrgRole(r.Role),
+			CreatedAt: r.CreatedAt.Time,
+		}
+	}
+	return OrganizationList{Items: items, Total: int(cnt)}, nil
+}
+
+func (s *Service) RenameOrganization(ctx context.Context, orgID, userID int64, name string) (Organization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Organization{}, fmt.Errorf("organization name is required: %w", ErrInvalid)
+	}
+	role, err := checkOrgRole(ctx, s.db, orgID, userID, OrgRoleAdmin)
+	if err != nil {
+		return Organization{}, err
+	}
+
+	o, err := s.db.UpdateOrganization(ctx, storage.UpdateOrganizationParams{ID: orgID, Name: name})
+	if err != nil {
+		return Organization{}, err
+	}
+	return toOrganization(o, role), nil
+}
+
+// DeleteOrganization removes the organization together with its memberships
+// and tokens. Its snippets stay with their owners; team snippets become private.
+func (s *Service) DeleteOrganization(ctx context.Context, orgID, userID int64) error {
+	return s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		if _, err := checkOrgRole(ctx, db, orgID, userID, OrgRoleOwner); err != nil {
+			return err
+		}
+		if err := db.MakeOrganizationSnippetsPrivate(ctx, pgtype.Int8{Int64: orgID, Valid: true}); err != nil {
+			return err
+		}
+		return db.DeleteOrganization(ctx, orgID)
+	})
+}
+
+func (s *Service) GetOrganizationMembersPage(ctx context.Context, orgID, userID int64, p PageParam) (OrganizationMemberList, error) {
+	if _, err := checkOrgRole(ctx, s.db, orgID, userID, OrgRoleMember); err != nil {
+		return OrganizationMemberList{}, err
+	}
+
+	var rows []storage.ListOrganizationMembersRow
+	var cnt int64
+
+	g, dbCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		rows, err = s.db.ListOrganizationMembers(dbCtx, storage.ListOrganizationMembersParams{
+			OrganizationID: orgID,
+			Offset:         int32(p.Offset()),
+			Limit:          int32(p.Limit()),
+		})
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		cnt, err = s.db.CountOrganizationMembers(dbCtx, orgID)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return OrganizationMemberList{}, err
+	}
+
+	items := make([]OrganizationMember, len(rows))
+	for i, r := range rows {
+		items[i] = OrganizationMember{
+			User:     User{ID: r.ID, Username: r.Username, Email: r.Email},
+			Role:     OrgRole(r.Role),
+			JoinedAt: r.CreatedAt.Time,
+		}
+	}
+	return OrganizationMemberList{Items: items, Total: int(cnt)}, nil
+}
+
+type AddOrganizationMemberParam struct {
+	OrganizationID int64
+	ActorID        int64 // user performing the change
+	Username       string
+	Role           OrgRole
+}
+
+// AddOrganizationMember adds an existing user to the organization. Admins can
+// add members and admins, only owners can add other owners.
+func (s *Service) AddOrganizationMember(ctx context.Context, p AddOrganizationMemberParam) error {
+	if !p.Role.Valid() {
+		return fmt.Errorf("invalid role %q: %w", p.Role, ErrInvalid)
+	}
+
+	return s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		actorRole, err := checkOrgRole(ctx, db, p.OrganizationID, p.ActorID, OrgRoleAdmin)
+		if err != nil {
+			return err
+		}
+		if !actorRole.atLeast(p.Role) {
+			return fmt.Errorf("only owners can grant the %s role: %w", p.Role, ErrForbidden)
+		}
+
+		userID, err := db.GetUserIDByUsername(ctx, p.Username)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("user %q: %w", p.Username, ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+
+		n, err := db.AddOrganizationMember(ctx, storage.AddOrganizationMemberParams{
+			OrganizationID: p.OrganizationID,
+			UserID:         userID,
+			Role:           string(p.Role),
+		})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("user %q is already a member of the organization: %w", p.Username, ErrConflict)
+		}
+		return nil
+	})
+}
+
+type UpdateOrganizationMemberParam struct {
+	OrganizationID int64
+	ActorID        int64
+	UserID         int64
+	Role           OrgRole
+}
+
+func (s *Service) UpdateOrganizationMemberRole(ctx context.Context, p UpdateOrganizationMemberParam) error {
+	if !p.Role.Valid() {
+		return fmt.Errorf("invalid role %q: %w", p.Role, ErrInvalid)
+	}
+
+	return s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		actorRole, err := checkOrgRole(ctx, db, p.OrganizationID, p.ActorID, OrgRoleAdmin)
+		if err != nil {
+			return err
+		}
+		current, err := getOrgRole(ctx, db, p.OrganizationID, p.UserID)
+		if err != nil {
+			return err
+		}
+		if !actorRole.atLeast(current) || !actorRole.atLeast(p.Role) {
+			return fmt.Errorf("only owners can change the owner role: %w", ErrForbidden)
+		}
+		if current == OrgRoleOwner && p.Role != OrgRoleOwner {
+			if err := ensureAnotherOwner(ctx, db, p.OrganizationID); err != nil {
+				return err
+			}
+		}
+
+		_, err = db.UpdateOrganizationMemberRole(ctx, storage.UpdateOrganizationMemberRoleParams{
+			OrganizationID: p.OrganizationID,
+			UserID:         p.UserID,
+			Role:           string(p.Role),
+		})
+		return err
+	})
+}
+
+// RemoveOrganizationMember removes userID from the organization. Members can
+// always leave on their own; removing someone else requires the admin role.
+func (s *Service) RemoveOrganizationMember(ctx context.Context, orgID, actorID, userID int64) error {
+	return s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		minRole := OrgRoleAdmin
+		if actorID == userID {
+			minRole = OrgRoleMember
+		}
+		actorRole, err := checkOrgRole(ctx, db, orgID, actorID, minRole)
+		if err != nil {
+			return err
+		}
+		current, err := getOrgRole(ctx, db, orgID, userID)
+		if err != nil {
+			return err
+		}
+		if actorID != userID && !actorRole.atLeast(current) {
+			return fmt.Errorf("only owners can remove an owner: %w", ErrForbidden)
+		}
+		if current == OrgRoleOwner {
+			if err := ensureAnotherOwner(ctx, db, orgID); err != nil {
+				return err
+			}
+		}
+
+		_, err = db.RemoveOrganizationMember(ctx, storage.RemoveOrganizationMemberParams{
+			OrganizationID: orgID,
+			UserID:         userID,
+		})
+		return err
+	})
+}
+
+// checkOrgRole returns the role of userID in the organization and fails with
+// ErrNotFound for non-members, so the existence of the organization is not
+// leaked, and with ErrForbidden when the role is lower than min.
+func checkOrgRole(ctx context.Context, db *storage.Queries, orgID, userID int64, min OrgRole) (OrgRole, error) {
+	role, err := db.GetOrganizationMemberRole(ctx, storage.GetOrganizationMemberRoleParams{
+		OrganizationID: orgID,
+		UserID:         userID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("organization %d: %w", orgID, ErrNotFound)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !OrgRole(role).atLeast(min) {
+		return "", fmt.Errorf("organization %d requires the %s role: %w", orgID, min, ErrForbidden)
+	}
+	return OrgRole(role), nil
+}
+
+func getOrgRole(ctx context.Context, db *storage.Queries, orgID, userID int64) (OrgRole, error) {
+	role, err := db.GetOrganizationMemberRole(ctx, storage.GetOrganizationMemberRoleParams{
+		OrganizationID: orgID,
+		UserID:         userID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("user %d is not a member of organization %d: %w", userID, orgID, ErrNotFound)
+	}
+	return OrgRole(role), err
+}
+
+// ensureAnotherOwner checks the organization keeps an owner when one is
+// demoted or removed. It locks the owners until the transaction ends.
+func ensureAnotherOwner(ctx context.Context, db *storage.Queries, orgID int64) error {
+	owners, err := db.LockOrganizationOwners(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	if len(owners) < 2 {
+		return fmt.Errorf("organization %d must keep at least one owner: %w", orgID, ErrConflict)
+	}
+	return nil
+}
+
+func toOrganization(o storage.Organization, role OrgRole) Organization {
+	return Organization{
+		ID:        o.ID,
+		Name:      o.Name,
+		Role:      role,
+		CreatedAt: o.CreatedAt.Time,
+	}
+}
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/beavercli/beaver_api/internal/integrations/github"
+	"github.com/beavercli/beaver_api/internal/integrations/gitlab"
+	"github.com/beavercli/beaver_api/internal/integrations/oidc"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+)
+
+// ErrAuthorizationPending is returned by LoginProvider.Authenticate until the
+// user approves the device code.
+var ErrAuthorizationPending = errors.New("authorization pending")
+
+// LoginProvider is an identity provider users sign in with through the OAuth
+// device authorization grant.
+type LoginProvider interface {
+	// Name is the provider segment of the /auth/{provider} routes.
+	Name() string
+	GetDeviceCode(ctx context.Context) (DeviceCode, error)
+	// Authenticate exchanges an approved device code for the account of the
+	// user, it returns ErrAuthorizationPending until the user approves it.
+	Authenticate(ctx context.Context, deviceCode string) (ExternalIdentity, error)
+}
+
+func (s *Service) loginProvider(name string) (LoginProvider, error) {
+	p, ok := s.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("login provider %q: %w", name, ErrNotFound)
+	}
+	return p, nil
+}
+
+type githubProvider struct {
+	client GithubOAuthClient
+}
+
+func NewGithubProvider(client GithubOAuthClient) LoginProvider {
+	return &githubProvider{client: client}
+}
+
+func (p *githubProvider) Name() string {
+	return ProviderGitHub
+}
+
+func (p *githubProvider) GetDeviceCode(ctx context.Context) (DeviceCode, error) {
+	g, err := p.client.GetDeviceCode(ctx)
+	if err != nil {
+		return DeviceCode{}, err
+	}
+	return DeviceCode{
+		DeviceCode:      g.DeviceCode,
+		UserCode:        g.UserCode,
+		VerificationURL: g.VerificationURL,
+		ExpiresIn:       g.ExpiresIn,
+		Interval:        g.Interval,
+	}, nil
+}
+
+func (p *githubProvider) Authenticate(ctx context.Context, deviceCode string) (ExternalIdentity, error) {
+	at, err := p.client.GetAccessToken(ctx, deviceCode)
+	if err != nil {
+		return ExternalIdentity{}, err
+	}
+	switch at.Error {
+	case "":
+	case "authorization_pending", "slow_down":
+		return ExternalIdentity{}, ErrAuthorizationPending
+	default:
+		return ExternalIdentity{}, fmt.Errorf("GitHub login failed: %s: %w", at.Error, ErrInvalid)
+	}
+
+	g, ctxG := errgroup.WithContext(ctx)
+
+	var githubUser github.GithubUserPayload
+	g.Go(func() error {
+		var err error
+		githubUser, err = p.client.GetUser(ctxG, at)
+		return err
+	})
+
+	var githubUserEmail github.GithubUserEmailPayload
+	g.Go(func() error {
+		var err error
+		githubUserEmail, err = p.client.GetUserEmail(ctxG, at)
+		return err
+	})
+
+	if err := g.Wait(); err != nil {
+		return ExternalIdentity{}, err
+	}
+	if !githubUserEmail.Verified {
+		return ExternalIdentity{}, fmt.Errorf("The GitHub account has no verified email: %w", ErrInvalid)
+	}
+
+	return ExternalIdentity{
+		Subject:  strconv.FormatInt(githubUser.ID, 10),
+		Username: githubUser.Login,
+		Email:    githubUserEmail.Email,
+	}, nil
+}
+
+type GitlabOAuthClient interface {
+	GetDeviceCode(ctx context.Context) (gitlab.GitlabDevicePayload, error)
+	GetAccessToken(ctx context.Context, dc string) (gitlab.GitlabAccessTokenPayload, error)
+	GetUser(ctx context.Context, t gitlab.GitlabAccessTokenPayload) (gitlab.GitlabUserPayload, error)
+	GetUserEmail(ctx context.Context, t gitlab.GitlabAccessTokenPayload) (gitlab.GitlabUserEmailPayload, error)
+}
+
+type gitlabProvider struct {
+	client GitlabOAuthClient
+}
+
+func NewGitlabProvider(client GitlabOAuthClient) LoginProvider {
+	return &gitlabProvider{client: client}
+}
+
+func (p *gitlabProvider) Name() string {
+	return ProviderGitLab
+}
+
+func (p *gitlabProvider) GetDeviceCode(ctx context.Context) (DeviceCode, error) {
+	g, err := p.client.GetDeviceCode(ctx)
+	if err != nil {
+		return DeviceCode{}, err
+	}
+
+	url := g.VerificationURLComplete
+	if url == "" {
+		url = g.VerificationURL
+	}
+	return DeviceCode{
+		DeviceCode:      g.DeviceCode,
+		UserCode:        g.UserCode,
+		VerificationURL: url,
+		ExpiresIn:       g.ExpiresIn,
+		Interval:        g.Interval,
+	}, nil
+}
+
+func (p *gitlabProvider) Authenticate(ctx context.Context, deviceCode string) (ExternalIdentity, error) {
+	at, err := p.client.GetAccessToken(ctx, deviceCode)
+	if err != nil {
+		return ExternalIdentity{}, err
+	}
+	switch at.Error {
+	case "":
+	case "authorization_pending", "slow_down":
+		return ExternalIdentity{}, ErrAuthorizationPending
+	default:
+		return ExternalIdentity{}, fmt.Errorf("GitLab login failed: %s: %w", at.Error, ErrInvalid)
+	}
+
+	g, ctxG := errgroup.WithContext(ctx)
+
+	var gitlabUser gitlab.GitlabUserPayload
+	g.Go(func() error {
+		var err error
+		gitlabUser, err = p.client.GetUser(ctxG, at)
+		return err
+	})
+
+	var gitlabUserEmail gitlab.GitlabUserEmailPayload
+	g.Go(func() error {
+		var err error
+		gitlabUserEmail, err = p.client.GetUserEmail(ctxG, at)
+		return err
+	})
+
+	if err := g.Wait(); err != nil {
+		return ExternalIdentity{}, err
+	}
+
+	// the primary email is confirmed together with the account
+	email := gitlabUserEmail.Email
+	if gitlabUser.Email != "" && gitlabUser.ConfirmedAt != "" {
+		email = gitlabUser.Email
+	}
+	if email == "" {
+		return ExternalIdentity{}, fmt.Errorf("The GitLab account has no confirmed email: %w", ErrInvalid)
+	}
+
+	return ExternalIdentity{
+		Subject:  strconv.FormatInt(gitlabUser.ID, 10),
+		Username: gitlabUser.Username,
+		Email:    email,
+	}, nil
+}
+
+type OIDCClient interface {
+	GetDeviceCode(ctx context.Context) (oidc.DevicePayload, error)
+	GetToken(ctx context.Context, deviceCode string) (oidc.TokenPayload, error)
+	VerifyIDToken(ctx context.Context, raw string) (oidc.IDTokenClaims, error)
+}
+
+type oidcProvider struct {
+	name   string
+	client OIDCClient
+}
+
+// NewOIDCProvider signs users in with an OpenID Connect issuer, name is the
+// provider segment of its routes.
+func NewOIDCProvider(name string, client OIDCClient) LoginProvider {
+	return &oidcProvider{name: name, client: client}
+}
+
+func (p *oidcProvider) Name() string {
+	return p.name
+}
+
+func (p *oidcProvider) GetDeviceCode(ctx context.Context) (DeviceCode, error) {
+	d, err := p.client.GetDeviceCode(ctx)
+	if err != nil {
+		return DeviceCode{}, err
+	}
+
+	url := d.VerificationURLComplete
+	if url == "" {
+		url = d.VerificationURL
+	}
+	return DeviceCode{
+		DeviceCode:      d.DeviceCode,
+		UserCode:        d.UserCode,
+		VerificationURL: url,
+		ExpiresIn:       d.ExpiresIn,
+		Interval:        d.Interval,
+	}, nil
+}
+
+func (p *oidcProvider) Authenticate(ctx context.Context, deviceCode string) (ExternalIdentity, error) {
+	tp, err := p.client.GetToken(ctx, deviceCode)
+	if err != nil {
+		if errors.Is(err, oidc.ErrAuthorizationPending) {
+			return ExternalIdentity{}, ErrAuthorizationPending
+		}
+		return ExternalIdentity{}, err
+	}
+
+	claims, err := p.client.VerifyIDToken(ctx, tp.IDToken)
+	if err != nil {
+		return ExternalIdentity{}, err
+	}
+	// users are matched by email, an unverified one could take over an account
+	if claims.Email == "" || !claims.EmailVerified {
+		return ExternalIdentity{}, fmt.Errorf("The %s account has no verified email: %w", p.name, ErrInvalid)
+	}
+
+	username := claims.PreferredUsername
+	if username == "" {
+		username, _, _ = strings.Cut(claims.Email, "@")
+	}
+	return ExternalIdentity{
+		Subject:  claims.Subject,
+		Username: username,
+		Email:    claims.Email,
+	}, nil
+}
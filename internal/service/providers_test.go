@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/beavercli/beaver_api/internal/integrations/oidc"
+	"github.com/beavercli/beaver_api/internal/integrations/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCProvider(t *testing.T) {
+	srv := oidctest.NewServer("beaver")
+	defer srv.Close()
+
+	ctx := context.Background()
+	p := NewOIDCProvider("keycloak", oidc.New(srv.Issuer(), "beaver", "", time.Second))
+
+	dc, err := p.GetDeviceCode(ctx)
+	require.NoError(t, err)
+	_, err = p.Authenticate(ctx, dc.DeviceCode)
+	assert.ErrorIs(t, err, ErrAuthorizationPending)
+
+	srv.Approve(dc.UserCode, oidctest.User{Subject: "42", Email: "ada@example.com", EmailVerified: true})
+	id, err := p.Authenticate(ctx, dc.DeviceCode)
+	require.NoError(t, err)
+	assert.Equal(t, ExternalIdentity{Subject: "42", Username: "ada", Email: "ada@example.com"}, id)
+
+	// accounts are matched by email, so an unverified one is refused
+	dc, err = p.GetDeviceCode(ctx)
+	require.NoError(t, err)
+	srv.Approve(dc.UserCode, oidctest.User{Subject: "43", Email: "eve@example.com"})
+	_, err = p.Authenticate(ctx, dc.DeviceCode)
+	assert.ErrorContains(t, err, "no verified email")
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/beavercli/beaver_api/internal/storage"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RateLimit is a token bucket holding up to Requests tokens, refilled at a
+// rate of Requests per Per. Every request takes a token.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// perSecond is the refill rate of the bucket.
+func (l RateLimit) perSecond() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int           // requests left in the bucket
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request is allowed, set when it is not
+}
+
+// RateLimitStore keeps the token buckets of the rate limiter.
+type RateLimitStore interface {
+	// Take takes a token from the bucket of key, creating a full one for
+	// unknown keys.
+	Take(ctx context.Context, key string, l RateLimit) (RateLimitResult, error)
+}
+
+// rateLimitSweepInterval is how often the stores drop the buckets that have
+// refilled, which are equal to new ones.
+const rateLimitSweepInterval = time.Minute
+
+func toRateLimitResult(l RateLimit, tokens float64, allowed bool) RateLimitResult {
+	r := RateLimitResult{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     secondsToDuration((float64(l.Requests) - tokens) / l.perSecond()),
+	}
+	if !allowed {
+		r.RetryAfter = secondsToDuration((1 - tokens) / l.perSecond())
+	}
+	return r
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(math.Max(s, 0) * float64(time.Second))
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+// memoryRateLimitStore keeps the buckets in process, every API instance
+// limits the requests it serves on its own.
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{buckets: map[string]memoryBucket{}, lastSweep: time.Now()}
+}
+
+func (m *memoryRateLimitStore) Take(ctx context.Context, key string, l RateLimit) (RateLimitResult, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.lastSweep) >= rateLimitSweepInterval {
+		for k, b := range m.buckets {
+			if !now.Before(b.fullAt) {
+				delete(m.buckets, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	burst := float64(l.Requests)
+	b, ok := m.buckets[key]
+	if !ok {
+		b = memoryBucket{tokens: burst, updatedAt: now}
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updatedAt).Seconds()*l.perSecond())
+	b.updatedAt = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.fullAt = now.Add(secondsToDuration((burst - b.tokens) / l.perSecond()))
+	m.buckets[key] = b
+
+	return toRateLimitResult(l, b.tokens, allowed), nil
+}
+
+// minRateLimitBucketTTL is the shortest time the Postgres store keeps a bucket
+// no request took a token from, it covers the device login polls.
+const minRateLimitBucketTTL = time.Hour
+
+// postgresRateLimitStore keeps the buckets in the database, so the limits hold
+// across every API instance.
+type postgresRateLimitStore struct {
+	db        *storage.Queries
+	ttl       time.Duration
+	lastSweep atomic.Int64 // unix seconds
+}
+
+// NewPostgresRateLimitStore returns a store for limits with periods up to
+// longestPeriod. It has no record of the limit of a bucket, so it keeps idle
+// buckets for the longest period, by when every one of them has refilled.
+func NewPostgresRateLimitStore(pool *pgxpool.Pool, longestPeriod time.Duration) RateLimitStore {
+	s := &postgresRateLimitStore{
+		db:  storage.New(pool),
+		ttl: max(longestPeriod, minRateLimitBucketTTL),
+	}
+	s.lastSweep.Store(time.Now().Unix())
+	return s
+}
+
+func (p *postgresRateLimitStore) Take(ctx context.Context, key string, l RateLimit) (RateLimitResult, error) {
+	p.sweep(ctx)
+
+	b, err := p.db.TakeRateLimitToken(ctx, storage.TakeRateLimitTokenParams{
+		Key:   key,
+		Burst: float64(l.Requests),
+		Rate:  l.perSecond(),
+	})
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return toRateLimitResult(l, b.Tokens, b.Allowed), nil
+}
+
+// sweep drops the idle buckets, at most once per interval across the requests
+// of this instance.
+func (p *postgresRateLimitStore) sweep(ctx context.Context) {
+	last := p.lastSweep.Load()
+	now := time.Now()
+	if now.Sub(time.Unix(last, 0)) < rateLimitSweepInterval || !p.lastSweep.CompareAndSwap(last, now.Unix()) {
+		return
+	}
+	// a failed sweep is retried with the next one, the request goes on
+	_, _ = p.db.DeleteIdleRateLimitBuckets(ctx, pgtype.Timestamptz{Time: now.Add(-p.ttl), Valid: true})
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryRateLimitStore()
+	l := RateLimit{Requests: 3, Per: time.Hour}
+
+	for want := 2; want >= 0; want-- {
+		res, err := store.Take(ctx, "user:1:write", l)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, want, res.Remaining)
+	}
+
+	res, err := store.Take(ctx, "user:1:write", l)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	// one token refills every 20 minutes
+	assert.InDelta(t, 20*time.Minute, res.RetryAfter, float64(time.Second))
+	assert.InDelta(t, time.Hour, res.Reset, float64(time.Second))
+
+	// buckets are per key
+	res, err = store.Take(ctx, "user:2:write", l)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	// a fast bucket refills between requests
+	fast := RateLimit{Requests: 1, Per: 10 * time.Millisecond}
+	res, err = store.Take(ctx, "device:1", fast)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	res, err = store.Take(ctx, "device:1", fast)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	time.Sleep(15 * time.Millisecond)
+	res, err = store.Take(ctx, "device:1", fast)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+}
@@ -2,44 +2,88 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/beavercli/beaver_api/internal/storage"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"golang.org/x/sync/errgroup"
 )
 
 type CreateServiceAccessTokenArgs struct {
-	UserID    int64
-	Name      string
-	ExpiresAt time.Duration
+	UserID         int64
+	OrganizationID *int64 // requires the admin role in the organization
+	Name           string
+	ExpiresAt      time.Duration
+	Scopes         []Scope
+	Grantor        Principal // caller, a token cannot grant scopes it does not have
 }
 
 func (s *Service) CreateServceAccessToken(ctx context.Context, args CreateServiceAccessTokenArgs) (ServiceAccessToken, error) {
-	t, err := s.IssueJWT(SessionToken, args.UserID, args.ExpiresAt)
+	if orgID := args.Grantor.OrganizationID; orgID != 0 && (args.OrganizationID == nil || *args.OrganizationID != orgID) {
+		return ServiceAccessToken{}, fmt.Errorf("the token can only create tokens of organization %d: %w", orgID, ErrForbidden)
+	}
+	if args.OrganizationID != nil {
+		if _, err := checkOrgRole(ctx, s.db, *args.OrganizationID, args.UserID, OrgRoleAdmin); err != nil {
+			return ServiceAccessToken{}, err
+		}
+	}
+
+	scopes, err := grantableScopes(args.Scopes, args.Grantor)
+	if err != nil {
+		return ServiceAccessToken{}, err
+	}
+
+	t, err := s.IssueJWT(SessionToken, TokenSubject{UserID: args.UserID, Scopes: scopes}, args.ExpiresAt)
 	if err != nil {
 		return ServiceAccessToken{}, err
 	}
 	arg := storage.CreateServiceAccessTokenParams{
-		Name:      args.Name,
-		TokenHash: computeHash(t),
-		UserID:    pgtype.Int8{Int64: args.UserID, Valid: true},
-		IssuedAt:  pgtype.Timestamptz{Time: time.Now(), Valid: true},
-		ExpiresAt: pgtype.Timestamptz{Time: time.Now().Add(args.ExpiresAt), Valid: true},
-	}
-	fmt.Println(arg)
-	st, err := s.db.CreateServiceAccessToken(ctx, arg)
+		Name:           args.Name,
+		TokenHash:      computeHash(t),
+		UserID:         pgtype.Int8{Int64: args.UserID, Valid: true},
+		OrganizationID: optionalInt8(args.OrganizationID),
+		Scopes:         fromScopes(scopes),
+		IssuedAt:       pgtype.Timestamptz{Time: time.Now(), Valid: true},
+		ExpiresAt:      pgtype.Timestamptz{Time: time.Now().Add(args.ExpiresAt), Valid: true},
+	}
+	s.log.DebugContext(ctx, "creating service access token",
+		"user_id", args.UserID,
+		"organization_id", arg.OrganizationID.Int64,
+		"name", arg.Name,
+		"scopes", arg.Scopes,
+		"expires_at", arg.ExpiresAt.Time,
+	)
+	var st storage.ServiceAccessToken
+	err = s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		var err error
+		st, err = db.CreateServiceAccessToken(ctx, arg)
+		if err != nil {
+			return err
+		}
+		return writeAuditEvent(ctx, db, auditEvent{
+			ActorID:    args.UserID,
+			Action:     AuditTokenCreated,
+			TargetType: "service_access_token",
+			TargetID:   st.ID,
+			After:      toTokenAuditState(st),
+		})
+	})
 	if err != nil {
 		return ServiceAccessToken{}, err
 	}
 
 	return ServiceAccessToken{
-		ID:        st.ID,
-		Name:      st.Name,
-		ExpiresAt: st.ExpiresAt.Time,
-		IssuedAT:  st.IssuedAt.Time,
-		Token:     t,
+		ID:             st.ID,
+		Name:           st.Name,
+		OrganizationID: st.OrganizationID.Int64,
+		Scopes:         toScopes(st.Scopes),
+		ExpiresAt:      st.ExpiresAt.Time,
+		IssuedAT:       st.IssuedAt.Time,
+		Token:          t,
 	}, nil
 }
 
@@ -69,22 +113,297 @@ func (s *Service) ListServiceAccessTokens(ctx context.Context, userID int64, pag
 	}, nil
 }
 
-func (s *Service) DeleteServiceAccessToken(ctx context.Context, tokenID int64) error {
-	if err := s.db.DeleteServiceAccessTokenByID(ctx, tokenID); err != nil {
+// ListOrganizationServiceAccessTokens lists the tokens issued for an
+// organization by any of its admins.
+func (s *Service) ListOrganizationServiceAccessTokens(ctx context.Context, orgID, userID int64, page PageParam) (ServiceAccessTokenList, error) {
+	if _, err := checkOrgRole(ctx, s.db, orgID, userID, OrgRoleAdmin); err != nil {
+		return ServiceAccessTokenList{}, err
+	}
+
+	var at []storage.ServiceAccessToken
+	var cnt int64
+
+	g, dbCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		at, err = s.db.ListServiceAccessTokensByOrganizationID(dbCtx, storage.ListServiceAccessTokensByOrganizationIDParams{
+			OrganizationID: pgtype.Int8{Int64: orgID, Valid: true},
+			Offset:         int32(page.Offset()),
+			Limit:          int32(page.Limit()),
+		})
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		cnt, err = s.db.CountServiceAccessTokensByOrganizationID(dbCtx, pgtype.Int8{Int64: orgID, Valid: true})
+		return err
+	})
+
+	if err := g.Wait(); err != nil {
+		return ServiceAccessTokenList{}, err
+	}
+
+	return ServiceAccessTokenList{
+		Total: int(cnt),
+		Items: toServiceAccessTokenSum(at),
+	}, nil
+}
+
+type ListStaleServiceAccessTokensParam struct {
+	UserID       int64
+	UnusedDays   int // tokens not used for this many days, 0 to skip
+	ExpiringDays int // tokens expiring within this many days, 0 to skip
+	Page         PageParam
+}
+
+// ListStaleServiceAccessTokens lists the tokens of a user that were not used
+// or are about to expire. Usage is stored periodically, so a token used within
+// the last flush interval can still be reported as unused.
+func (s *Service) ListStaleServiceAccessTokens(ctx context.Context, arg ListStaleServiceAccessTokensParam) (ServiceAccessTokenList, error) {
+	if arg.UnusedDays <= 0 && arg.ExpiringDays <= 0 {
+		return ServiceAccessTokenList{}, fmt.Errorf("Either unused or expiring days must be set: %w", ErrInvalid)
+	}
+
+	now := time.Now()
+	userID := pgtype.Int8{Int64: arg.UserID, Valid: true}
+	var unusedSince, expiresBefore pgtype.Timestamptz
+	if arg.UnusedDays > 0 {
+		unusedSince = pgtype.Timestamptz{Time: now.AddDate(0, 0, -arg.UnusedDays), Valid: true}
+	}
+	if arg.ExpiringDays > 0 {
+		expiresBefore = pgtype.Timestamptz{Time: now.AddDate(0, 0, arg.ExpiringDays), Valid: true}
+	}
+
+	var at []storage.ServiceAccessToken
+	var cnt int64
+
+	g, dbCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		at, err = s.db.ListStaleServiceAccessTokens(dbCtx, storage.ListStaleServiceAccessTokensParams{
+			UserID:        userID,
+			UnusedSince:   unusedSince,
+			ExpiresBefore: expiresBefore,
+			Offset:        int32(arg.Page.Offset()),
+			Limit:         int32(arg.Page.Limit()),
+		})
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		cnt, err = s.db.CountStaleServiceAccessTokens(dbCtx, storage.CountStaleServiceAccessTokensParams{
+			UserID:        userID,
+			UnusedSince:   unusedSince,
+			ExpiresBefore: expiresBefore,
+		})
 		return err
+	})
+
+	if err := g.Wait(); err != nil {
+		return ServiceAccessTokenList{}, err
 	}
 
-	return nil
+	return ServiceAccessTokenList{
+		Total: int(cnt),
+		Items: toServiceAccessTokenSum(at),
+	}, nil
 }
+
+// RevokeServiceAccessToken revokes a token of userID, or of an organization
+// userID is an admin of. The token is kept in the revoked state for auditing.
+func (s *Service) RevokeServiceAccessToken(ctx context.Context, tokenID, userID int64) error {
+	t, err := s.getManagedServiceAccessToken(ctx, tokenID, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		n, err := db.RevokeServiceAccessToken(ctx, storage.RevokeServiceAccessTokenParams{
+			ID:        tokenID,
+			RevokedBy: pgtype.Int8{Int64: userID, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("service access token %d: %w", tokenID, ErrNotFound)
+		}
+
+		before := toTokenAuditState(t)
+		after := before
+		after.Revoked = true
+		return writeAuditEvent(ctx, db, auditEvent{
+			ActorID:    userID,
+			Action:     AuditTokenRevoked,
+			TargetType: "service_access_token",
+			TargetID:   tokenID,
+			Before:     before,
+			After:      after,
+		})
+	})
+}
+
+// tokenAuditState is what the audit log keeps of a service access token,
+// never its secret or hash.
+type tokenAuditState struct {
+	Name           string    `json:"name"`
+	OwnerID        int64     `json:"owner_id,omitempty"`
+	OrganizationID int64     `json:"organization_id,omitempty"`
+	Scopes         []string  `json:"scopes"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	Revoked        bool      `json:"revoked,omitempty"`
+}
+
+func toTokenAuditState(t storage.ServiceAccessToken) tokenAuditState {
+	return tokenAuditState{
+		Name:           t.Name,
+		OwnerID:        t.UserID.Int64,
+		OrganizationID: t.OrganizationID.Int64,
+		Scopes:         t.Scopes,
+		ExpiresAt:      t.ExpiresAt.Time,
+		Revoked:        t.RevokedAt.Valid,
+	}
+}
+
 func toServiceAccessTokenSum(sts []storage.ServiceAccessToken) []ServiceAccessTokenSum {
 	s := make([]ServiceAccessTokenSum, len(sts))
 	for i, st := range sts {
 		s[i] = ServiceAccessTokenSum{
-			ID:        st.ID,
-			Name:      st.Name,
-			ExpiresAt: st.ExpiresAt.Time,
-			IssuedAT:  st.IssuedAt.Time,
+			ID:             st.ID,
+			Name:           st.Name,
+			OrganizationID: st.OrganizationID.Int64,
+			Scopes:         toScopes(st.Scopes),
+			ExpiresAt:      st.ExpiresAt.Time,
+			IssuedAT:       st.IssuedAt.Time,
+			LastUsedAt:     st.LastUsedAt.Time,
+			LastUsedIP:     st.LastUsedIp.String,
+			UsageCount:     st.UsageCount,
 		}
 	}
 	return s
 }
+
+// RotateServiceAccessToken issues a new secret for a token, keeping its name,
+// scopes and expiry. The replaced secret stays valid for the configured grace
+// period so it can be swapped without downtime; rotating again within that
+// period ends it early.
+func (s *Service) RotateServiceAccessToken(ctx context.Context, tokenID int64, grantor Principal) (ServiceAccessToken, error) {
+	t, err := s.getManagedServiceAccessToken(ctx, tokenID, grantor.UserID)
+	if err != nil {
+		return ServiceAccessToken{}, err
+	}
+
+	scopes := toScopes(t.Scopes)
+	for _, sc := range scopes {
+		if !grantor.HasScope(sc) {
+			return ServiceAccessToken{}, fmt.Errorf("cannot grant the %s scope: %w", sc, ErrForbidden)
+		}
+	}
+
+	ttl := time.Until(t.ExpiresAt.Time)
+	if ttl <= 0 {
+		return ServiceAccessToken{}, fmt.Errorf("Expired service access tokens cannot be rotated: %w", ErrInvalid)
+	}
+
+	secret, err := s.IssueJWT(SessionToken, TokenSubject{UserID: t.UserID.Int64, Scopes: scopes}, ttl)
+	if err != nil {
+		return ServiceAccessToken{}, err
+	}
+
+	graceEnd := time.Now().Add(s.conf.TokenRotationGracePeriod)
+	var st storage.ServiceAccessToken
+	err = s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		var err error
+		st, err = db.RotateServiceAccessToken(ctx, storage.RotateServiceAccessTokenParams{
+			ID:                     t.ID,
+			TokenHash:              computeHash(secret),
+			PreviousTokenExpiresAt: pgtype.Timestamptz{Time: graceEnd, Valid: true},
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("service access token %d: %w", tokenID, ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		return writeAuditEvent(ctx, db, auditEvent{
+			ActorID:    grantor.UserID,
+			Action:     AuditTokenRotated,
+			TargetType: "service_access_token",
+			TargetID:   st.ID,
+			Data:       map[string]any{"previous_secret_expires_at": graceEnd},
+		})
+	})
+	if err != nil {
+		return ServiceAccessToken{}, err
+	}
+
+	return ServiceAccessToken{
+		ID:             st.ID,
+		Name:           st.Name,
+		OrganizationID: st.OrganizationID.Int64,
+		Scopes:         toScopes(st.Scopes),
+		ExpiresAt:      st.ExpiresAt.Time,
+		IssuedAT:       st.IssuedAt.Time,
+		Token:          secret,
+	}, nil
+}
+
+// getManagedServiceAccessToken returns a token userID may manage: their own,
+// or one of an organization they are an admin of.
+func (s *Service) getManagedServiceAccessToken(ctx context.Context, tokenID, userID int64) (storage.ServiceAccessToken, error) {
+	t, err := s.db.GetServiceAccessTokenByID(ctx, tokenID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storage.ServiceAccessToken{}, fmt.Errorf("service access token %d: %w", tokenID, ErrNotFound)
+		}
+		return storage.ServiceAccessToken{}, err
+	}
+
+	if t.UserID.Int64 != userID {
+		// tokens of other users are not disclosed
+		if !t.OrganizationID.Valid {
+			return storage.ServiceAccessToken{}, fmt.Errorf("service access token %d: %w", tokenID, ErrNotFound)
+		}
+		if _, err := checkOrgRole(ctx, s.db, t.OrganizationID.Int64, userID, OrgRoleAdmin); err != nil {
+			return storage.ServiceAccessToken{}, err
+		}
+	}
+	return t, nil
+}
+
+// grantableScopes validates the requested scopes and drops duplicates.
+func grantableScopes(scopes []Scope, grantor Principal) ([]Scope, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("At least one scope is required: %w", ErrInvalid)
+	}
+
+	out := make([]Scope, 0, len(scopes))
+	for _, sc := range scopes {
+		if !sc.Valid() {
+			return nil, fmt.Errorf("Unknown scope %q: %w", sc, ErrInvalid)
+		}
+		if !grantor.HasScope(sc) {
+			return nil, fmt.Errorf("cannot grant the %s scope: %w", sc, ErrForbidden)
+		}
+		if !slices.Contains(out, sc) {
+			out = append(out, sc)
+		}
+	}
+	return out, nil
+}
+
+func toScopes(ss []string) []Scope {
+	scopes := make([]Scope, len(ss))
+	for i, s := range ss {
+		scopes[i] = Scope(s)
+	}
+	return scopes
+}
+
+func fromScopes(scopes []Scope) []string {
+	ss := make([]string, len(scopes))
+	for i, sc := range scopes {
+		ss[i] = string(sc)
+	}
+	return ss
+}
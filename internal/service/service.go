@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"log/slog"
+	"time"
 
 	"github.com/beavercli/beaver_api/internal/integrations/github"
 	"github.com/beavercli/beaver_api/internal/storage"
@@ -10,7 +12,13 @@ import (
 )
 
 type Config struct {
-	Secret []byte
+	SigningKeys              []SigningKey // JWT keyring
+	ActiveKeyID              string       // signs new JWTs
+	JWEKey                   []byte       // encrypts the device-flow state, a dedicated key that is none of SigningKeys
+	TrashRetention           time.Duration
+	TokenRotationGracePeriod time.Duration // how long a rotated service access token secret stays valid
+	AdminEmails              []string      // users signing in with these emails are made admins
+	Logger                   *slog.Logger  // slog.Default() when nil
 }
 
 type GithubOAuthClient interface {
@@ -21,19 +29,30 @@ type GithubOAuthClient interface {
 }
 
 type Service struct {
-	conf   Config
-	github GithubOAuthClient
-	pool   *pgxpool.Pool
-	db     *storage.Queries
+	conf      Config
+	providers map[string]LoginProvider
+	pool      *pgxpool.Pool
+	db        *storage.Queries
+	usage     *tokenUsage
+	log       *slog.Logger
 }
 
-func New(pool *pgxpool.Pool, c Config, github GithubOAuthClient) *Service {
-	return &Service{
-		conf:   c,
-		github: github,
-		pool:   pool,
-		db:     storage.New(pool),
+func New(pool *pgxpool.Pool, c Config, providers ...LoginProvider) *Service {
+	s := &Service{
+		conf:      c,
+		providers: map[string]LoginProvider{},
+		pool:      pool,
+		db:        storage.New(pool),
+		usage:     newTokenUsage(),
+		log:       c.Logger,
 	}
+	if s.log == nil {
+		s.log = slog.Default()
+	}
+	for _, p := range providers {
+		s.providers[p.Name()] = p
+	}
+	return s
 }
 
 func (s *Service) inTx(ctx context.Context, txOpts pgx.TxOptions, fn func(q *storage.Queries) error) error {
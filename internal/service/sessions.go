@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/beavercli/beaver_api/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/sync/errgroup"
+)
+
+// sessionTouchInterval limits how often requests made with an access token
+// update the last_used_at of their session.
+const sessionTouchInterval = time.Minute
+
+// startSession records a new login from client with provider and issues its
+// first token pair.
+func (s *Service) startSession(ctx context.Context, userID int64, provider string, client ClientInfo) (TokenPair, error) {
+	var tp TokenPair
+	err := s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		ss, err := db.CreateSession(ctx, storage.CreateSessionParams{
+			UserID:     userID,
+			DeviceName: pgtype.Text{String: client.DeviceName, Valid: client.DeviceName != ""},
+			UserAgent:  pgtype.Text{String: client.UserAgent, Valid: client.UserAgent != ""},
+			IpAddress:  pgtype.Text{String: client.IP, Valid: client.IP != ""},
+		})
+		if err != nil {
+			return err
+		}
+
+		sub := TokenSubject{UserID: userID, SessionID: ss.ID}
+		tp.AccessToken, err = s.IssueJWT(AccessToken, sub, AccessTokenTTL)
+		if err != nil {
+			return err
+		}
+		tp.RefreshToken, err = s.IssueJWT(RefreshToken, sub, RefreshTokenTTL)
+		if err != nil {
+			return err
+		}
+
+		if _, err := db.CreateRefreshToken(ctx, storage.CreateRefreshTokenParams{
+			UserID:    pgtype.Int8{Int64: userID, Valid: true},
+			SessionID: ss.ID,
+			TokenHash: computeHash(tp.RefreshToken),
+			IssuedAt:  pgtype.Timestamptz{Time: time.Now(), Valid: true},
+			ExpiresAt: pgtype.Timestamptz{Time: time.Now().Add(RefreshTokenTTL), Valid: true},
+		}); err != nil {
+			return err
+		}
+
+		actx := WithActor(ctx, Actor{UserID: userID, SessionID: ss.ID, IP: client.IP})
+		return writeAuditEvent(actx, db, auditEvent{
+			Action:     AuditLogin,
+			TargetType: "session",
+			TargetID:   ss.ID,
+			Data: map[string]any{
+				"provider":    provider,
+				"device_name": client.DeviceName,
+				"user_agent":  client.UserAgent,
+			},
+		})
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return tp, nil
+}
+
+// checkSession rejects access tokens of revoked sessions and keeps
+// last_used_at roughly up to date without writing on every request.
+func (s *Service) checkSession(ctx context.Context, userID, sessionID int64, client ClientInfo) error {
+	if sessionID == 0 {
+		return invalidToken("Token is not bound to a session, please log in again")
+	}
+
+	ss, err := s.db.GetSession(ctx, storage.GetSessionParams{ID: sessionID, UserID: userID})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return invalidToken("Session has been revoked")
+	}
+	if err != nil {
+		return err
+	}
+
+	if time.Since(ss.LastUsedAt.Time) < sessionTouchInterval {
+		return nil
+	}
+	return s.db.TouchSession(ctx, storage.TouchSessionParams{
+		ID:        sessionID,
+		UserAgent: pgtype.Text{String: client.UserAgent, Valid: client.UserAgent != ""},
+		IpAddress: pgtype.Text{String: client.IP, Valid: client.IP != ""},
+	})
+}
+
+// GetSessionsPage lists the sessions of userID that can still be refreshed.
+func (s *Service) GetSessionsPage(ctx context.Context, userID int64, p PageParam) (DeviceSessionList, error) {
+	activeSince := pgtype.Timestamptz{Time: time.Now().Add(-RefreshTokenTTL), Valid: true}
+
+	var rows []storage.Session
+	var cnt int64
+
+	g, dbCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		rows, err = s.db.ListActiveSessions(dbCtx, storage.ListActiveSessionsParams{
+			UserID:     userID,
+			LastUsedAt: activeSince,
+			Offset:     int32(p.Offset()),
+			Limit:      int32(p.Limit()),
+		})
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		cnt, err = s.db.CountActiveSessions(dbCtx, storage.CountActiveSessionsParams{
+			UserID:     userID,
+			LastUsedAt: activeSince,
+		})
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return DeviceSessionList{}, err
+	}
+
+	items := make([]DeviceSession, len(rows))
+	for i, r := range rows {
+		items[i] = DeviceSession{
+			ID:         r.ID,
+			DeviceName: r.DeviceName.String,
+			UserAgent:  r.UserAgent.String,
+			IP:         r.IpAddress.String,
+			CreatedAt:  r.CreatedAt.Time,
+			LastUsedAt: r.LastUsedAt.Time,
+		}
+	}
+	return DeviceSessionList{Items: items, Total: int(cnt)}, nil
+}
+
+// RevokeSession ends a session of userID together with its refresh tokens.
+// Access tokens issued for it are rejected from then on.
+func (s *Service) RevokeSession(ctx context.Context, userID, sessionID int64) error {
+	return s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		n, err := db.DeleteSession(ctx, storage.DeleteSessionParams{ID: sessionID, UserID: userID})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("session %d: %w", sessionID, ErrNotFound)
+		}
+
+		return writeAuditEvent(ctx, db, auditEvent{
+			ActorID:    userID,
+			Action:     AuditSessionRevoked,
+			TargetType: "session",
+			TargetID:   sessionID,
+		})
+	})
+}
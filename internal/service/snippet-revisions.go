@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/beavercli/beaver_api/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/sync/errgroup"
+)
+
+func (s *Service) GetSnippetRevisionsPage(ctx context.Context, snippetID int64, viewerID *int64, p PageParam) (SnippetRevisionList, error) {
+	if err := s.checkSnippetVisible(ctx, snippetID, viewerID); err != nil {
+		return SnippetRevisionList{}, err
+	}
+
+	var revs []storage.ListSnippetRevisionsRow
+	var total int64
+
+	g, _ := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		revs, err = s.db.ListSnippetRevisions(ctx, storage.ListSnippetRevisionsParams{
+			SnippetID: snippetID,
+			Offset:    int32(p.Offset()),
+			Limit:     int32(p.Limit()),
+		})
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		total, err = s.db.CountSnippetRevisions(ctx, snippetID)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return SnippetRevisionList{}, err
+	}
+
+	items := make([]SnippetRevisionSum, len(revs))
+	for i, r := range revs {
+		items[i] = SnippetRevisionSum{
+			Revision:   r.Revision,
+			GitVersion: r.GitVersion.String,
+			UserID:     r.UserID.Int64,
+			CreatedAt:  r.CreatedAt.Time,
+		}
+	}
+	return SnippetRevisionList{
+		Total: int(total),
+		Items: items,
+	}, nil
+}
+
+func (s *Service) GetSnippetRevision(ctx context.Context, snippetID int64, revision int32, viewerID *int64) (SnippetRevision, error) {
+	if err := s.checkSnippetVisible(ctx, snippetID, viewerID); err != nil {
+		return SnippetRevision{}, err
+	}
+	return s.getSnippetRevision(ctx, snippetID, revision)
+}
+
+func (s *Service) getSnippetRevision(ctx context.Context, snippetID int64, revision int32) (SnippetRevision, error) {
+	r, err := s.db.GetSnippetRevision(ctx, storage.GetSnippetRevisionParams{
+		SnippetID: snippetID,
+		Revision:  revision,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return SnippetRevision{}, fmt.Errorf("snippet %d revision %d: %w", snippetID, revision, ErrNotFound)
+	}
+	if err != nil {
+		return SnippetRevision{}, err
+	}
+
+	return SnippetRevision{
+		Revision:   r.Revision,
+		Title:      r.Title.String,
+		Code:       r.Code.String,
+		ProjectURL: r.ProjectUrl.String,
+		GitPath:    r.GitFilePath.String,
+		GitVersion: r.GitVersion.String,
+		Language: Language{
+			ID:   r.LanguageID.Int64,
+			Name: r.LanguageName.String,
+		},
+		UserID:    r.UserID.Int64,
+		CreatedAt: r.CreatedAt.Time,
+	}, nil
+}
+
+// DiffSnippetRevisions returns a unified diff of the code between two
+// revisions of the same snippet. from may be newer than to, in which case the
+// diff shows how to go back.
+func (s *Service) DiffSnippetRevisions(ctx context.Context, snippetID int64, from, to int32, viewerID *int64) (SnippetDiff, error) {
+	if err := s.checkSnippetVisible(ctx, snippetID, viewerID); err != nil {
+		return SnippetDiff{}, err
+	}
+
+	var a, b SnippetRevision
+
+	g, _ := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		a, err = s.getSnippetRevision(ctx, snippetID, from)
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		b, err = s.getSnippetRevision(ctx, snippetID, to)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return SnippetDiff{}, err
+	}
+	for _, r := range []SnippetRevision{a, b} {
+		if len(splitLines(r.Code)) > maxDiffLines {
+			return SnippetDiff{}, fmt.Errorf("revision %d has more than %d lines to diff: %w", r.Revision, maxDiffLines, ErrTooLarge)
+		}
+	}
+
+	return SnippetDiff{
+		From: from,
+		To:   to,
+		Diff: unifiedDiff(
+			fmt.Sprintf("a/%s@%d", a.GitPath, a.Revision),
+			fmt.Sprintf("b/%s@%d", b.GitPath, b.Revision),
+			a.Code,
+			b.Code,
+		),
+	}, nil
+}
+
+// checkSnippetVisible hides the history of snippets the viewer cannot read.
+func (s *Service) checkSnippetVisible(ctx context.Context, snippetID int64, viewerID *int64) error {
+	_, err := s.db.GetSnippetByID(ctx, storage.GetSnippetByIDParams{
+		ID:       snippetID,
+		ViewerID: optionalInt8(viewerID),
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("snippet %d: %w", snippetID, ErrNotFound)
+	}
+	return err
+}
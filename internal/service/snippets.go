@@ -2,8 +2,11 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/beavercli/beaver_api/internal/storage"
 	"github.com/jackc/pgx/v5"
@@ -11,7 +14,17 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
-func (s *Service) GetSnippet(ctx context.Context, id int64) (Snippet, error) {
+const maxSnippetTitleLen = 255
+
+// GetSnippet returns the snippet if viewerID is allowed to see it. A nil
+// viewerID stands for an anonymous caller, who can only see public snippets.
+func (s *Service) GetSnippet(ctx context.Context, id int64, viewerID *int64) (Snippet, error) {
+	return s.getSnippet(ctx, id, viewerID, false)
+}
+
+// getSnippet is GetSnippet, with anyVisibility it skips the visibility filter
+// to return a snippet a moderator has just changed.
+func (s *Service) getSnippet(ctx context.Context, id int64, viewerID *int64, anyVisibility bool) (Snippet, error) {
 	var tags []storage.GetTagsBySnippetIDRow
 	var contributors []storage.GetContributorsBySnippetIDRow
 	var snippet storage.GetSnippetByIDRow
@@ -20,7 +33,14 @@ func (s *Service) GetSnippet(ctx context.Context, id int64) (Snippet, error) {
 
 	g.Go(func() error {
 		var err error
-		snippet, err = s.db.GetSnippetByID(ctx, id)
+		snippet, err = s.db.GetSnippetByID(ctx, storage.GetSnippetByIDParams{
+			ID:            id,
+			AnyVisibility: anyVisibility,
+			ViewerID:      optionalInt8(viewerID),
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("snippet %d: %w", id, ErrNotFound)
+		}
 		return err
 	})
 	g.Go(func() error {
@@ -39,12 +59,14 @@ func (s *Service) GetSnippet(ctx context.Context, id int64) (Snippet, error) {
 	}
 
 	return Snippet{
-		ID:         snippet.ID,
-		Title:      snippet.Title.String,
-		Code:       snippet.Code.String,
-		ProjectURL: snippet.ProjectUrl.String,
-		GitPath:    snippet.GitFilePath.String,
-		GitVersion: snippet.GitVersion.String,
+		ID:             snippet.ID,
+		Title:          snippet.Title.String,
+		Code:           snippet.Code.String,
+		ProjectURL:     snippet.ProjectUrl.String,
+		GitPath:        snippet.GitFilePath.String,
+		GitVersion:     snippet.GitVersion.String,
+		Visibility:     Visibility(snippet.Visibility),
+		OrganizationID: snippet.OrganizationID.Int64,
 		Git: Git{
 			ID:  snippet.GitRepoID.Int64,
 			URL: snippet.GitRepoUrl.String,
@@ -61,8 +83,12 @@ func (s *Service) GetSnippet(ctx context.Context, id int64) (Snippet, error) {
 type ListSnippetsParams struct {
 	PageParam
 
-	LanguageID *int64
-	TagIDs     []int64
+	ViewerID       *int64 // nil for anonymous callers
+	OrganizationID *int64
+	LanguageID     *int64
+	TagIDs         []int64
+	Query          string
+	Fuzzy          bool // match Query against titles by trigram similarity instead of full-text
 }
 
 func (s *Service) GetSnippetsPage(ctx context.Context, params ListSnippetsParams) (SnippetsList, error) {
@@ -72,35 +98,38 @@ func (s *Service) GetSnippetsPage(ctx context.Context, params ListSnippetsParams
 	if params.LanguageID != nil {
 		langID = pgtype.Int8{Int64: *params.LanguageID, Valid: true}
 	}
+	query := pgtype.Text{String: params.Query, Valid: params.Query != ""}
 
 	g, _ := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
 		var err error
 		snippetsCount, err = s.db.CountSnippetsFiltered(ctx, storage.CountSnippetsFilteredParams{
-			LanguageID: langID,
-			TagIds:     params.TagIDs,
+			ViewerID:       optionalInt8(params.ViewerID),
+			OrganizationID: optionalInt8(params.OrganizationID),
+			LanguageID:     langID,
+			TagIds:         params.TagIDs,
+			Query:          query,
+			Fuzzy:          params.Fuzzy,
 		})
-		if err != nil {
-			fmt.Println("CountSnippetsFiltered error:", err) // Add this
-		}
 		return err
 	})
 	g.Go(func() error {
 		var err error
 		snippets, err = s.db.ListSnippetsFiltered(ctx, storage.ListSnippetsFilteredParams{
-			LanguageID: langID,
-			TagIds:     params.TagIDs,
-			SqlLimit:   int32(params.Limit()),
-			SqlOffset:  int32(params.Offset()),
+			Fuzzy:          params.Fuzzy,
+			Query:          query,
+			ViewerID:       optionalInt8(params.ViewerID),
+			OrganizationID: optionalInt8(params.OrganizationID),
+			LanguageID:     langID,
+			TagIds:         params.TagIDs,
+			SqlLimit:       int32(params.Limit()),
+			SqlOffset:      int32(params.Offset()),
 		})
-		if err != nil {
-			fmt.Println("ListSnippetsFiltered error:", err) // Add this
-		}
 		return err
 	})
 	if err := g.Wait(); err != nil {
-		fmt.Println("Error in waitgroup")
+		s.log.ErrorContext(ctx, "listing snippets failed", "error", err)
 		return SnippetsList{}, err
 	}
 
@@ -118,11 +147,13 @@ func (s *Service) GetSnippetsPage(ctx context.Context, params ListSnippetsParams
 	snippetSummary := make([]SnippetSummary, len(snippets))
 	for i, s := range snippets {
 		snippetSummary[i] = SnippetSummary{
-			ID:         s.ID,
-			Title:      s.Title.String,
-			ProjectURL: s.ProjectUrl.String,
-			GitPath:    s.GitFilePath.String,
-			GitVersion: s.GitVersion.String,
+			ID:             s.ID,
+			Title:          s.Title.String,
+			ProjectURL:     s.ProjectUrl.String,
+			GitPath:        s.GitFilePath.String,
+			GitVersion:     s.GitVersion.String,
+			Visibility:     Visibility(s.Visibility),
+			OrganizationID: s.OrganizationID.Int64,
 			Git: Git{
 				ID:  s.GitRepoID.Int64,
 				URL: s.GitRepoUrl.String,
@@ -131,7 +162,10 @@ func (s *Service) GetSnippetsPage(ctx context.Context, params ListSnippetsParams
 				ID:   s.LanguageID.Int64,
 				Name: s.LanguageName.String,
 			},
-			Tags: tagsBySnippet[s.ID],
+			Tags:       tagsBySnippet[s.ID],
+			Rank:       s.Rank,
+			Similarity: s.Similarity,
+			Highlight:  s.Headline,
 		}
 	}
 
@@ -159,19 +193,28 @@ type CreateGitParam struct {
 }
 
 type CreateSnippetParam struct {
-	Title        string
-	Code         string
-	ProjectURL   string
-	GitPath      string
-	GitVersion   string
-	UserID       int64
-	Git          CreateGitParam
-	Language     CreateLanguageParam
-	Tags         []CreateTagParam
-	Contributors []CreateContributorParam
+	Title          string
+	Code           string
+	ProjectURL     string
+	GitPath        string
+	GitVersion     string
+	Visibility     Visibility // empty keeps the current visibility, new snippets default to team
+	UserID         int64
+	OrganizationID *int64 // the user must be a member; team visibility is then limited to the organization
+	Git            CreateGitParam
+	Language       CreateLanguageParam
+	Tags           []CreateTagParam
+	Contributors   []CreateContributorParam
 }
 
 func (s *Service) IngestSnippet(ctx context.Context, csp CreateSnippetParam) error {
+	if csp.Visibility != "" && !csp.Visibility.Valid() {
+		return fmt.Errorf("invalid visibility %q: %w", csp.Visibility, ErrInvalid)
+	}
+	if utf8.RuneCountInString(csp.Title) > maxSnippetTitleLen {
+		return fmt.Errorf("snippet title must be <=%d characters: %w", maxSnippetTitleLen, ErrInvalid)
+	}
+
 	txOptions := pgx.TxOptions{
 		IsoLevel:       pgx.ReadCommitted,
 		AccessMode:     pgx.ReadWrite,
@@ -179,16 +222,58 @@ func (s *Service) IngestSnippet(ctx context.Context, csp CreateSnippetParam) err
 	}
 
 	err := s.inTx(ctx, txOptions, func(db *storage.Queries) error {
+		if csp.OrganizationID != nil {
+			if _, err := checkOrgRole(ctx, db, *csp.OrganizationID, csp.UserID, OrgRoleMember); err != nil {
+				return err
+			}
+		}
 		r, err := uploadSnippetRelatedObjects(ctx, db, csp)
 		if err != nil {
 			return err
 		}
-		if err := updateOrCreateSnippet(ctx, db, csp, r); err != nil {
+
+		// ingesting a file of a known repo path overwrites its snippet
+		var before *snippetAuditState
+		existingID, err := db.GetSnippetIDByRepoPath(ctx, storage.GetSnippetIDByRepoPathParams{
+			GitRepoID:      pgtype.Int8{Int64: r.gitID, Valid: true},
+			GitFilePath:    pgtype.Text{String: csp.GitPath, Valid: true},
+			OrganizationID: optionalInt8(csp.OrganizationID),
+		})
+		switch {
+		case err == nil:
+			if err := lockIngestedSnippet(ctx, db, existingID, csp.UserID); err != nil {
+				return err
+			}
+			if before, err = loadSnippetAuditState(ctx, db, existingID); err != nil {
+				return err
+			}
+		case !errors.Is(err, pgx.ErrNoRows):
+			return err
+		}
+
+		snippetID, err := updateOrCreateSnippet(ctx, db, csp, r)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// another user created the snippet of the path since it was looked up
+			return fmt.Errorf("snippet of %s is owned by another user: %w", csp.GitPath, ErrForbidden)
+		}
+		if err != nil {
+			return err
+		}
+
+		after, err := loadSnippetAuditState(ctx, db, snippetID)
+		if err != nil {
 			return err
 		}
-		return nil
+		action := AuditSnippetUpdated
+		if before == nil {
+			action = AuditSnippetCreated
+		}
+		return writeSnippetAuditEvents(ctx, db, action, snippetID, before, after)
 	})
 
+	if isUniqueViolation(err) {
+		return fmt.Errorf("snippet of %s clashes with an existing one: %w", csp.GitPath, ErrConflict)
+	}
 	if err != nil {
 		return err
 	}
@@ -219,82 +304,276 @@ func uploadSnippetRelatedObjects(ctx context.Context, tx *storage.Queries, cs Cr
 		return snippetRefs{}, err
 	}
 
-	tagNames := make([]string, len(cs.Tags))
-	for i, t := range cs.Tags {
-		tagNames[i] = t.Name
+	r.tagsIDs, err = upsertTags(ctx, tx, cs.Tags)
+	if err != nil {
+		return snippetRefs{}, err
 	}
-	r.tagsIDs, err = tx.BulkUpsertTags(ctx, tagNames)
+
+	r.contribsIDs, err = upsertContributors(ctx, tx, cs.Contributors)
 	if err != nil {
 		return snippetRefs{}, err
 	}
 
+	return r, nil
+}
+
+func upsertTags(ctx context.Context, tx *storage.Queries, ts []CreateTagParam) ([]int64, error) {
+	tagNames := make([]string, len(ts))
+	for i, t := range ts {
+		tagNames[i] = t.Name
+	}
+	return tx.BulkUpsertTags(ctx, tagNames)
+}
+
+func upsertContributors(ctx context.Context, tx *storage.Queries, cs []CreateContributorParam) ([]int64, error) {
 	contributorsParams := storage.BulkUpsertContributorsParams{
-		FirstNames: make([]string, len(cs.Contributors)),
-		LastNames:  make([]string, len(cs.Contributors)),
-		Emails:     make([]string, len(cs.Contributors)),
+		FirstNames: make([]string, len(cs)),
+		LastNames:  make([]string, len(cs)),
+		Emails:     make([]string, len(cs)),
 	}
-	for i, c := range cs.Contributors {
+	for i, c := range cs {
 		contributorsParams.FirstNames[i] = c.FirstName
 		contributorsParams.LastNames[i] = c.LastName
 		contributorsParams.Emails[i] = c.Email
 	}
-	r.contribsIDs, err = tx.BulkUpsertContributors(ctx, contributorsParams)
-	if err != nil {
-		return snippetRefs{}, err
-	}
-
-	return r, nil
+	return tx.BulkUpsertContributors(ctx, contributorsParams)
 }
 
-func updateOrCreateSnippet(ctx context.Context, tx *storage.Queries, cs CreateSnippetParam, r snippetRefs) error {
+func updateOrCreateSnippet(ctx context.Context, tx *storage.Queries, cs CreateSnippetParam, r snippetRefs) (int64, error) {
 	snippetID, err := tx.UpsertSnippet(ctx, storage.UpsertSnippetParams{
-		Title:       pgtype.Text{String: cs.Title, Valid: true},
-		Code:        pgtype.Text{String: cs.Code, Valid: true},
-		ProjectUrl:  pgtype.Text{String: cs.ProjectURL, Valid: true},
-		GitFilePath: pgtype.Text{String: cs.GitPath, Valid: true},
-		GitVersion:  pgtype.Text{String: cs.GitVersion, Valid: true},
-		GitRepoID:   pgtype.Int8{Int64: r.gitID, Valid: true},
-		LanguageID:  pgtype.Int8{Int64: r.langID, Valid: true},
-		UserID:      pgtype.Int8{Int64: cs.UserID, Valid: true},
-		CreatedAt:   pgtype.Timestamptz{Time: time.Now(), InfinityModifier: pgtype.Finite, Valid: true},
+		Title:          pgtype.Text{String: cs.Title, Valid: true},
+		Code:           pgtype.Text{String: cs.Code, Valid: true},
+		ProjectUrl:     pgtype.Text{String: cs.ProjectURL, Valid: true},
+		GitFilePath:    pgtype.Text{String: cs.GitPath, Valid: true},
+		GitVersion:     pgtype.Text{String: cs.GitVersion, Valid: true},
+		GitRepoID:      pgtype.Int8{Int64: r.gitID, Valid: true},
+		LanguageID:     pgtype.Int8{Int64: r.langID, Valid: true},
+		UserID:         pgtype.Int8{Int64: cs.UserID, Valid: true},
+		CreatedAt:      pgtype.Timestamptz{Time: time.Now(), InfinityModifier: pgtype.Finite, Valid: true},
+		Visibility:     pgtype.Text{String: string(cs.Visibility), Valid: cs.Visibility != ""},
+		OrganizationID: optionalInt8(cs.OrganizationID),
 	})
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	if err := tx.CreateSnippetRevision(ctx, snippetID); err != nil {
+		return 0, err
+	}
+
+	if err := linkSnippetTags(ctx, tx, snippetID, r.tagsIDs); err != nil {
+		return 0, err
 	}
+	if err := linkSnippetContributors(ctx, tx, snippetID, r.contribsIDs); err != nil {
+		return 0, err
+	}
+
+	return snippetID, nil
+}
 
+// linkSnippetTags makes tagIDs the exact set of tags linked to the snippet.
+func linkSnippetTags(ctx context.Context, tx *storage.Queries, snippetID int64, tagIDs []int64) error {
 	dr := storage.DeleteSnippetTagsExceptParams{
 		SnippetID: snippetID,
-		TagIds:    r.tagsIDs,
+		TagIds:    tagIDs,
 	}
 	if err := tx.DeleteSnippetTagsExcept(ctx, dr); err != nil {
 		return err
 	}
 	ur := storage.BulkLinkSnippetTagsParams{
 		SnippetID: snippetID,
-		TagIds:    r.tagsIDs,
-	}
-	if err := tx.BulkLinkSnippetTags(ctx, ur); err != nil {
-		return err
+		TagIds:    tagIDs,
 	}
+	return tx.BulkLinkSnippetTags(ctx, ur)
+}
 
+// linkSnippetContributors makes contribIDs the exact set of contributors linked to the snippet.
+func linkSnippetContributors(ctx context.Context, tx *storage.Queries, snippetID int64, contribIDs []int64) error {
 	drContrib := storage.DeleteSnippetContributorsExceptParams{
 		SnippetID:      snippetID,
-		ContributorIds: r.contribsIDs,
+		ContributorIds: contribIDs,
 	}
 	if err := tx.DeleteSnippetContributorsExcept(ctx, drContrib); err != nil {
 		return err
 	}
 	urContrib := storage.BulkLinkSnippetContributorsParams{
 		SnippetID:      snippetID,
-		ContributorIds: r.contribsIDs,
+		ContributorIds: contribIDs,
 	}
-	if err := tx.BulkLinkSnippetContributors(ctx, urContrib); err != nil {
-		return err
+	return tx.BulkLinkSnippetContributors(ctx, urContrib)
+}
+
+// UpdateSnippetParam describes a partial update. Nil fields are left as they are,
+// a non-nil empty Tags or Contributors slice unlinks all of them.
+type UpdateSnippetParam struct {
+	ID           int64
+	UserID       int64
+	Title        *string
+	Code         *string
+	Visibility   *Visibility
+	Tags         *[]CreateTagParam
+	Contributors *[]CreateContributorParam
+}
+
+func (s *Service) UpdateSnippet(ctx context.Context, usp UpdateSnippetParam) (Snippet, error) {
+	if usp.Title != nil {
+		title := strings.TrimSpace(*usp.Title)
+		if title == "" {
+			return Snippet{}, fmt.Errorf("snippet title is required: %w", ErrInvalid)
+		}
+		if utf8.RuneCountInString(title) > maxSnippetTitleLen {
+			return Snippet{}, fmt.Errorf("snippet title must be <=%d characters: %w", maxSnippetTitleLen, ErrInvalid)
+		}
+		usp.Title = &title
+	}
+	var visibility pgtype.Text
+	if usp.Visibility != nil {
+		if !usp.Visibility.Valid() {
+			return Snippet{}, fmt.Errorf("invalid visibility %q: %w", *usp.Visibility, ErrInvalid)
+		}
+		visibility = pgtype.Text{String: string(*usp.Visibility), Valid: true}
 	}
 
+	txOptions := pgx.TxOptions{
+		IsoLevel:   pgx.ReadCommitted,
+		AccessMode: pgx.ReadWrite,
+	}
+
+	var moderated bool
+	err := s.inTx(ctx, txOptions, func(db *storage.Queries) error {
+		var err error
+		moderated, err = checkSnippetOwner(ctx, db, usp.ID, usp.UserID)
+		if err != nil {
+			return err
+		}
+		// moderators fix the content, who may see it stays up to the owner
+		if moderated && usp.Visibility != nil {
+			return fmt.Errorf("only the owner can change the visibility of snippet %d: %w", usp.ID, ErrForbidden)
+		}
+		before, err := loadSnippetAuditState(ctx, db, usp.ID)
+		if err != nil {
+			return err
+		}
+
+		if err := db.UpdateSnippet(ctx, storage.UpdateSnippetParams{
+			ID:         usp.ID,
+			Title:      optionalText(usp.Title),
+			Code:       optionalText(usp.Code),
+			Visibility: visibility,
+		}); err != nil {
+			return err
+		}
+
+		if usp.Tags != nil {
+			tagIDs, err := upsertTags(ctx, db, *usp.Tags)
+			if err != nil {
+				return err
+			}
+			if err := linkSnippetTags(ctx, db, usp.ID, tagIDs); err != nil {
+				return err
+			}
+		}
+		if usp.Contributors != nil {
+			contribIDs, err := upsertContributors(ctx, db, *usp.Contributors)
+			if err != nil {
+				return err
+			}
+			if err := linkSnippetContributors(ctx, db, usp.ID, contribIDs); err != nil {
+				return err
+			}
+		}
+
+		if err := db.CreateSnippetRevision(ctx, usp.ID); err != nil {
+			return err
+		}
+
+		after, err := loadSnippetAuditState(ctx, db, usp.ID)
+		if err != nil {
+			return err
+		}
+		return writeSnippetAuditEvents(ctx, db, AuditSnippetUpdated, usp.ID, before, after)
+	})
+	if err != nil {
+		return Snippet{}, err
+	}
+
+	return s.getSnippet(ctx, usp.ID, &usp.UserID, moderated)
+}
+
+// DeleteSnippet moves the snippet to the trash. It stays restorable until the
+// trash retention period is over and the purger removes it for good.
+func (s *Service) DeleteSnippet(ctx context.Context, snippetID, userID int64) error {
+	txOptions := pgx.TxOptions{
+		IsoLevel:   pgx.ReadCommitted,
+		AccessMode: pgx.ReadWrite,
+	}
+
+	return s.inTx(ctx, txOptions, func(db *storage.Queries) error {
+		if _, err := checkSnippetOwner(ctx, db, snippetID, userID); err != nil {
+			return err
+		}
+		before, err := loadSnippetAuditState(ctx, db, snippetID)
+		if err != nil {
+			return err
+		}
+		if err := db.SoftDeleteSnippet(ctx, snippetID); err != nil {
+			return err
+		}
+		return writeSnippetAuditEvents(ctx, db, AuditSnippetDeleted, snippetID, before, nil)
+	})
+}
+
+// checkSnippetOwner locks the snippet row for the rest of the transaction and
+// verifies that it is not in the trash and belongs to userID. moderated is
+// set when userID acts on the snippet of another user as a moderator.
+func checkSnippetOwner(ctx context.Context, tx *storage.Queries, snippetID, userID int64) (moderated bool, err error) {
+	return lockOwnedSnippet(ctx, tx, snippetID, userID, false)
+}
+
+// checkTrashedSnippetOwner is checkSnippetOwner for snippets in the trash.
+func checkTrashedSnippetOwner(ctx context.Context, tx *storage.Queries, snippetID, userID int64) (moderated bool, err error) {
+	return lockOwnedSnippet(ctx, tx, snippetID, userID, true)
+}
+
+// lockIngestedSnippet locks the snippet an ingest is about to overwrite. Only
+// its owner may overwrite it, and a trashed snippet has to be restored first.
+func lockIngestedSnippet(ctx context.Context, tx *storage.Queries, snippetID, userID int64) error {
+	sn, err := tx.GetSnippetOwnerForUpdate(ctx, snippetID)
+	if err != nil {
+		return err
+	}
+	if !sn.UserID.Valid || sn.UserID.Int64 != userID {
+		return fmt.Errorf("snippet %d is owned by another user: %w", snippetID, ErrForbidden)
+	}
+	if sn.DeletedAt.Valid {
+		return fmt.Errorf("snippet %d is in the trash, restore it first: %w", snippetID, ErrConflict)
+	}
 	return nil
 }
 
+func lockOwnedSnippet(ctx context.Context, tx *storage.Queries, snippetID, userID int64, trashed bool) (bool, error) {
+	sn, err := tx.GetSnippetOwnerForUpdate(ctx, snippetID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && sn.DeletedAt.Valid != trashed) {
+		return false, fmt.Errorf("snippet %d: %w", snippetID, ErrNotFound)
+	}
+	if err != nil {
+		return false, err
+	}
+	if sn.UserID.Valid && sn.UserID.Int64 == userID {
+		return false, nil
+	}
+
+	// maintainers and admins moderate the snippets of other users
+	a, err := tx.GetUserAccess(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if !Role(a.Role).Can(PermSnippetsModerate) {
+		return false, fmt.Errorf("snippet %d is owned by another user: %w", snippetID, ErrForbidden)
+	}
+	return true, nil
+}
+
 func mapTags(rows []storage.GetTagsBySnippetIDsRow) map[int64][]Tag {
 	tagsBySnippet := make(map[int64][]Tag)
 
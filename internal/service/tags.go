@@ -7,9 +7,20 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
-func (s *Service) GetTagsPage(ctx context.Context, p PageParam) (TagList, error) {
+type ListTagsParams struct {
+	PageParam
+
+	Query string
+}
+
+func (s *Service) GetTagsPage(ctx context.Context, params ListTagsParams) (TagList, error) {
+	if params.Query != "" {
+		return s.searchTags(ctx, params)
+	}
+
 	var tags []storage.Tag
 	var total int64
+	p := params.PageParam
 
 	g, _ := errgroup.WithContext(ctx)
 	g.Go(func() error {
@@ -34,6 +45,45 @@ func (s *Service) GetTagsPage(ctx context.Context, p PageParam) (TagList, error)
 	}, nil
 }
 
+// searchTags returns tags whose names are similar to the query, so the client
+// can offer "did you mean" suggestions for misspelled tags.
+func (s *Service) searchTags(ctx context.Context, params ListTagsParams) (TagList, error) {
+	var rows []storage.SearchTagsRow
+	var total int64
+
+	g, _ := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		rows, err = s.db.SearchTags(ctx, storage.SearchTagsParams{
+			Query:     params.Query,
+			SqlOffset: int32(params.Offset()),
+			SqlLimit:  int32(params.Limit()),
+		})
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		total, err = s.db.CountSearchTags(ctx, params.Query)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return TagList{}, err
+	}
+
+	tags := make([]Tag, len(rows))
+	for i, r := range rows {
+		tags[i] = Tag{
+			ID:         r.ID,
+			Name:       r.Name.String,
+			Similarity: r.Similarity,
+		}
+	}
+	return TagList{
+		Total: int(total),
+		Items: tags,
+	}, nil
+}
+
 func toServiceTag(ts []storage.Tag) []Tag {
 	tags := make([]Tag, len(ts))
 	for i, t := range ts {
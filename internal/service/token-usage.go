@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/beavercli/beaver_api/internal/storage"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// tokenUsage collects service access token uses in memory so authenticating
+// a request does not write to the database. RunTokenUsageFlusher stores them.
+type tokenUsage struct {
+	mu   sync.Mutex
+	uses map[int64]tokenUse
+}
+
+type tokenUse struct {
+	count      int64
+	lastUsedAt time.Time
+	lastUsedIP string
+}
+
+func newTokenUsage() *tokenUsage {
+	return &tokenUsage{uses: map[int64]tokenUse{}}
+}
+
+func (u *tokenUsage) record(tokenID int64, ip string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	use := u.uses[tokenID]
+	use.count++
+	use.lastUsedAt = time.Now()
+	use.lastUsedIP = ip
+	u.uses[tokenID] = use
+}
+
+// take returns the collected uses and starts collecting anew.
+func (u *tokenUsage) take() map[int64]tokenUse {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	uses := u.uses
+	u.uses = map[int64]tokenUse{}
+	return uses
+}
+
+// restore merges uses that could not be stored back into the collected ones.
+func (u *tokenUsage) restore(uses map[int64]tokenUse) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for id, use := range uses {
+		cur, ok := u.uses[id]
+		if !ok {
+			u.uses[id] = use
+			continue
+		}
+		cur.count += use.count
+		if cur.lastUsedAt.Before(use.lastUsedAt) {
+			cur.lastUsedAt = use.lastUsedAt
+			cur.lastUsedIP = use.lastUsedIP
+		}
+		u.uses[id] = cur
+	}
+}
+
+// FlushTokenUsage stores the service access token uses collected since the
+// previous flush.
+func (s *Service) FlushTokenUsage(ctx context.Context) error {
+	uses := s.usage.take()
+	if len(uses) == 0 {
+		return nil
+	}
+
+	arg := storage.RecordServiceAccessTokenUsageParams{
+		Ids:        make([]int64, 0, len(uses)),
+		Uses:       make([]int64, 0, len(uses)),
+		LastUsedAt: make([]pgtype.Timestamptz, 0, len(uses)),
+		LastUsedIp: make([]string, 0, len(uses)),
+	}
+	for id, use := range uses {
+		arg.Ids = append(arg.Ids, id)
+		arg.Uses = append(arg.Uses, use.count)
+		arg.LastUsedAt = append(arg.LastUsedAt, pgtype.Timestamptz{Time: use.lastUsedAt, Valid: true})
+		arg.LastUsedIp = append(arg.LastUsedIp, use.lastUsedIP)
+	}
+
+	if err := s.db.RecordServiceAccessTokenUsage(ctx, arg); err != nil {
+		s.usage.restore(uses)
+		return err
+	}
+	return nil
+}
+
+// RunTokenUsageFlusher calls FlushTokenUsage every interval until ctx is
+// cancelled, then flushes one last time so uses are not lost on shutdown.
+func (s *Service) RunTokenUsageFlusher(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := s.FlushTokenUsage(flushCtx); err != nil {
+				s.log.ErrorContext(flushCtx, "storing the service access token usage failed", "error", err)
+			}
+			return
+		case <-t.C:
+		}
+
+		if err := s.FlushTokenUsage(ctx); err != nil && ctx.Err() == nil {
+			s.log.ErrorContext(ctx, "storing the service access token usage failed", "error", err)
+		}
+	}
+}
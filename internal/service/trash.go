@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/beavercli/beaver_api/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/sync/errgroup"
+)
+
+func (s *Service) GetTrashPage(ctx context.Context, userID int64, p PageParam) (TrashedSnippetList, error) {
+	var snippets []storage.ListTrashedSnippetsRow
+	var total int64
+	owner := pgtype.Int8{Int64: userID, Valid: true}
+
+	g, _ := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		snippets, err = s.db.ListTrashedSnippets(ctx, storage.ListTrashedSnippetsParams{
+			UserID: owner,
+			Offset: int32(p.Offset()),
+			Limit:  int32(p.Limit()),
+		})
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		total, err = s.db.CountTrashedSnippets(ctx, owner)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return TrashedSnippetList{}, err
+	}
+
+	items := make([]TrashedSnippet, len(snippets))
+	for i, sn := range snippets {
+		items[i] = TrashedSnippet{
+			ID:         sn.ID,
+			Title:      sn.Title.String,
+			GitPath:    sn.GitFilePath.String,
+			GitVersion: sn.GitVersion.String,
+			Git: Git{
+				ID:  sn.GitRepoID.Int64,
+				URL: sn.GitRepoUrl.String,
+			},
+			Language: Language{
+				ID:   sn.LanguageID.Int64,
+				Name: sn.LanguageName.String,
+			},
+			DeletedAt: sn.DeletedAt.Time,
+			PurgeAt:   sn.DeletedAt.Time.Add(s.conf.TrashRetention),
+		}
+	}
+	return TrashedSnippetList{
+		Total: int(total),
+		Items: items,
+	}, nil
+}
+
+func (s *Service) RestoreSnippet(ctx context.Context, snippetID, userID int64) (Snippet, error) {
+	txOptions := pgx.TxOptions{
+		IsoLevel:   pgx.ReadCommitted,
+		AccessMode: pgx.ReadWrite,
+	}
+
+	var moderated bool
+	err := s.inTx(ctx, txOptions, func(db *storage.Queries) error {
+		var err error
+		moderated, err = checkTrashedSnippetOwner(ctx, db, snippetID, userID)
+		if err != nil {
+			return err
+		}
+		before, err := loadSnippetAuditState(ctx, db, snippetID)
+		if err != nil {
+			return err
+		}
+		if err := db.RestoreSnippet(ctx, snippetID); err != nil {
+			return err
+		}
+		after, err := loadSnippetAuditState(ctx, db, snippetID)
+		if err != nil {
+			return err
+		}
+		return writeSnippetAuditEvents(ctx, db, AuditSnippetRestored, snippetID, before, after)
+	})
+	if err != nil {
+		return Snippet{}, err
+	}
+
+	return s.getSnippet(ctx, snippetID, &userID, moderated)
+}
+
+// PurgeTrash permanently deletes snippets that have been in the trash for
+// longer than the retention period and returns how many were removed.
+func (s *Service) PurgeTrash(ctx context.Context) (int64, error) {
+	before := time.Now().Add(-s.conf.TrashRetention)
+	return s.db.PurgeDeletedSnippets(ctx, pgtype.Timestamptz{Time: before, Valid: true})
+}
+
+// RunTrashPurger calls PurgeTrash every interval until ctx is cancelled.
+func (s *Service) RunTrashPurger(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		n, err := s.PurgeTrash(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			s.log.ErrorContext(ctx, "purging the snippets trash failed", "error", err)
+		case n > 0:
+			s.log.InfoContext(ctx, "purged the snippets trash", "snippets", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/beavercli/beaver_api/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const maxDisplayNameLen = 255
+
+func (s *Service) GetUserProfile(ctx context.Context, userID int64) (UserProfile, error) {
+	u, err := s.db.GetUserByID(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return UserProfile{}, fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	if err != nil {
+		return UserProfile{}, err
+	}
+
+	var lang string
+	if u.DefaultLanguageID.Valid {
+		l, err := s.db.GetLanguageByID(ctx, u.DefaultLanguageID.Int64)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return UserProfile{}, err
+		}
+		lang = l.Name.String
+	}
+
+	identities, err := s.db.ListUserIdentities(ctx, userID)
+	if err != nil {
+		return UserProfile{}, err
+	}
+	providers := make([]string, len(identities))
+	for i, ident := range identities {
+		providers[i] = ident.Provider
+	}
+
+	return UserProfile{
+		ID:              u.ID,
+		Username:        u.Username,
+		Email:           u.Email,
+		DisplayName:     u.DisplayName.String,
+		DefaultLanguage: lang,
+		Providers:       providers,
+		CreatedAt:       u.CreatedAt.Time,
+	}, nil
+}
+
+// UpdateUserProfileParam is a partial update; nil fields are left unchanged
+// and empty strings clear the field.
+type UpdateUserProfileParam struct {
+	UserID          int64
+	DisplayName     *string
+	DefaultLanguage *string // name of a known language
+}
+
+// UpdateUserProfile locks the user row while merging the given fields, so
+// concurrent partial updates of different fields do not undo each other.
+func (s *Service) UpdateUserProfile(ctx context.Context, p UpdateUserProfileParam) (UserProfile, error) {
+	var displayName pgtype.Text
+	if p.DisplayName != nil {
+		name := strings.TrimSpace(*p.DisplayName)
+		if utf8.RuneCountInString(name) > maxDisplayNameLen {
+			return UserProfile{}, fmt.Errorf("display name must be <=%d characters: %w", maxDisplayNameLen, ErrInvalid)
+		}
+		displayName = pgtype.Text{String: name, Valid: name != ""}
+	}
+	var languageID pgtype.Int8
+	if p.DefaultLanguage != nil && *p.DefaultLanguage != "" {
+		id, err := s.db.GetLanguageIDByName(ctx, pgtype.Text{String: *p.DefaultLanguage, Valid: true})
+		if errors.Is(err, pgx.ErrNoRows) {
+			return UserProfile{}, fmt.Errorf("unknown language %q: %w", *p.DefaultLanguage, ErrInvalid)
+		}
+		if err != nil {
+			return UserProfile{}, err
+		}
+		languageID = pgtype.Int8{Int64: id, Valid: true}
+	}
+
+	err := s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		u, err := db.GetUserByIDForUpdate(ctx, p.UserID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("user %d: %w", p.UserID, ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+
+		arg := storage.UpdateUserProfileParams{
+			ID:                u.ID,
+			DisplayName:       u.DisplayName,
+			DefaultLanguageID: u.DefaultLanguageID,
+		}
+		if p.DisplayName != nil {
+			arg.DisplayName = displayName
+		}
+		if p.DefaultLanguage != nil {
+			arg.DefaultLanguageID = languageID
+		}
+
+		if err := db.UpdateUserProfile(ctx, arg); err != nil {
+			return err
+		}
+		return writeAuditEvent(ctx, db, auditEvent{
+			ActorID:    u.ID,
+			Action:     AuditProfileUpdated,
+			TargetType: "user",
+			TargetID:   u.ID,
+			Before:     profileAuditState(u.DisplayName, u.DefaultLanguageID),
+			After:      profileAuditState(arg.DisplayName, arg.DefaultLanguageID),
+		})
+	})
+	if err != nil {
+		return UserProfile{}, err
+	}
+	return s.GetUserProfile(ctx, p.UserID)
+}
+
+func profileAuditState(displayName pgtype.Text, languageID pgtype.Int8) map[string]any {
+	return map[string]any{
+		"display_name":        displayName.String,
+		"default_language_id": languageID.Int64,
+	}
+}
+
+type SnippetDisposition string
+
+const (
+	SnippetsTransfer SnippetDisposition = "transfer"
+	SnippetsDelete   SnippetDisposition = "delete"
+)
+
+type DeleteAccountParam struct {
+	UserID   int64
+	Snippets SnippetDisposition
+	// With SnippetsTransfer exactly one of the targets must be set. See
+	// placeTransferredSnippet for which snippets move and where to.
+	TransferToUsername       string
+	TransferToOrganizationID *int64
+}
+
+// DeleteAccount removes the user together with every token issued to them.
+// Organizations the user is the only owner of have to be handed over first.
+func (s *Service) DeleteAccount(ctx context.Context, p DeleteAccountParam) error {
+	switch p.Snippets {
+	case SnippetsDelete:
+	case SnippetsTransfer:
+		if (p.TransferToUsername == "") == (p.TransferToOrganizationID == nil) {
+			return fmt.Errorf("transfer requires either a user or an organization: %w", ErrInvalid)
+		}
+	default:
+		return fmt.Errorf("invalid snippets disposition %q: %w", p.Snippets, ErrInvalid)
+	}
+
+	return s.inTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite}, func(db *storage.Queries) error {
+		u, err := db.GetUserByID(ctx, p.UserID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("user %d: %w", p.UserID, ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+
+		orgs, err := db.ListSoleOwnedOrganizations(ctx, p.UserID)
+		if err != nil {
+			return err
+		}
+		if len(orgs) > 0 {
+			names := make([]string, len(orgs))
+			for i, o := range orgs {
+				names[i] = o.Name
+			}
+			return fmt.Errorf("add another owner to or delete these organizations first: %s: %w", strings.Join(names, ", "), ErrConflict)
+		}
+
+		owner := pgtype.Int8{Int64: p.UserID, Valid: true}
+		details := map[string]any{"username": u.Username, "snippets": p.Snippets}
+
+		var n int64
+		if p.Snippets == SnippetsDelete {
+			n, err = db.DeleteSnippetsByUserID(ctx, owner)
+		} else {
+			var to snippetTransfer
+			to, err = snippetTransferTarget(ctx, db, p)
+			if err != nil {
+				return err
+			}
+			details["to_user_id"] = to.userID
+			if to.organizationID.Valid {
+				details["to_organization_id"] = to.organizationID.Int64
+			}
+			n, err = transferSnippets(ctx, db, p.UserID, to)
+		}
+		if err != nil {
+			return err
+		}
+		details["snippet_count"] = n
+
+		if err := db.DeleteRefreshTokensByUserID(ctx, owner); err != nil {
+			return err
+		}
+		if err := db.DeleteServiceAccessTokensByUserID(ctx, owner); err != nil {
+			return err
+		}
+		if err := db.DeleteUser(ctx, p.UserID); err != nil {
+			return err
+		}
+
+		return writeAuditEvent(ctx, db, auditEvent{
+			ActorID:    p.UserID,
+			Action:     AuditAccountDeleted,
+			TargetType: "user",
+			TargetID:   p.UserID,
+			Data:       details,
+		})
+	})
+}
+
+// snippetTransfer is where the snippets of a deleted account are handed over
+// to: a user, or another owner of an organization.
+type snippetTransfer struct {
+	userID         int64
+	organizationID pgtype.Int8
+}
+
+func snippetTransferTarget(ctx context.Context, db *storage.Queries, p DeleteAccountParam) (snippetTransfer, error) {
+	if p.TransferToOrganizationID != nil {
+		orgID := *p.TransferToOrganizationID
+		if _, err := checkOrgRole(ctx, db, orgID, p.UserID, OrgRoleMember); err != nil {
+			return snippetTransfer{}, err
+		}
+		ownerID, err := db.GetOtherOrganizationOwner(ctx, storage.GetOtherOrganizationOwnerParams{
+			OrganizationID: orgID,
+			UserID:         p.UserID,
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			return snippetTransfer{}, fmt.Errorf("organization %d has no other owner to take over the snippets: %w", orgID, ErrConflict)
+		}
+		if err != nil {
+			return snippetTransfer{}, err
+		}
+		return snippetTransfer{
+			userID:         ownerID,
+			organizationID: pgtype.Int8{Int64: orgID, Valid: true},
+		}, nil
+	}
+
+	toID, err := db.GetUserIDByUsername(ctx, p.TransferToUsername)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return snippetTransfer{}, fmt.Errorf("user %q: %w", p.TransferToUsername, ErrNotFound)
+	}
+	if err != nil {
+		return snippetTransfer{}, err
+	}
+	if toID == p.UserID {
+		return snippetTransfer{}, fmt.Errorf("cannot transfer snippets to the account being deleted: %w", ErrInvalid)
+	}
+	return snippetTransfer{userID: toID}, nil
+}
+
+// transferSnippets hands the snippets of fromUserID over and returns how many
+// moved. The snippets that stay behind are orphaned with the account.
+func transferSnippets(ctx context.Context, db *storage.Queries, fromUserID int64, to snippetTransfer) (int64, error) {
+	rows, err := db.ListSnippetsForTransfer(ctx, storage.ListSnippetsForTransferParams{
+		ToUserID:   to.userID,
+		FromUserID: fromUserID,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	arg := storage.TransferSnippetsParams{ToUserID: to.userID}
+	for _, sn := range rows {
+		orgID, visibility, ok := placeTransferredSnippet(sn, to.organizationID)
+		if !ok {
+			continue
+		}
+		arg.Ids = append(arg.Ids, sn.ID)
+		arg.OrganizationIds = append(arg.OrganizationIds, orgID.Int64)
+		arg.Visibilities = append(arg.Visibilities, string(visibility))
+	}
+	if len(arg.Ids) == 0 {
+		return 0, nil
+	}
+
+	n, err := db.TransferSnippets(ctx, arg)
+	if isUniqueViolation(err) {
+		return 0, fmt.Errorf("the recipient already has a snippet of the same repo file: %w", ErrConflict)
+	}
+	return n, err
+}
+
+// placeTransferredSnippet returns the organization and visibility a snippet of
+// a deleted account gets, and false if it stays behind. Handed to an
+// organization, only personal snippets and the organization's own move. Handed
+// to a user, a snippet leaves an organization the user is not a member of, and
+// a team snippet turns private then, as team visibility without an
+// organization would open it to every user.
+func placeTransferredSnippet(sn storage.ListSnippetsForTransferRow, toOrgID pgtype.Int8) (pgtype.Int8, Visibility, bool) {
+	visibility := Visibility(sn.Visibility)
+	if toOrgID.Valid {
+		if sn.OrganizationID.Valid && sn.OrganizationID.Int64 != toOrgID.Int64 {
+			return pgtype.Int8{}, "", false
+		}
+		return toOrgID, visibility, true
+	}
+
+	if !sn.OrganizationID.Valid || sn.RecipientIsMember {
+		return sn.OrganizationID, visibility, true
+	}
+	if visibility == VisibilityTeam {
+		visibility = VisibilityPrivate
+	}
+	return pgtype.Int8{}, visibility, true
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/beavercli/beaver_api/internal/storage"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlaceTransferredSnippet(t *testing.T) {
+	orgA := pgtype.Int8{Int64: 1, Valid: true}
+	orgB := pgtype.Int8{Int64: 2, Valid: true}
+
+	tests := []struct {
+		name       string
+		snippet    storage.ListSnippetsForTransferRow
+		toOrgID    pgtype.Int8
+		orgID      pgtype.Int8
+		visibility Visibility
+		moved      bool
+	}{
+		{
+			name:       "personal snippet to a user",
+			snippet:    storage.ListSnippetsForTransferRow{Visibility: "team"},
+			visibility: VisibilityTeam,
+			moved:      true,
+		},
+		{
+			name:       "team snippet to a member of its organization",
+			snippet:    storage.ListSnippetsForTransferRow{OrganizationID: orgA, Visibility: "team", RecipientIsMember: true},
+			orgID:      orgA,
+			visibility: VisibilityTeam,
+			moved:      true,
+		},
+		{
+			name:       "team snippet to a user outside its organization turns private",
+			snippet:    storage.ListSnippetsForTransferRow{OrganizationID: orgA, Visibility: "team"},
+			visibility: VisibilityPrivate,
+			moved:      true,
+		},
+		{
+			name:       "public snippet to a user outside its organization",
+			snippet:    storage.ListSnippetsForTransferRow{OrganizationID: orgA, Visibility: "public"},
+			visibility: VisibilityPublic,
+			moved:      true,
+		},
+		{
+			name:       "personal snippet to an organization",
+			snippet:    storage.ListSnippetsForTransferRow{Visibility: "private", RecipientIsMember: true},
+			toOrgID:    orgB,
+			orgID:      orgB,
+			visibility: VisibilityPrivate,
+			moved:      true,
+		},
+		{
+			name:       "snippet of the organization it is transferred to",
+			snippet:    storage.ListSnippetsForTransferRow{OrganizationID: orgB, Visibility: "team", RecipientIsMember: true},
+			toOrgID:    orgB,
+			orgID:      orgB,
+			visibility: VisibilityTeam,
+			moved:      true,
+		},
+		{
+			name:    "snippet of another organization stays behind",
+			snippet: storage.ListSnippetsForTransferRow{OrganizationID: orgA, Visibility: "team", RecipientIsMember: true},
+			toOrgID: orgB,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orgID, visibility, moved := placeTransferredSnippet(tt.snippet, tt.toOrgID)
+			assert.Equal(t, tt.moved, moved)
+			assert.Equal(t, tt.orgID, orgID)
+			assert.Equal(t, tt.visibility, visibility)
+		})
+	}
+}
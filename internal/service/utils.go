@@ -1,5 +1,7 @@
 package service
 
+import "github.com/jackc/pgx/v5/pgtype"
+
 type PageParam struct {
 	Page     int
 	PageSize int
@@ -12,3 +14,19 @@ func (p *PageParam) Offset() int {
 func (p *PageParam) Limit() int {
 	return p.PageSize
 }
+
+// optionalText maps a nil pointer to SQL NULL.
+func optionalText(v *string) pgtype.Text {
+	if v == nil {
+		return pgtype.Text{}
+	}
+	return pgtype.Text{String: *v, Valid: true}
+}
+
+// optionalInt8 maps a nil pointer, such as an anonymous viewer, to SQL NULL.
+func optionalInt8(v *int64) pgtype.Int8 {
+	if v == nil {
+		return pgtype.Int8{}
+	}
+	return pgtype.Int8{Int64: *v, Valid: true}
+}
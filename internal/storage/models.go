@@ -8,6 +8,21 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type AuditEvent struct {
+	ID         int64
+	CreatedAt  pgtype.Timestamptz
+	ActorID    pgtype.Int8
+	Action     string
+	TargetType string
+	TargetID   pgtype.Int8
+	Data       []byte
+	TokenID    pgtype.Int8
+	SessionID  pgtype.Int8
+	IpAddress  pgtype.Text
+	BeforeData []byte
+	AfterData  []byte
+}
+
 type Contributor struct {
 	ID        int64
 	CreatedAt pgtype.Timestamptz
@@ -31,46 +46,107 @@ type Language struct {
 	Name      pgtype.Text
 }
 
-type RefreshToken struct {
+type Organization struct {
 	ID        int64
 	CreatedAt pgtype.Timestamptz
 	UpdatedAt pgtype.Timestamptz
-	TokenHash string
-	IssuedAt  pgtype.Timestamptz
-	ExpiresAt pgtype.Timestamptz
-	UserID    pgtype.Int8
+	Name      string
 }
 
-type ServiceAccessToken struct {
+type OrganizationMember struct {
+	OrganizationID int64
+	UserID         int64
+	Role           string
+	CreatedAt      pgtype.Timestamptz
+}
+
+type RateLimitBucket struct {
+	Key       string
+	Tokens    float64
+	Allowed   bool
+	UpdatedAt pgtype.Timestamptz
+}
+
+type RefreshToken struct {
 	ID        int64
-	Name      string
 	CreatedAt pgtype.Timestamptz
 	UpdatedAt pgtype.Timestamptz
 	TokenHash string
 	IssuedAt  pgtype.Timestamptz
 	ExpiresAt pgtype.Timestamptz
 	UserID    pgtype.Int8
+	SessionID int64
+	RotatedAt pgtype.Timestamptz
+}
+
+type ServiceAccessToken struct {
+	ID                     int64
+	Name                   string
+	CreatedAt              pgtype.Timestamptz
+	UpdatedAt              pgtype.Timestamptz
+	TokenHash              string
+	IssuedAt               pgtype.Timestamptz
+	ExpiresAt              pgtype.Timestamptz
+	UserID                 pgtype.Int8
+	OrganizationID         pgtype.Int8
+	Scopes                 []string
+	LastUsedAt             pgtype.Timestamptz
+	LastUsedIp             pgtype.Text
+	UsageCount             int64
+	RevokedAt              pgtype.Timestamptz
+	RevokedBy              pgtype.Int8
+	PreviousTokenHash      pgtype.Text
+	PreviousTokenExpiresAt pgtype.Timestamptz
+}
+
+type Session struct {
+	ID         int64
+	CreatedAt  pgtype.Timestamptz
+	UpdatedAt  pgtype.Timestamptz
+	DeviceName pgtype.Text
+	UserAgent  pgtype.Text
+	IpAddress  pgtype.Text
+	LastUsedAt pgtype.Timestamptz
+	UserID     int64
 }
 
 type Snippet struct {
+	ID             int64
+	CreatedAt      pgtype.Timestamptz
+	UpdatedAt      pgtype.Timestamptz
+	Title          pgtype.Text
+	Code           pgtype.Text
+	ProjectUrl     pgtype.Text
+	GitFilePath    pgtype.Text
+	GitVersion     pgtype.Text
+	GitRepoID      pgtype.Int8
+	LanguageID     pgtype.Int8
+	UserID         pgtype.Int8
+	SearchVector   interface{}
+	DeletedAt      pgtype.Timestamptz
+	Visibility     string
+	OrganizationID pgtype.Int8
+}
+
+type SnippetContributor struct {
+	SnippetID     int64
+	ContributorID int64
+}
+
+type SnippetRevision struct {
 	ID          int64
 	CreatedAt   pgtype.Timestamptz
-	UpdatedAt   pgtype.Timestamptz
+	Revision    int32
 	Title       pgtype.Text
 	Code        pgtype.Text
 	ProjectUrl  pgtype.Text
 	GitFilePath pgtype.Text
 	GitVersion  pgtype.Text
-	GitRepoID   pgtype.Int8
+	SnippetID   int64
 	LanguageID  pgtype.Int8
 	UserID      pgtype.Int8
 }
 
-type SnippetContributor struct {
-	SnippetID     int64
-	ContributorID int64
-}
-
 type SnippetTag struct {
 	SnippetID int64
 	TagID     int64
@@ -84,10 +160,25 @@ type Tag struct {
 }
 
 type User struct {
-	ID           int64
-	CreatedAt    pgtype.Timestamptz
-	UpdatedAt    pgtype.Timestamptz
-	Username     string
-	Email        string
-	PasswordHash string
+	ID                int64
+	CreatedAt         pgtype.Timestamptz
+	UpdatedAt         pgtype.Timestamptz
+	Username          string
+	Email             string
+	PasswordHash      string
+	DisplayName       pgtype.Text
+	DefaultLanguageID pgtype.Int8
+	Role              string
+	DisabledAt        pgtype.Timestamptz
+}
+
+type UserIdentity struct {
+	ID             int64
+	CreatedAt      pgtype.Timestamptz
+	UpdatedAt      pgtype.Timestamptz
+	Provider       string
+	ProviderUserID string
+	Username       string
+	Email          string
+	UserID         int64
 }
@@ -11,6 +11,26 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const addOrganizationMember = `-- name: AddOrganizationMember :execrows
+INSERT INTO organization_members (organization_id, user_id, role)
+VALUES ($1, $2, $3)
+ON CONFLICT (organization_id, user_id) DO NOTHING
+`
+
+type AddOrganizationMemberParams struct {
+	OrganizationID int64
+	UserID         int64
+	Role           string
+}
+
+func (q *Queries) AddOrganizationMember(ctx context.Context, arg AddOrganizationMemberParams) (int64, error) {
+	result, err := q.db.Exec(ctx, addOrganizationMember, arg.OrganizationID, arg.UserID, arg.Role)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const bulkLinkSnippetContributors = `-- name: BulkLinkSnippetContributors :exec
 INSERT INTO snippet_contributors (snippet_id, contributor_id)
 SELECT $1::bigint, unnest($2::bigint[])
@@ -127,6 +147,22 @@ func (q *Queries) BulkUpsertTags(ctx context.Context, names []string) ([]int64,
 	return items, nil
 }
 
+const countActiveSessions = `-- name: CountActiveSessions :one
+SELECT COUNT(*) FROM sessions WHERE user_id = $1 AND last_used_at > $2
+`
+
+type CountActiveSessionsParams struct {
+	UserID     int64
+	LastUsedAt pgtype.Timestamptz
+}
+
+func (q *Queries) CountActiveSessions(ctx context.Context, arg CountActiveSessionsParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countActiveSessions, arg.UserID, arg.LastUsedAt)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const countContributors = `-- name: CountContributors :one
 SELECT COUNT(*) FROM contributors
 `
@@ -149,10 +185,67 @@ func (q *Queries) CountLanguages(ctx context.Context) (int64, error) {
 	return count, err
 }
 
+const countOrganizationMembers = `-- name: CountOrganizationMembers :one
+SELECT COUNT(*) FROM organization_members WHERE organization_id = $1
+`
+
+func (q *Queries) CountOrganizationMembers(ctx context.Context, organizationID int64) (int64, error) {
+	row := q.db.QueryRow(ctx, countOrganizationMembers, organizationID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countOrganizationsByUserID = `-- name: CountOrganizationsByUserID :one
+SELECT COUNT(*) FROM organization_members WHERE user_id = $1
+`
+
+func (q *Queries) CountOrganizationsByUserID(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRow(ctx, countOrganizationsByUserID, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countOrphanedSnippets = `-- name: CountOrphanedSnippets :one
+SELECT COUNT(*) FROM snippets WHERE user_id IS NULL AND deleted_at IS NULL
+`
+
+func (q *Queries) CountOrphanedSnippets(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, countOrphanedSnippets)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countSearchTags = `-- name: CountSearchTags :one
+SELECT COUNT(*) FROM tags WHERE $1::TEXT <% name
+`
+
+func (q *Queries) CountSearchTags(ctx context.Context, query string) (int64, error) {
+	row := q.db.QueryRow(ctx, countSearchTags, query)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countServiceAccessTokensByOrganizationID = `-- name: CountServiceAccessTokensByOrganizationID :one
+SELECT COUNT(*)
+FROM service_access_tokens
+WHERE organization_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) CountServiceAccessTokensByOrganizationID(ctx context.Context, organizationID pgtype.Int8) (int64, error) {
+	row := q.db.QueryRow(ctx, countServiceAccessTokensByOrganizationID, organizationID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const countServiceAccessTokensByUserID = `-- name: CountServiceAccessTokensByUserID :one
 SELECT COUNT(*)
 FROM service_access_tokens
-WHERE user_id = $1
+WHERE user_id = $1 AND revoked_at IS NULL
 `
 
 func (q *Queries) CountServiceAccessTokensByUserID(ctx context.Context, userID pgtype.Int8) (int64, error) {
@@ -162,12 +255,34 @@ func (q *Queries) CountServiceAccessTokensByUserID(ctx context.Context, userID p
 	return count, err
 }
 
+const countSnippetRevisions = `-- name: CountSnippetRevisions :one
+SELECT COUNT(*) FROM snippet_revisions WHERE snippet_id = $1
+`
+
+func (q *Queries) CountSnippetRevisions(ctx context.Context, snippetID int64) (int64, error) {
+	row := q.db.QueryRow(ctx, countSnippetRevisions, snippetID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const countSnippetsFiltered = `-- name: CountSnippetsFiltered :one
 SELECT COUNT(*) FROM snippets s
-WHERE ($1::BIGINT IS NULL OR s.language_id = $1::BIGINT)
+WHERE s.deleted_at IS NULL
+  AND (s.visibility = 'public'
+    OR ($1::BIGINT IS NOT NULL
+      AND (s.user_id = $1::BIGINT
+        OR (s.visibility = 'team'
+          AND (s.organization_id IS NULL OR EXISTS (
+            SELECT 1
+            FROM organization_members m
+            WHERE m.organization_id = s.organization_id
+              AND m.user_id = $1::BIGINT))))))
+  AND ($2::BIGINT IS NULL OR s.organization_id = $2::BIGINT)
+  AND ($3::BIGINT IS NULL OR s.language_id = $3::BIGINT)
   AND NOT EXISTS (
     SELECT 1
-    FROM (SELECT unnest($2::BIGINT[]) AS tag_id) ft
+    FROM (SELECT unnest($4::BIGINT[]) AS tag_id) ft
     WHERE NOT EXISTS (
       SELECT 1
       FROM snippet_tags st
@@ -175,15 +290,53 @@ WHERE ($1::BIGINT IS NULL OR s.language_id = $1::BIGINT)
         AND st.tag_id = ft.tag_id
     )
   )
+  AND ($5::TEXT IS NULL
+    OR ($6::BOOLEAN AND $5::TEXT <% s.title)
+    OR (NOT $6::BOOLEAN AND s.search_vector @@ websearch_to_tsquery('simple', $5::TEXT)))
 `
 
 type CountSnippetsFilteredParams struct {
-	LanguageID pgtype.Int8
-	TagIds     []int64
+	ViewerID       pgtype.Int8
+	OrganizationID pgtype.Int8
+	LanguageID     pgtype.Int8
+	TagIds         []int64
+	Query          pgtype.Text
+	Fuzzy          bool
 }
 
 func (q *Queries) CountSnippetsFiltered(ctx context.Context, arg CountSnippetsFilteredParams) (int64, error) {
-	row := q.db.QueryRow(ctx, countSnippetsFiltered, arg.LanguageID, arg.TagIds)
+	row := q.db.QueryRow(ctx, countSnippetsFiltered,
+		arg.ViewerID,
+		arg.OrganizationID,
+		arg.LanguageID,
+		arg.TagIds,
+		arg.Query,
+		arg.Fuzzy,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countStaleServiceAccessTokens = `-- name: CountStaleServiceAccessTokens :one
+SELECT COUNT(*)
+FROM service_access_tokens
+WHERE user_id = $1
+  AND revoked_at IS NULL
+  AND (
+    COALESCE(last_used_at, issued_at) < $2::TIMESTAMPTZ
+    OR expires_at < $3::TIMESTAMPTZ
+  )
+`
+
+type CountStaleServiceAccessTokensParams struct {
+	UserID        pgtype.Int8
+	UnusedSince   pgtype.Timestamptz
+	ExpiresBefore pgtype.Timestamptz
+}
+
+func (q *Queries) CountStaleServiceAccessTokens(ctx context.Context, arg CountStaleServiceAccessTokensParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countStaleServiceAccessTokens, arg.UserID, arg.UnusedSince, arg.ExpiresBefore)
 	var count int64
 	err := row.Scan(&count)
 	return count, err
@@ -200,11 +353,98 @@ func (q *Queries) CountTags(ctx context.Context) (int64, error) {
 	return count, err
 }
 
+const countTrashedSnippets = `-- name: CountTrashedSnippets :one
+SELECT COUNT(*) FROM snippets WHERE user_id = $1 AND deleted_at IS NOT NULL
+`
+
+func (q *Queries) CountTrashedSnippets(ctx context.Context, userID pgtype.Int8) (int64, error) {
+	row := q.db.QueryRow(ctx, countTrashedSnippets, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUserIdentities = `-- name: CountUserIdentities :one
+SELECT COUNT(*) FROM user_identities WHERE user_id = $1
+`
+
+func (q *Queries) CountUserIdentities(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRow(ctx, countUserIdentities, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUsers = `-- name: CountUsers :one
+SELECT COUNT(*) FROM users
+`
+
+func (q *Queries) CountUsers(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, countUsers)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createAuditEvent = `-- name: CreateAuditEvent :exec
+
+INSERT INTO audit_events (actor_id, action, target_type, target_id, data, token_id, session_id, ip_address, before_data, after_data)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+`
+
+type CreateAuditEventParams struct {
+	ActorID    pgtype.Int8
+	Action     string
+	TargetType string
+	TargetID   pgtype.Int8
+	Data       []byte
+	TokenID    pgtype.Int8
+	SessionID  pgtype.Int8
+	IpAddress  pgtype.Text
+	BeforeData []byte
+	AfterData  []byte
+}
+
+// Audit events
+func (q *Queries) CreateAuditEvent(ctx context.Context, arg CreateAuditEventParams) error {
+	_, err := q.db.Exec(ctx, createAuditEvent,
+		arg.ActorID,
+		arg.Action,
+		arg.TargetType,
+		arg.TargetID,
+		arg.Data,
+		arg.TokenID,
+		arg.SessionID,
+		arg.IpAddress,
+		arg.BeforeData,
+		arg.AfterData,
+	)
+	return err
+}
+
+const createOrganization = `-- name: CreateOrganization :one
+
+INSERT INTO organizations (name) VALUES ($1) RETURNING id, created_at, updated_at, name
+`
+
+// Organizations
+func (q *Queries) CreateOrganization(ctx context.Context, name string) (Organization, error) {
+	row := q.db.QueryRow(ctx, createOrganization, name)
+	var i Organization
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Name,
+	)
+	return i, err
+}
+
 const createRefreshToken = `-- name: CreateRefreshToken :one
 
-INSERT INTO refresh_tokens (token_hash, issued_at, expires_at, user_id)
-VALUES ($1, $2, $3, $4)
-RETURNING id, created_at, updated_at, token_hash, issued_at, expires_at, user_id
+INSERT INTO refresh_tokens (token_hash, issued_at, expires_at, user_id, session_id)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, created_at, updated_at, token_hash, issued_at, expires_at, user_id, session_id, rotated_at
 `
 
 type CreateRefreshTokenParams struct {
@@ -212,6 +452,7 @@ type CreateRefreshTokenParams struct {
 	IssuedAt  pgtype.Timestamptz
 	ExpiresAt pgtype.Timestamptz
 	UserID    pgtype.Int8
+	SessionID int64
 }
 
 // Refresh tokens
@@ -221,6 +462,7 @@ func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshToken
 		arg.IssuedAt,
 		arg.ExpiresAt,
 		arg.UserID,
+		arg.SessionID,
 	)
 	var i RefreshToken
 	err := row.Scan(
@@ -231,23 +473,27 @@ func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshToken
 		&i.IssuedAt,
 		&i.ExpiresAt,
 		&i.UserID,
+		&i.SessionID,
+		&i.RotatedAt,
 	)
 	return i, err
 }
 
 const createServiceAccessToken = `-- name: CreateServiceAccessToken :one
 
-INSERT INTO service_access_tokens (token_hash, name, issued_at, expires_at, user_id)
-VALUES ($1, $2, $3, $4, $5)
-RETURNING id, name, created_at, updated_at, token_hash, issued_at, expires_at, user_id
+INSERT INTO service_access_tokens (token_hash, name, issued_at, expires_at, user_id, organization_id, scopes)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, name, created_at, updated_at, token_hash, issued_at, expires_at, user_id, organization_id, scopes, last_used_at, last_used_ip, usage_count, revoked_at, revoked_by, previous_token_hash, previous_token_expires_at
 `
 
 type CreateServiceAccessTokenParams struct {
-	TokenHash string
-	Name      string
-	IssuedAt  pgtype.Timestamptz
-	ExpiresAt pgtype.Timestamptz
-	UserID    pgtype.Int8
+	TokenHash      string
+	Name           string
+	IssuedAt       pgtype.Timestamptz
+	ExpiresAt      pgtype.Timestamptz
+	UserID         pgtype.Int8
+	OrganizationID pgtype.Int8
+	Scopes         []string
 }
 
 // Service access tokens
@@ -258,6 +504,8 @@ func (q *Queries) CreateServiceAccessToken(ctx context.Context, arg CreateServic
 		arg.IssuedAt,
 		arg.ExpiresAt,
 		arg.UserID,
+		arg.OrganizationID,
+		arg.Scopes,
 	)
 	var i ServiceAccessToken
 	err := row.Scan(
@@ -269,6 +517,115 @@ func (q *Queries) CreateServiceAccessToken(ctx context.Context, arg CreateServic
 		&i.IssuedAt,
 		&i.ExpiresAt,
 		&i.UserID,
+		&i.OrganizationID,
+		&i.Scopes,
+		&i.LastUsedAt,
+		&i.LastUsedIp,
+		&i.UsageCount,
+		&i.RevokedAt,
+		&i.RevokedBy,
+		&i.PreviousTokenHash,
+		&i.PreviousTokenExpiresAt,
+	)
+	return i, err
+}
+
+const createSession = `-- name: CreateSession :one
+
+INSERT INTO sessions (user_id, device_name, user_agent, ip_address)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, updated_at, device_name, user_agent, ip_address, last_used_at, user_id
+`
+
+type CreateSessionParams struct {
+	UserID     int64
+	DeviceName pgtype.Text
+	UserAgent  pgtype.Text
+	IpAddress  pgtype.Text
+}
+
+// Sessions
+func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error) {
+	row := q.db.QueryRow(ctx, createSession,
+		arg.UserID,
+		arg.DeviceName,
+		arg.UserAgent,
+		arg.IpAddress,
+	)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeviceName,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastUsedAt,
+		&i.UserID,
+	)
+	return i, err
+}
+
+const createSnippetRevision = `-- name: CreateSnippetRevision :exec
+
+INSERT INTO snippet_revisions (revision, title, code, project_url, git_file_path, git_version, snippet_id, language_id, user_id)
+SELECT
+    COALESCE((SELECT MAX(r.revision) FROM snippet_revisions r WHERE r.snippet_id = s.id), 0) + 1,
+    s.title, s.code, s.project_url, s.git_file_path, s.git_version, s.id, s.language_id, s.user_id
+FROM snippets s
+WHERE s.id = $1
+  AND NOT EXISTS (
+    SELECT 1
+    FROM snippet_revisions r
+    WHERE r.snippet_id = s.id
+      AND r.revision = (SELECT MAX(revision) FROM snippet_revisions WHERE snippet_id = s.id)
+      AND r.title IS NOT DISTINCT FROM s.title
+      AND r.code IS NOT DISTINCT FROM s.code
+      AND r.git_version IS NOT DISTINCT FROM s.git_version
+      AND r.language_id IS NOT DISTINCT FROM s.language_id
+  )
+`
+
+// Snippet revisions
+// Snapshots the current state of a snippet as its next revision. Nothing is
+// written when the latest revision already matches the snippet.
+func (q *Queries) CreateSnippetRevision(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, createSnippetRevision, id)
+	return err
+}
+
+const createUserIdentity = `-- name: CreateUserIdentity :one
+INSERT INTO user_identities (user_id, provider, provider_user_id, username, email)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, created_at, updated_at, provider, provider_user_id, username, email, user_id
+`
+
+type CreateUserIdentityParams struct {
+	UserID         int64
+	Provider       string
+	ProviderUserID string
+	Username       string
+	Email          string
+}
+
+func (q *Queries) CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) (UserIdentity, error) {
+	row := q.db.QueryRow(ctx, createUserIdentity,
+		arg.UserID,
+		arg.Provider,
+		arg.ProviderUserID,
+		arg.Username,
+		arg.Email,
+	)
+	var i UserIdentity
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Provider,
+		&i.ProviderUserID,
+		&i.Username,
+		&i.Email,
+		&i.UserID,
 	)
 	return i, err
 }
@@ -282,6 +639,18 @@ func (q *Queries) DeleteContributorsExcept(ctx context.Context, ids []int64) err
 	return err
 }
 
+const deleteIdleRateLimitBuckets = `-- name: DeleteIdleRateLimitBuckets :execrows
+DELETE FROM rate_limit_buckets WHERE updated_at < $1
+`
+
+func (q *Queries) DeleteIdleRateLimitBuckets(ctx context.Context, idleSince pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteIdleRateLimitBuckets, idleSince)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteLanguagesExcept = `-- name: DeleteLanguagesExcept :exec
 DELETE FROM languages WHERE NOT (id = ANY($1::BIGINT[]))
 `
@@ -291,6 +660,15 @@ func (q *Queries) DeleteLanguagesExcept(ctx context.Context, ids []int64) error
 	return err
 }
 
+const deleteOrganization = `-- name: DeleteOrganization :exec
+DELETE FROM organizations WHERE id = $1
+`
+
+func (q *Queries) DeleteOrganization(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, deleteOrganization, id)
+	return err
+}
+
 const deleteRefreshTokenByID = `-- name: DeleteRefreshTokenByID :exec
 DELETE FROM refresh_tokens WHERE id = $1
 `
@@ -309,15 +687,44 @@ func (q *Queries) DeleteRefreshTokensByUserID(ctx context.Context, userID pgtype
 	return err
 }
 
-const deleteServiceAccessTokenByID = `-- name: DeleteServiceAccessTokenByID :exec
-DELETE FROM service_access_tokens WHERE id = $1
+const deleteServiceAccessTokensByUserID = `-- name: DeleteServiceAccessTokensByUserID :exec
+DELETE FROM service_access_tokens WHERE user_id = $1
 `
 
-func (q *Queries) DeleteServiceAccessTokenByID(ctx context.Context, id int64) error {
-	_, err := q.db.Exec(ctx, deleteServiceAccessTokenByID, id)
+func (q *Queries) DeleteServiceAccessTokensByUserID(ctx context.Context, userID pgtype.Int8) error {
+	_, err := q.db.Exec(ctx, deleteServiceAccessTokensByUserID, userID)
 	return err
 }
 
+const deleteSession = `-- name: DeleteSession :execrows
+DELETE FROM sessions WHERE id = $1 AND user_id = $2
+`
+
+type DeleteSessionParams struct {
+	ID     int64
+	UserID int64
+}
+
+func (q *Queries) DeleteSession(ctx context.Context, arg DeleteSessionParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteSession, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteSessionsByUserID = `-- name: DeleteSessionsByUserID :execrows
+DELETE FROM sessions WHERE user_id = $1
+`
+
+func (q *Queries) DeleteSessionsByUserID(ctx context.Context, userID int64) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteSessionsByUserID, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteSnippetContributorsExcept = `-- name: DeleteSnippetContributorsExcept :exec
 DELETE FROM snippet_contributors
 WHERE snippet_id = $1::bigint
@@ -359,6 +766,18 @@ func (q *Queries) DeleteSnippetsBefore(ctx context.Context, createdAt pgtype.Tim
 	return err
 }
 
+const deleteSnippetsByUserID = `-- name: DeleteSnippetsByUserID :execrows
+DELETE FROM snippets WHERE user_id = $1
+`
+
+func (q *Queries) DeleteSnippetsByUserID(ctx context.Context, userID pgtype.Int8) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteSnippetsByUserID, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteTagsExcept = `-- name: DeleteTagsExcept :exec
 DELETE FROM tags WHERE NOT (id = ANY($1::BIGINT[]))
 `
@@ -368,6 +787,32 @@ func (q *Queries) DeleteTagsExcept(ctx context.Context, ids []int64) error {
 	return err
 }
 
+const deleteUser = `-- name: DeleteUser :exec
+DELETE FROM users WHERE id = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, deleteUser, id)
+	return err
+}
+
+const deleteUserIdentity = `-- name: DeleteUserIdentity :execrows
+DELETE FROM user_identities WHERE user_id = $1 AND provider = $2
+`
+
+type DeleteUserIdentityParams struct {
+	UserID   int64
+	Provider string
+}
+
+func (q *Queries) DeleteUserIdentity(ctx context.Context, arg DeleteUserIdentityParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUserIdentity, arg.UserID, arg.Provider)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const getContributorIDByEmail = `-- name: GetContributorIDByEmail :one
 SELECT id FROM contributors WHERE email=$1
 `
@@ -447,6 +892,22 @@ func (q *Queries) GetContributorsBySnippetID(ctx context.Context, snippetID int6
 	return items, nil
 }
 
+const getLanguageByID = `-- name: GetLanguageByID :one
+SELECT id, created_at, updated_at, name FROM languages WHERE id = $1
+`
+
+func (q *Queries) GetLanguageByID(ctx context.Context, id int64) (Language, error) {
+	row := q.db.QueryRow(ctx, getLanguageByID, id)
+	var i Language
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Name,
+	)
+	return i, err
+}
+
 const getLanguageBySnippetID = `-- name: GetLanguageBySnippetID :one
 SELECT l.id, l.created_at, l.updated_at, l.name FROM languages l
 INNER JOIN snippets s ON s.language_id = l.id
@@ -476,8 +937,60 @@ func (q *Queries) GetLanguageIDByName(ctx context.Context, name pgtype.Text) (in
 	return id, err
 }
 
+const getOrganizationByID = `-- name: GetOrganizationByID :one
+SELECT id, created_at, updated_at, name FROM organizations WHERE id = $1
+`
+
+func (q *Queries) GetOrganizationByID(ctx context.Context, id int64) (Organization, error) {
+	row := q.db.QueryRow(ctx, getOrganizationByID, id)
+	var i Organization
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Name,
+	)
+	return i, err
+}
+
+const getOrganizationMemberRole = `-- name: GetOrganizationMemberRole :one
+SELECT role FROM organization_members WHERE organization_id = $1 AND user_id = $2
+`
+
+type GetOrganizationMemberRoleParams struct {
+	OrganizationID int64
+	UserID         int64
+}
+
+func (q *Queries) GetOrganizationMemberRole(ctx context.Context, arg GetOrganizationMemberRoleParams) (string, error) {
+	row := q.db.QueryRow(ctx, getOrganizationMemberRole, arg.OrganizationID, arg.UserID)
+	var role string
+	err := row.Scan(&role)
+	return role, err
+}
+
+const getOtherOrganizationOwner = `-- name: GetOtherOrganizationOwner :one
+SELECT user_id
+FROM organization_members
+WHERE organization_id = $1 AND role = 'owner' AND user_id <> $2
+ORDER BY created_at, user_id
+LIMIT 1
+`
+
+type GetOtherOrganizationOwnerParams struct {
+	OrganizationID int64
+	UserID         int64
+}
+
+func (q *Queries) GetOtherOrganizationOwner(ctx context.Context, arg GetOtherOrganizationOwnerParams) (int64, error) {
+	row := q.db.QueryRow(ctx, getOtherOrganizationOwner, arg.OrganizationID, arg.UserID)
+	var user_id int64
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
 const getRefreshTokenByHash = `-- name: GetRefreshTokenByHash :one
-SELECT id, created_at, updated_at, token_hash, issued_at, expires_at, user_id FROM refresh_tokens WHERE token_hash = $1
+SELECT id, created_at, updated_at, token_hash, issued_at, expires_at, user_id, session_id, rotated_at FROM refresh_tokens WHERE token_hash = $1
 `
 
 func (q *Queries) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (RefreshToken, error) {
@@ -491,12 +1004,17 @@ func (q *Queries) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (
 		&i.IssuedAt,
 		&i.ExpiresAt,
 		&i.UserID,
+		&i.SessionID,
+		&i.RotatedAt,
 	)
 	return i, err
 }
 
 const getServiceAccessTokenByHash = `-- name: GetServiceAccessTokenByHash :one
-SELECT id, name, created_at, updated_at, token_hash, issued_at, expires_at, user_id FROM service_access_tokens WHERE token_hash = $1
+SELECT id, name, created_at, updated_at, token_hash, issued_at, expires_at, user_id, organization_id, scopes, last_used_at, last_used_ip, usage_count, revoked_at, revoked_by, previous_token_hash, previous_token_expires_at
+FROM service_access_tokens
+WHERE token_hash = $1
+   OR (previous_token_hash = $1 AND previous_token_expires_at > now())
 `
 
 func (q *Queries) GetServiceAccessTokenByHash(ctx context.Context, tokenHash string) (ServiceAccessToken, error) {
@@ -511,13 +1029,108 @@ func (q *Queries) GetServiceAccessTokenByHash(ctx context.Context, tokenHash str
 		&i.IssuedAt,
 		&i.ExpiresAt,
 		&i.UserID,
+		&i.OrganizationID,
+		&i.Scopes,
+		&i.LastUsedAt,
+		&i.LastUsedIp,
+		&i.UsageCount,
+		&i.RevokedAt,
+		&i.RevokedBy,
+		&i.PreviousTokenHash,
+		&i.PreviousTokenExpiresAt,
 	)
 	return i, err
 }
 
-const getSnippetByID = `-- name: GetSnippetByID :one
-SELECT
-    s.id,
+const getServiceAccessTokenByID = `-- name: GetServiceAccessTokenByID :one
+SELECT id, name, created_at, updated_at, token_hash, issued_at, expires_at, user_id, organization_id, scopes, last_used_at, last_used_ip, usage_count, revoked_at, revoked_by, previous_token_hash, previous_token_expires_at FROM service_access_tokens WHERE id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) GetServiceAccessTokenByID(ctx context.Context, id int64) (ServiceAccessToken, error) {
+	row := q.db.QueryRow(ctx, getServiceAccessTokenByID, id)
+	var i ServiceAccessToken
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TokenHash,
+		&i.IssuedAt,
+		&i.ExpiresAt,
+		&i.UserID,
+		&i.OrganizationID,
+		&i.Scopes,
+		&i.LastUsedAt,
+		&i.LastUsedIp,
+		&i.UsageCount,
+		&i.RevokedAt,
+		&i.RevokedBy,
+		&i.PreviousTokenHash,
+		&i.PreviousTokenExpiresAt,
+	)
+	return i, err
+}
+
+const getSession = `-- name: GetSession :one
+SELECT id, created_at, updated_at, device_name, user_agent, ip_address, last_used_at, user_id FROM sessions WHERE id = $1 AND user_id = $2
+`
+
+type GetSessionParams struct {
+	ID     int64
+	UserID int64
+}
+
+func (q *Queries) GetSession(ctx context.Context, arg GetSessionParams) (Session, error) {
+	row := q.db.QueryRow(ctx, getSession, arg.ID, arg.UserID)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeviceName,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastUsedAt,
+		&i.UserID,
+	)
+	return i, err
+}
+
+const getSnippetAuditState = `-- name: GetSnippetAuditState :one
+SELECT s.title, s.code, s.visibility, s.user_id, s.organization_id, s.deleted_at, l.name AS language_name
+FROM snippets s
+LEFT JOIN languages l ON s.language_id = l.id
+WHERE s.id = $1
+`
+
+type GetSnippetAuditStateRow struct {
+	Title          pgtype.Text
+	Code           pgtype.Text
+	Visibility     string
+	UserID         pgtype.Int8
+	OrganizationID pgtype.Int8
+	DeletedAt      pgtype.Timestamptz
+	LanguageName   pgtype.Text
+}
+
+func (q *Queries) GetSnippetAuditState(ctx context.Context, id int64) (GetSnippetAuditStateRow, error) {
+	row := q.db.QueryRow(ctx, getSnippetAuditState, id)
+	var i GetSnippetAuditStateRow
+	err := row.Scan(
+		&i.Title,
+		&i.Code,
+		&i.Visibility,
+		&i.UserID,
+		&i.OrganizationID,
+		&i.DeletedAt,
+		&i.LanguageName,
+	)
+	return i, err
+}
+
+const getSnippetByID = `-- name: GetSnippetByID :one
+SELECT
+    s.id,
     s.title,
     s.code,
     s.project_url,
@@ -525,6 +1138,8 @@ SELECT
     s.git_version,
     s.created_at,
     s.updated_at,
+    s.visibility,
+    s.organization_id,
     g.id AS git_repo_id,
     g.url AS git_repo_url,
     l.id AS language_id,
@@ -532,26 +1147,45 @@ SELECT
 FROM snippets s
 LEFT JOIN languages l ON s.language_id = l.id
 LEFT JOIN git_repos g ON s.git_repo_id = g.id
-WHERE s.id = $1
+WHERE s.id = $1 AND s.deleted_at IS NULL
+  AND ($2::BOOLEAN
+    OR s.visibility = 'public'
+    OR ($3::BIGINT IS NOT NULL
+      AND (s.user_id = $3::BIGINT
+        OR (s.visibility = 'team'
+          AND (s.organization_id IS NULL OR EXISTS (
+            SELECT 1
+            FROM organization_members m
+            WHERE m.organization_id = s.organization_id
+              AND m.user_id = $3::BIGINT))))))
 `
 
-type GetSnippetByIDRow struct {
-	ID           int64
-	Title        pgtype.Text
-	Code         pgtype.Text
-	ProjectUrl   pgtype.Text
-	GitFilePath  pgtype.Text
-	GitVersion   pgtype.Text
-	CreatedAt    pgtype.Timestamptz
-	UpdatedAt    pgtype.Timestamptz
-	GitRepoID    pgtype.Int8
-	GitRepoUrl   pgtype.Text
-	LanguageID   pgtype.Int8
-	LanguageName pgtype.Text
+type GetSnippetByIDParams struct {
+	ID            int64
+	AnyVisibility bool
+	ViewerID      pgtype.Int8
 }
 
-func (q *Queries) GetSnippetByID(ctx context.Context, id int64) (GetSnippetByIDRow, error) {
-	row := q.db.QueryRow(ctx, getSnippetByID, id)
+type GetSnippetByIDRow struct {
+	ID             int64
+	Title          pgtype.Text
+	Code           pgtype.Text
+	ProjectUrl     pgtype.Text
+	GitFilePath    pgtype.Text
+	GitVersion     pgtype.Text
+	CreatedAt      pgtype.Timestamptz
+	UpdatedAt      pgtype.Timestamptz
+	Visibility     string
+	OrganizationID pgtype.Int8
+	GitRepoID      pgtype.Int8
+	GitRepoUrl     pgtype.Text
+	LanguageID     pgtype.Int8
+	LanguageName   pgtype.Text
+}
+
+// any_visibility skips the visibility filter, for moderators.
+func (q *Queries) GetSnippetByID(ctx context.Context, arg GetSnippetByIDParams) (GetSnippetByIDRow, error) {
+	row := q.db.QueryRow(ctx, getSnippetByID, arg.ID, arg.AnyVisibility, arg.ViewerID)
 	var i GetSnippetByIDRow
 	err := row.Scan(
 		&i.ID,
@@ -562,6 +1196,8 @@ func (q *Queries) GetSnippetByID(ctx context.Context, id int64) (GetSnippetByIDR
 		&i.GitVersion,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Visibility,
+		&i.OrganizationID,
 		&i.GitRepoID,
 		&i.GitRepoUrl,
 		&i.LanguageID,
@@ -570,17 +1206,93 @@ func (q *Queries) GetSnippetByID(ctx context.Context, id int64) (GetSnippetByIDR
 	return i, err
 }
 
-const getSnippetIDByTitle = `-- name: GetSnippetIDByTitle :one
-SELECT id FROM snippets WHERE title=$1
+const getSnippetIDByRepoPath = `-- name: GetSnippetIDByRepoPath :one
+SELECT id FROM snippets
+WHERE git_repo_id = $1 AND git_file_path = $2 AND organization_id IS NOT DISTINCT FROM $3
 `
 
-func (q *Queries) GetSnippetIDByTitle(ctx context.Context, title pgtype.Text) (int64, error) {
-	row := q.db.QueryRow(ctx, getSnippetIDByTitle, title)
+type GetSnippetIDByRepoPathParams struct {
+	GitRepoID      pgtype.Int8
+	GitFilePath    pgtype.Text
+	OrganizationID pgtype.Int8
+}
+
+func (q *Queries) GetSnippetIDByRepoPath(ctx context.Context, arg GetSnippetIDByRepoPathParams) (int64, error) {
+	row := q.db.QueryRow(ctx, getSnippetIDByRepoPath, arg.GitRepoID, arg.GitFilePath, arg.OrganizationID)
 	var id int64
 	err := row.Scan(&id)
 	return id, err
 }
 
+const getSnippetOwnerForUpdate = `-- name: GetSnippetOwnerForUpdate :one
+SELECT user_id, deleted_at FROM snippets WHERE id = $1 FOR UPDATE
+`
+
+type GetSnippetOwnerForUpdateRow struct {
+	UserID    pgtype.Int8
+	DeletedAt pgtype.Timestamptz
+}
+
+func (q *Queries) GetSnippetOwnerForUpdate(ctx context.Context, id int64) (GetSnippetOwnerForUpdateRow, error) {
+	row := q.db.QueryRow(ctx, getSnippetOwnerForUpdate, id)
+	var i GetSnippetOwnerForUpdateRow
+	err := row.Scan(&i.UserID, &i.DeletedAt)
+	return i, err
+}
+
+const getSnippetRevision = `-- name: GetSnippetRevision :one
+SELECT
+    r.revision,
+    r.title,
+    r.code,
+    r.project_url,
+    r.git_file_path,
+    r.git_version,
+    r.user_id,
+    r.created_at,
+    l.id AS language_id,
+    l.name AS language_name
+FROM snippet_revisions r
+LEFT JOIN languages l ON r.language_id = l.id
+WHERE r.snippet_id = $1 AND r.revision = $2
+`
+
+type GetSnippetRevisionParams struct {
+	SnippetID int64
+	Revision  int32
+}
+
+type GetSnippetRevisionRow struct {
+	Revision     int32
+	Title        pgtype.Text
+	Code         pgtype.Text
+	ProjectUrl   pgtype.Text
+	GitFilePath  pgtype.Text
+	GitVersion   pgtype.Text
+	UserID       pgtype.Int8
+	CreatedAt    pgtype.Timestamptz
+	LanguageID   pgtype.Int8
+	LanguageName pgtype.Text
+}
+
+func (q *Queries) GetSnippetRevision(ctx context.Context, arg GetSnippetRevisionParams) (GetSnippetRevisionRow, error) {
+	row := q.db.QueryRow(ctx, getSnippetRevision, arg.SnippetID, arg.Revision)
+	var i GetSnippetRevisionRow
+	err := row.Scan(
+		&i.Revision,
+		&i.Title,
+		&i.Code,
+		&i.ProjectUrl,
+		&i.GitFilePath,
+		&i.GitVersion,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.LanguageID,
+		&i.LanguageName,
+	)
+	return i, err
+}
+
 const getTagIDByName = `-- name: GetTagIDByName :one
 SELECT id FROM tags WHERE name=$1
 `
@@ -686,8 +1398,24 @@ func (q *Queries) GetTagsBySnippetIDs(ctx context.Context, snippetIds []int64) (
 	return items, nil
 }
 
+const getUserAccess = `-- name: GetUserAccess :one
+SELECT role, disabled_at FROM users WHERE id = $1
+`
+
+type GetUserAccessRow struct {
+	Role       string
+	DisabledAt pgtype.Timestamptz
+}
+
+func (q *Queries) GetUserAccess(ctx context.Context, id int64) (GetUserAccessRow, error) {
+	row := q.db.QueryRow(ctx, getUserAccess, id)
+	var i GetUserAccessRow
+	err := row.Scan(&i.Role, &i.DisabledAt)
+	return i, err
+}
+
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, created_at, updated_at, username, email, password_hash FROM users WHERE id = $1
+SELECT id, created_at, updated_at, username, email, password_hash, display_name, default_language_id, role, disabled_at FROM users WHERE id = $1
 `
 
 func (q *Queries) GetUserByID(ctx context.Context, id int64) (User, error) {
@@ -700,6 +1428,32 @@ func (q *Queries) GetUserByID(ctx context.Context, id int64) (User, error) {
 		&i.Username,
 		&i.Email,
 		&i.PasswordHash,
+		&i.DisplayName,
+		&i.DefaultLanguageID,
+		&i.Role,
+		&i.DisabledAt,
+	)
+	return i, err
+}
+
+const getUserByIDForUpdate = `-- name: GetUserByIDForUpdate :one
+SELECT id, created_at, updated_at, username, email, password_hash, display_name, default_language_id, role, disabled_at FROM users WHERE id = $1 FOR UPDATE
+`
+
+func (q *Queries) GetUserByIDForUpdate(ctx context.Context, id int64) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByIDForUpdate, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Username,
+		&i.Email,
+		&i.PasswordHash,
+		&i.DisplayName,
+		&i.DefaultLanguageID,
+		&i.Role,
+		&i.DisabledAt,
 	)
 	return i, err
 }
@@ -715,6 +1469,44 @@ func (q *Queries) GetUserIDByEmail(ctx context.Context, email string) (int64, er
 	return id, err
 }
 
+const getUserIDByUsername = `-- name: GetUserIDByUsername :one
+SELECT id FROM users WHERE username = $1
+`
+
+func (q *Queries) GetUserIDByUsername(ctx context.Context, username string) (int64, error) {
+	row := q.db.QueryRow(ctx, getUserIDByUsername, username)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const getUserIdentity = `-- name: GetUserIdentity :one
+
+SELECT id, created_at, updated_at, provider, provider_user_id, username, email, user_id FROM user_identities WHERE provider = $1 AND provider_user_id = $2
+`
+
+type GetUserIdentityParams struct {
+	Provider       string
+	ProviderUserID string
+}
+
+// User identities
+func (q *Queries) GetUserIdentity(ctx context.Context, arg GetUserIdentityParams) (UserIdentity, error) {
+	row := q.db.QueryRow(ctx, getUserIdentity, arg.Provider, arg.ProviderUserID)
+	var i UserIdentity
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Provider,
+		&i.ProviderUserID,
+		&i.Username,
+		&i.Email,
+		&i.UserID,
+	)
+	return i, err
+}
+
 const linkSnippetContributor = `-- name: LinkSnippetContributor :exec
 INSERT INTO snippet_contributors (snippet_id, contributor_id) VALUES($1, $2) ON CONFLICT (snippet_id, contributor_id) DO NOTHING
 `
@@ -743,6 +1535,55 @@ func (q *Queries) LinkSnippetTag(ctx context.Context, arg LinkSnippetTagParams)
 	return err
 }
 
+const listActiveSessions = `-- name: ListActiveSessions :many
+SELECT id, created_at, updated_at, device_name, user_agent, ip_address, last_used_at, user_id
+FROM sessions
+WHERE user_id = $1 AND last_used_at > $2
+ORDER BY last_used_at DESC
+OFFSET $3 LIMIT $4
+`
+
+type ListActiveSessionsParams struct {
+	UserID     int64
+	LastUsedAt pgtype.Timestamptz
+	Offset     int32
+	Limit      int32
+}
+
+func (q *Queries) ListActiveSessions(ctx context.Context, arg ListActiveSessionsParams) ([]Session, error) {
+	rows, err := q.db.Query(ctx, listActiveSessions,
+		arg.UserID,
+		arg.LastUsedAt,
+		arg.Offset,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Session
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeviceName,
+			&i.UserAgent,
+			&i.IpAddress,
+			&i.LastUsedAt,
+			&i.UserID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listAllContributors = `-- name: ListAllContributors :many
 SELECT id, created_at, updated_at, first_name, last_name, email FROM contributors
 `
@@ -833,7 +1674,7 @@ func (q *Queries) ListAllTags(ctx context.Context) ([]Tag, error) {
 }
 
 const listAllUsers = `-- name: ListAllUsers :many
-SELECT id, created_at, updated_at, username, email, password_hash FROM users
+SELECT id, created_at, updated_at, username, email, password_hash, display_name, default_language_id, role, disabled_at FROM users
 `
 
 func (q *Queries) ListAllUsers(ctx context.Context) ([]User, error) {
@@ -852,6 +1693,79 @@ func (q *Queries) ListAllUsers(ctx context.Context) ([]User, error) {
 			&i.Username,
 			&i.Email,
 			&i.PasswordHash,
+			&i.DisplayName,
+			&i.DefaultLanguageID,
+			&i.Role,
+			&i.DisabledAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditEvents = `-- name: ListAuditEvents :many
+SELECT id, created_at, actor_id, action, target_type, target_id, data, token_id, session_id, ip_address, before_data, after_data FROM audit_events
+WHERE ($1::BIGINT IS NULL OR id < $1::BIGINT)
+  AND ($2::BIGINT IS NULL OR actor_id = $2::BIGINT)
+  AND ($3::VARCHAR IS NULL OR action = $3::VARCHAR)
+  AND ($4::VARCHAR IS NULL OR target_type = $4::VARCHAR)
+  AND ($5::BIGINT IS NULL OR target_id = $5::BIGINT)
+  AND ($6::BIGINT IS NULL OR token_id = $6::BIGINT)
+  AND ($7::TIMESTAMPTZ IS NULL OR created_at >= $7::TIMESTAMPTZ)
+  AND ($8::TIMESTAMPTZ IS NULL OR created_at < $8::TIMESTAMPTZ)
+ORDER BY id DESC
+LIMIT $9
+`
+
+type ListAuditEventsParams struct {
+	BeforeID   pgtype.Int8
+	ActorID    pgtype.Int8
+	Action     pgtype.Text
+	TargetType pgtype.Text
+	TargetID   pgtype.Int8
+	TokenID    pgtype.Int8
+	Since      pgtype.Timestamptz
+	Until      pgtype.Timestamptz
+	Limit      int32
+}
+
+func (q *Queries) ListAuditEvents(ctx context.Context, arg ListAuditEventsParams) ([]AuditEvent, error) {
+	rows, err := q.db.Query(ctx, listAuditEvents,
+		arg.BeforeID,
+		arg.ActorID,
+		arg.Action,
+		arg.TargetType,
+		arg.TargetID,
+		arg.TokenID,
+		arg.Since,
+		arg.Until,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditEvent
+	for rows.Next() {
+		var i AuditEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.ActorID,
+			&i.Action,
+			&i.TargetType,
+			&i.TargetID,
+			&i.Data,
+			&i.TokenID,
+			&i.SessionID,
+			&i.IpAddress,
+			&i.BeforeData,
+			&i.AfterData,
 		); err != nil {
 			return nil, err
 		}
@@ -985,31 +1899,44 @@ func (q *Queries) ListLinkedTagIDs(ctx context.Context) ([]int64, error) {
 	return items, nil
 }
 
-const listServiceAccessTokensByUserID = `-- name: ListServiceAccessTokensByUserID :many
-SELECT id, name, created_at, updated_at, token_hash, issued_at, expires_at, user_id
-FROM service_access_tokens
-WHERE user_id = $1
-ORDER BY created_at DESC
+const listOrganizationMembers = `-- name: ListOrganizationMembers :many
+SELECT u.id, u.username, u.email, m.role, m.created_at
+FROM organization_members m
+INNER JOIN users u ON u.id = m.user_id
+WHERE m.organization_id = $1
+ORDER BY m.created_at, u.id
+OFFSET $2 LIMIT $3
 `
 
-func (q *Queries) ListServiceAccessTokensByUserID(ctx context.Context, userID pgtype.Int8) ([]ServiceAccessToken, error) {
-	rows, err := q.db.Query(ctx, listServiceAccessTokensByUserID, userID)
+type ListOrganizationMembersParams struct {
+	OrganizationID int64
+	Offset         int32
+	Limit          int32
+}
+
+type ListOrganizationMembersRow struct {
+	ID        int64
+	Username  string
+	Email     string
+	Role      string
+	CreatedAt pgtype.Timestamptz
+}
+
+func (q *Queries) ListOrganizationMembers(ctx context.Context, arg ListOrganizationMembersParams) ([]ListOrganizationMembersRow, error) {
+	rows, err := q.db.Query(ctx, listOrganizationMembers, arg.OrganizationID, arg.Offset, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []ServiceAccessToken
+	var items []ListOrganizationMembersRow
 	for rows.Next() {
-		var i ServiceAccessToken
+		var i ListOrganizationMembersRow
 		if err := rows.Scan(
 			&i.ID,
-			&i.Name,
+			&i.Username,
+			&i.Email,
+			&i.Role,
 			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.TokenHash,
-			&i.IssuedAt,
-			&i.ExpiresAt,
-			&i.UserID,
 		); err != nil {
 			return nil, err
 		}
@@ -1021,23 +1948,46 @@ func (q *Queries) ListServiceAccessTokensByUserID(ctx context.Context, userID pg
 	return items, nil
 }
 
-const listSnippetIDs = `-- name: ListSnippetIDs :many
-SELECT id FROM snippets
+const listOrganizationsByUserID = `-- name: ListOrganizationsByUserID :many
+SELECT o.id, o.name, o.created_at, m.role
+FROM organizations o
+INNER JOIN organization_members m ON m.organization_id = o.id
+WHERE m.user_id = $1
+ORDER BY o.name
+OFFSET $2 LIMIT $3
 `
 
-func (q *Queries) ListSnippetIDs(ctx context.Context) ([]int64, error) {
-	rows, err := q.db.Query(ctx, listSnippetIDs)
+type ListOrganizationsByUserIDParams struct {
+	UserID int64
+	Offset int32
+	Limit  int32
+}
+
+type ListOrganizationsByUserIDRow struct {
+	ID        int64
+	Name      string
+	CreatedAt pgtype.Timestamptz
+	Role      string
+}
+
+func (q *Queries) ListOrganizationsByUserID(ctx context.Context, arg ListOrganizationsByUserIDParams) ([]ListOrganizationsByUserIDRow, error) {
+	rows, err := q.db.Query(ctx, listOrganizationsByUserID, arg.UserID, arg.Offset, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []int64
+	var items []ListOrganizationsByUserIDRow
 	for rows.Next() {
-		var id int64
-		if err := rows.Scan(&id); err != nil {
+		var i ListOrganizationsByUserIDRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.CreatedAt,
+			&i.Role,
+		); err != nil {
 			return nil, err
 		}
-		items = append(items, id)
+		items = append(items, i)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -1045,24 +1995,259 @@ func (q *Queries) ListSnippetIDs(ctx context.Context) ([]int64, error) {
 	return items, nil
 }
 
-const listSnippetsFiltered = `-- name: ListSnippetsFiltered :many
-SELECT
-    s.id,
-    s.title,
-    s.project_url,
-    s.git_file_path,
-    s.git_version,
-    g.id AS git_repo_id,
-    g.url AS git_repo_url,
-    l.id AS language_id,
-    l.name AS language_name
-FROM snippets s
-LEFT JOIN languages l ON s.language_id = l.id
-LEFT JOIN git_repos g ON s.git_repo_id = g.id
-WHERE ($1::BIGINT IS NULL OR s.language_id = $1::BIGINT)
-  AND NOT EXISTS (
+const listOrphanedSnippets = `-- name: ListOrphanedSnippets :many
+SELECT id, title, organization_id, created_at FROM snippets
+WHERE user_id IS NULL AND deleted_at IS NULL
+ORDER BY id
+OFFSET $1 LIMIT $2
+`
+
+type ListOrphanedSnippetsParams struct {
+	Offset int32
+	Limit  int32
+}
+
+type ListOrphanedSnippetsRow struct {
+	ID             int64
+	Title          pgtype.Text
+	OrganizationID pgtype.Int8
+	CreatedAt      pgtype.Timestamptz
+}
+
+func (q *Queries) ListOrphanedSnippets(ctx context.Context, arg ListOrphanedSnippetsParams) ([]ListOrphanedSnippetsRow, error) {
+	rows, err := q.db.Query(ctx, listOrphanedSnippets, arg.Offset, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListOrphanedSnippetsRow
+	for rows.Next() {
+		var i ListOrphanedSnippetsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.OrganizationID,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listServiceAccessTokensByOrganizationID = `-- name: ListServiceAccessTokensByOrganizationID :many
+SELECT id, name, created_at, updated_at, token_hash, issued_at, expires_at, user_id, organization_id, scopes, last_used_at, last_used_ip, usage_count, revoked_at, revoked_by, previous_token_hash, previous_token_expires_at
+FROM service_access_tokens
+WHERE organization_id = $1 AND revoked_at IS NULL
+ORDER BY created_at DESC
+OFFSET $2 LIMIT $3
+`
+
+type ListServiceAccessTokensByOrganizationIDParams struct {
+	OrganizationID pgtype.Int8
+	Offset         int32
+	Limit          int32
+}
+
+func (q *Queries) ListServiceAccessTokensByOrganizationID(ctx context.Context, arg ListServiceAccessTokensByOrganizationIDParams) ([]ServiceAccessToken, error) {
+	rows, err := q.db.Query(ctx, listServiceAccessTokensByOrganizationID, arg.OrganizationID, arg.Offset, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ServiceAccessToken
+	for rows.Next() {
+		var i ServiceAccessToken
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TokenHash,
+			&i.IssuedAt,
+			&i.ExpiresAt,
+			&i.UserID,
+			&i.OrganizationID,
+			&i.Scopes,
+			&i.LastUsedAt,
+			&i.LastUsedIp,
+			&i.UsageCount,
+			&i.RevokedAt,
+			&i.RevokedBy,
+			&i.PreviousTokenHash,
+			&i.PreviousTokenExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listServiceAccessTokensByUserID = `-- name: ListServiceAccessTokensByUserID :many
+SELECT id, name, created_at, updated_at, token_hash, issued_at, expires_at, user_id, organization_id, scopes, last_used_at, last_used_ip, usage_count, revoked_at, revoked_by, previous_token_hash, previous_token_expires_at
+FROM service_access_tokens
+WHERE user_id = $1 AND revoked_at IS NULL
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListServiceAccessTokensByUserID(ctx context.Context, userID pgtype.Int8) ([]ServiceAccessToken, error) {
+	rows, err := q.db.Query(ctx, listServiceAccessTokensByUserID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ServiceAccessToken
+	for rows.Next() {
+		var i ServiceAccessToken
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TokenHash,
+			&i.IssuedAt,
+			&i.ExpiresAt,
+			&i.UserID,
+			&i.OrganizationID,
+			&i.Scopes,
+			&i.LastUsedAt,
+			&i.LastUsedIp,
+			&i.UsageCount,
+			&i.RevokedAt,
+			&i.RevokedBy,
+			&i.PreviousTokenHash,
+			&i.PreviousTokenExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSnippetIDs = `-- name: ListSnippetIDs :many
+SELECT id FROM snippets
+`
+
+func (q *Queries) ListSnippetIDs(ctx context.Context) ([]int64, error) {
+	rows, err := q.db.Query(ctx, listSnippetIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSnippetRevisions = `-- name: ListSnippetRevisions :many
+SELECT id, revision, git_version, user_id, created_at
+FROM snippet_revisions
+WHERE snippet_id = $1
+ORDER BY revision DESC
+OFFSET $2 LIMIT $3
+`
+
+type ListSnippetRevisionsParams struct {
+	SnippetID int64
+	Offset    int32
+	Limit     int32
+}
+
+type ListSnippetRevisionsRow struct {
+	ID         int64
+	Revision   int32
+	GitVersion pgtype.Text
+	UserID     pgtype.Int8
+	CreatedAt  pgtype.Timestamptz
+}
+
+func (q *Queries) ListSnippetRevisions(ctx context.Context, arg ListSnippetRevisionsParams) ([]ListSnippetRevisionsRow, error) {
+	rows, err := q.db.Query(ctx, listSnippetRevisions, arg.SnippetID, arg.Offset, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListSnippetRevisionsRow
+	for rows.Next() {
+		var i ListSnippetRevisionsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Revision,
+			&i.GitVersion,
+			&i.UserID,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSnippetsFiltered = `-- name: ListSnippetsFiltered :many
+SELECT
+    s.id,
+    s.title,
+    s.project_url,
+    s.git_file_path,
+    s.git_version,
+    g.id AS git_repo_id,
+    g.url AS git_repo_url,
+    l.id AS language_id,
+    l.name AS language_name,
+    s.visibility,
+    s.organization_id,
+    COALESCE(CASE WHEN $1::BOOLEAN
+        THEN word_similarity($2::TEXT, s.title)
+        ELSE ts_rank(s.search_vector, websearch_to_tsquery('simple', $2::TEXT))
+    END, 0)::REAL AS rank,
+    COALESCE(word_similarity($2::TEXT, s.title), 0)::REAL AS similarity,
+    (CASE WHEN $1::BOOLEAN THEN ''
+        ELSE COALESCE(ts_headline('simple', s.code, websearch_to_tsquery('simple', $2::TEXT),
+            'StartSel=<mark>, StopSel=</mark>, MaxFragments=1, MaxWords=24, MinWords=8'), '')
+    END)::TEXT AS headline
+FROM snippets s
+LEFT JOIN languages l ON s.language_id = l.id
+LEFT JOIN git_repos g ON s.git_repo_id = g.id
+WHERE s.deleted_at IS NULL
+  AND (s.visibility = 'public'
+    OR ($3::BIGINT IS NOT NULL
+      AND (s.user_id = $3::BIGINT
+        OR (s.visibility = 'team'
+          AND (s.organization_id IS NULL OR EXISTS (
+            SELECT 1
+            FROM organization_members m
+            WHERE m.organization_id = s.organization_id
+              AND m.user_id = $3::BIGINT))))))
+  AND ($4::BIGINT IS NULL OR s.organization_id = $4::BIGINT)
+  AND ($5::BIGINT IS NULL OR s.language_id = $5::BIGINT)
+  AND NOT EXISTS (
     SELECT 1
-    FROM (SELECT unnest($2::BIGINT[]) AS tag_id) ft
+    FROM (SELECT unnest($6::BIGINT[]) AS tag_id) ft
     WHERE NOT EXISTS (
       SELECT 1
       FROM snippet_tags st
@@ -1070,53 +2255,394 @@ WHERE ($1::BIGINT IS NULL OR s.language_id = $1::BIGINT)
         AND st.tag_id = ft.tag_id
     )
   )
-ORDER BY s.id
-OFFSET $3::INT LIMIT $4::INT
+  AND ($2::TEXT IS NULL
+    OR ($1::BOOLEAN AND $2::TEXT <% s.title)
+    OR (NOT $1::BOOLEAN AND s.search_vector @@ websearch_to_tsquery('simple', $2::TEXT)))
+ORDER BY rank DESC, s.id
+OFFSET $7::INT LIMIT $8::INT
 `
 
 type ListSnippetsFilteredParams struct {
-	LanguageID pgtype.Int8
-	TagIds     []int64
-	SqlOffset  int32
-	SqlLimit   int32
+	Fuzzy          bool
+	Query          pgtype.Text
+	ViewerID       pgtype.Int8
+	OrganizationID pgtype.Int8
+	LanguageID     pgtype.Int8
+	TagIds         []int64
+	SqlOffset      int32
+	SqlLimit       int32
 }
 
 type ListSnippetsFilteredRow struct {
+	ID             int64
+	Title          pgtype.Text
+	ProjectUrl     pgtype.Text
+	GitFilePath    pgtype.Text
+	GitVersion     pgtype.Text
+	GitRepoID      pgtype.Int8
+	GitRepoUrl     pgtype.Text
+	LanguageID     pgtype.Int8
+	LanguageName   pgtype.Text
+	Visibility     string
+	OrganizationID pgtype.Int8
+	Rank           float32
+	Similarity     float32
+	Headline       string
+}
+
+func (q *Queries) ListSnippetsFiltered(ctx context.Context, arg ListSnippetsFilteredParams) ([]ListSnippetsFilteredRow, error) {
+	rows, err := q.db.Query(ctx, listSnippetsFiltered,
+		arg.Fuzzy,
+		arg.Query,
+		arg.ViewerID,
+		arg.OrganizationID,
+		arg.LanguageID,
+		arg.TagIds,
+		arg.SqlOffset,
+		arg.SqlLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListSnippetsFilteredRow
+	for rows.Next() {
+		var i ListSnippetsFilteredRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.ProjectUrl,
+			&i.GitFilePath,
+			&i.GitVersion,
+			&i.GitRepoID,
+			&i.GitRepoUrl,
+			&i.LanguageID,
+			&i.LanguageName,
+			&i.Visibility,
+			&i.OrganizationID,
+			&i.Rank,
+			&i.Similarity,
+			&i.Headline,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSnippetsForTransfer = `-- name: ListSnippetsForTransfer :many
+SELECT
+    s.id,
+    s.organization_id,
+    s.visibility,
+    EXISTS (
+        SELECT 1 FROM organization_members m
+        WHERE m.organization_id = s.organization_id
+            AND m.user_id = $1::BIGINT
+    ) AS recipient_is_member
+FROM snippets s
+WHERE s.user_id = $2::BIGINT
+ORDER BY s.id
+FOR UPDATE OF s
+`
+
+type ListSnippetsForTransferParams struct {
+	ToUserID   int64
+	FromUserID int64
+}
+
+type ListSnippetsForTransferRow struct {
+	ID                int64
+	OrganizationID    pgtype.Int8
+	Visibility        string
+	RecipientIsMember bool
+}
+
+// locks the snippets of a deleted account while they are handed over
+func (q *Queries) ListSnippetsForTransfer(ctx context.Context, arg ListSnippetsForTransferParams) ([]ListSnippetsForTransferRow, error) {
+	rows, err := q.db.Query(ctx, listSnippetsForTransfer, arg.ToUserID, arg.FromUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListSnippetsForTransferRow
+	for rows.Next() {
+		var i ListSnippetsForTransferRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrganizationID,
+			&i.Visibility,
+			&i.RecipientIsMember,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSoleOwnedOrganizations = `-- name: ListSoleOwnedOrganizations :many
+SELECT o.id, o.name
+FROM organizations o
+INNER JOIN organization_members m ON m.organization_id = o.id
+WHERE m.user_id = $1 AND m.role = 'owner'
+  AND NOT EXISTS (
+    SELECT 1
+    FROM organization_members other
+    WHERE other.organization_id = o.id
+      AND other.role = 'owner'
+      AND other.user_id <> $1
+  )
+ORDER BY o.name
+`
+
+type ListSoleOwnedOrganizationsRow struct {
+	ID   int64
+	Name string
+}
+
+// Organizations that would be left without an owner if the user went away.
+func (q *Queries) ListSoleOwnedOrganizations(ctx context.Context, userID int64) ([]ListSoleOwnedOrganizationsRow, error) {
+	rows, err := q.db.Query(ctx, listSoleOwnedOrganizations, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListSoleOwnedOrganizationsRow
+	for rows.Next() {
+		var i ListSoleOwnedOrganizationsRow
+		if err := rows.Scan(&i.ID, &i.Name); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listStaleServiceAccessTokens = `-- name: ListStaleServiceAccessTokens :many
+SELECT id, name, created_at, updated_at, token_hash, issued_at, expires_at, user_id, organization_id, scopes, last_used_at, last_used_ip, usage_count, revoked_at, revoked_by, previous_token_hash, previous_token_expires_at
+FROM service_access_tokens
+WHERE user_id = $1
+  AND revoked_at IS NULL
+  AND (
+    COALESCE(last_used_at, issued_at) < $2::TIMESTAMPTZ
+    OR expires_at < $3::TIMESTAMPTZ
+  )
+ORDER BY expires_at ASC, id ASC
+OFFSET $4 LIMIT $5
+`
+
+type ListStaleServiceAccessTokensParams struct {
+	UserID        pgtype.Int8
+	UnusedSince   pgtype.Timestamptz
+	ExpiresBefore pgtype.Timestamptz
+	Offset        int32
+	Limit         int32
+}
+
+func (q *Queries) ListStaleServiceAccessTokens(ctx context.Context, arg ListStaleServiceAccessTokensParams) ([]ServiceAccessToken, error) {
+	rows, err := q.db.Query(ctx, listStaleServiceAccessTokens,
+		arg.UserID,
+		arg.UnusedSince,
+		arg.ExpiresBefore,
+		arg.Offset,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ServiceAccessToken
+	for rows.Next() {
+		var i ServiceAccessToken
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TokenHash,
+			&i.IssuedAt,
+			&i.ExpiresAt,
+			&i.UserID,
+			&i.OrganizationID,
+			&i.Scopes,
+			&i.LastUsedAt,
+			&i.LastUsedIp,
+			&i.UsageCount,
+			&i.RevokedAt,
+			&i.RevokedBy,
+			&i.PreviousTokenHash,
+			&i.PreviousTokenExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTags = `-- name: ListTags :many
+
+SELECT id, created_at, updated_at, name FROM tags OFFSET $1 LIMIT $2
+`
+
+type ListTagsParams struct {
+	Offset int32
+	Limit  int32
+}
+
+// Tags
+func (q *Queries) ListTags(ctx context.Context, arg ListTagsParams) ([]Tag, error) {
+	rows, err := q.db.Query(ctx, listTags, arg.Offset, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Tag
+	for rows.Next() {
+		var i Tag
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Name,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTrashedSnippets = `-- name: ListTrashedSnippets :many
+SELECT
+    s.id,
+    s.title,
+    s.git_file_path,
+    s.git_version,
+    g.id AS git_repo_id,
+    g.url AS git_repo_url,
+    l.id AS language_id,
+    l.name AS language_name,
+    s.deleted_at
+FROM snippets s
+LEFT JOIN languages l ON s.language_id = l.id
+LEFT JOIN git_repos g ON s.git_repo_id = g.id
+WHERE s.user_id = $1 AND s.deleted_at IS NOT NULL
+ORDER BY s.deleted_at DESC
+OFFSET $2 LIMIT $3
+`
+
+type ListTrashedSnippetsParams struct {
+	UserID pgtype.Int8
+	Offset int32
+	Limit  int32
+}
+
+type ListTrashedSnippetsRow struct {
 	ID           int64
 	Title        pgtype.Text
-	ProjectUrl   pgtype.Text
 	GitFilePath  pgtype.Text
 	GitVersion   pgtype.Text
 	GitRepoID    pgtype.Int8
 	GitRepoUrl   pgtype.Text
 	LanguageID   pgtype.Int8
 	LanguageName pgtype.Text
+	DeletedAt    pgtype.Timestamptz
 }
 
-func (q *Queries) ListSnippetsFiltered(ctx context.Context, arg ListSnippetsFilteredParams) ([]ListSnippetsFilteredRow, error) {
-	rows, err := q.db.Query(ctx, listSnippetsFiltered,
-		arg.LanguageID,
-		arg.TagIds,
-		arg.SqlOffset,
-		arg.SqlLimit,
-	)
+func (q *Queries) ListTrashedSnippets(ctx context.Context, arg ListTrashedSnippetsParams) ([]ListTrashedSnippetsRow, error) {
+	rows, err := q.db.Query(ctx, listTrashedSnippets, arg.UserID, arg.Offset, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTrashedSnippetsRow
+	for rows.Next() {
+		var i ListTrashedSnippetsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.GitFilePath,
+			&i.GitVersion,
+			&i.GitRepoID,
+			&i.GitRepoUrl,
+			&i.LanguageID,
+			&i.LanguageName,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsedLanguageIDs = `-- name: ListUsedLanguageIDs :many
+SELECT DISTINCT(language_id) FROM snippets
+`
+
+func (q *Queries) ListUsedLanguageIDs(ctx context.Context) ([]pgtype.Int8, error) {
+	rows, err := q.db.Query(ctx, listUsedLanguageIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []pgtype.Int8
+	for rows.Next() {
+		var language_id pgtype.Int8
+		if err := rows.Scan(&language_id); err != nil {
+			return nil, err
+		}
+		items = append(items, language_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserIdentities = `-- name: ListUserIdentities :many
+SELECT id, created_at, updated_at, provider, provider_user_id, username, email, user_id FROM user_identities WHERE user_id = $1 ORDER BY provider
+`
+
+func (q *Queries) ListUserIdentities(ctx context.Context, userID int64) ([]UserIdentity, error) {
+	rows, err := q.db.Query(ctx, listUserIdentities, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []ListSnippetsFilteredRow
+	var items []UserIdentity
 	for rows.Next() {
-		var i ListSnippetsFilteredRow
+		var i UserIdentity
 		if err := rows.Scan(
 			&i.ID,
-			&i.Title,
-			&i.ProjectUrl,
-			&i.GitFilePath,
-			&i.GitVersion,
-			&i.GitRepoID,
-			&i.GitRepoUrl,
-			&i.LanguageID,
-			&i.LanguageName,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Provider,
+			&i.ProviderUserID,
+			&i.Username,
+			&i.Email,
+			&i.UserID,
 		); err != nil {
 			return nil, err
 		}
@@ -1128,31 +2654,37 @@ func (q *Queries) ListSnippetsFiltered(ctx context.Context, arg ListSnippetsFilt
 	return items, nil
 }
 
-const listTags = `-- name: ListTags :many
-
-SELECT id, created_at, updated_at, name FROM tags OFFSET $1 LIMIT $2
+const listUsers = `-- name: ListUsers :many
+SELECT id, created_at, updated_at, username, email, password_hash, display_name, default_language_id, role, disabled_at FROM users
+ORDER BY id
+OFFSET $1 LIMIT $2
 `
 
-type ListTagsParams struct {
+type ListUsersParams struct {
 	Offset int32
 	Limit  int32
 }
 
-// Tags
-func (q *Queries) ListTags(ctx context.Context, arg ListTagsParams) ([]Tag, error) {
-	rows, err := q.db.Query(ctx, listTags, arg.Offset, arg.Limit)
+func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error) {
+	rows, err := q.db.Query(ctx, listUsers, arg.Offset, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Tag
+	var items []User
 	for rows.Next() {
-		var i Tag
+		var i User
 		if err := rows.Scan(
 			&i.ID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
-			&i.Name,
+			&i.Username,
+			&i.Email,
+			&i.PasswordHash,
+			&i.DisplayName,
+			&i.DefaultLanguageID,
+			&i.Role,
+			&i.DisabledAt,
 		); err != nil {
 			return nil, err
 		}
@@ -1164,23 +2696,258 @@ func (q *Queries) ListTags(ctx context.Context, arg ListTagsParams) ([]Tag, erro
 	return items, nil
 }
 
-const listUsedLanguageIDs = `-- name: ListUsedLanguageIDs :many
-SELECT DISTINCT(language_id) FROM snippets
+const lockActiveAdmins = `-- name: LockActiveAdmins :many
+SELECT id FROM users WHERE role = 'admin' AND disabled_at IS NULL FOR UPDATE
 `
 
-func (q *Queries) ListUsedLanguageIDs(ctx context.Context) ([]pgtype.Int8, error) {
-	rows, err := q.db.Query(ctx, listUsedLanguageIDs)
+// locks the active admins, so concurrent demotions and disables of admins are
+// checked one after the other
+func (q *Queries) LockActiveAdmins(ctx context.Context) ([]int64, error) {
+	rows, err := q.db.Query(ctx, lockActiveAdmins)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []pgtype.Int8
+	var items []int64
 	for rows.Next() {
-		var language_id pgtype.Int8
-		if err := rows.Scan(&language_id); err != nil {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
 			return nil, err
 		}
-		items = append(items, language_id)
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockOrganizationOwners = `-- name: LockOrganizationOwners :many
+SELECT user_id FROM organization_members WHERE organization_id = $1 AND role = 'owner' FOR UPDATE
+`
+
+// locks the owner memberships, so concurrent demotions and removals of owners
+// are checked one after the other
+func (q *Queries) LockOrganizationOwners(ctx context.Context, organizationID int64) ([]int64, error) {
+	rows, err := q.db.Query(ctx, lockOrganizationOwners, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var user_id int64
+		if err := rows.Scan(&user_id); err != nil {
+			return nil, err
+		}
+		items = append(items, user_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const makeOrganizationSnippetsPrivate = `-- name: MakeOrganizationSnippetsPrivate :exec
+UPDATE snippets SET visibility = 'private', updated_at = CURRENT_TIMESTAMP
+WHERE organization_id = $1 AND visibility = 'team'
+`
+
+// Team snippets of a deleted organization must not become readable by everyone.
+func (q *Queries) MakeOrganizationSnippetsPrivate(ctx context.Context, organizationID pgtype.Int8) error {
+	_, err := q.db.Exec(ctx, makeOrganizationSnippetsPrivate, organizationID)
+	return err
+}
+
+const markRefreshTokenRotated = `-- name: MarkRefreshTokenRotated :execrows
+UPDATE refresh_tokens
+SET rotated_at = now(), updated_at = now()
+WHERE id = $1 AND rotated_at IS NULL
+`
+
+func (q *Queries) MarkRefreshTokenRotated(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.Exec(ctx, markRefreshTokenRotated, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeDeletedSnippets = `-- name: PurgeDeletedSnippets :execrows
+DELETE FROM snippets WHERE deleted_at < $1
+`
+
+func (q *Queries) PurgeDeletedSnippets(ctx context.Context, deletedAt pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeDeletedSnippets, deletedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const recordServiceAccessTokenUsage = `-- name: RecordServiceAccessTokenUsage :exec
+UPDATE service_access_tokens t
+SET usage_count = t.usage_count + u.uses,
+    last_used_ip = CASE
+        WHEN t.last_used_at IS NULL OR u.last_used_at >= t.last_used_at THEN NULLIF(u.last_used_ip, '')
+        ELSE t.last_used_ip
+    END,
+    last_used_at = GREATEST(t.last_used_at, u.last_used_at)
+FROM unnest(
+    $1::BIGINT[],
+    $2::BIGINT[],
+    $3::TIMESTAMPTZ[],
+    $4::VARCHAR[]
+) AS u(id, uses, last_used_at, last_used_ip)
+WHERE t.id = u.id
+`
+
+type RecordServiceAccessTokenUsageParams struct {
+	Ids        []int64
+	Uses       []int64
+	LastUsedAt []pgtype.Timestamptz
+	LastUsedIp []string
+}
+
+func (q *Queries) RecordServiceAccessTokenUsage(ctx context.Context, arg RecordServiceAccessTokenUsageParams) error {
+	_, err := q.db.Exec(ctx, recordServiceAccessTokenUsage,
+		arg.Ids,
+		arg.Uses,
+		arg.LastUsedAt,
+		arg.LastUsedIp,
+	)
+	return err
+}
+
+const removeOrganizationMember = `-- name: RemoveOrganizationMember :execrows
+DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2
+`
+
+type RemoveOrganizationMemberParams struct {
+	OrganizationID int64
+	UserID         int64
+}
+
+func (q *Queries) RemoveOrganizationMember(ctx context.Context, arg RemoveOrganizationMemberParams) (int64, error) {
+	result, err := q.db.Exec(ctx, removeOrganizationMember, arg.OrganizationID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const restoreSnippet = `-- name: RestoreSnippet :exec
+UPDATE snippets SET deleted_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1
+`
+
+func (q *Queries) RestoreSnippet(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, restoreSnippet, id)
+	return err
+}
+
+const revokeServiceAccessToken = `-- name: RevokeServiceAccessToken :execrows
+UPDATE service_access_tokens
+SET revoked_at = now(), revoked_by = $2, updated_at = now()
+WHERE id = $1 AND revoked_at IS NULL
+`
+
+type RevokeServiceAccessTokenParams struct {
+	ID        int64
+	RevokedBy pgtype.Int8
+}
+
+func (q *Queries) RevokeServiceAccessToken(ctx context.Context, arg RevokeServiceAccessTokenParams) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeServiceAccessToken, arg.ID, arg.RevokedBy)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const revokeSessionByID = `-- name: RevokeSessionByID :exec
+DELETE FROM sessions WHERE id = $1
+`
+
+func (q *Queries) RevokeSessionByID(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, revokeSessionByID, id)
+	return err
+}
+
+const rotateServiceAccessToken = `-- name: RotateServiceAccessToken :one
+UPDATE service_access_tokens
+SET previous_token_hash = token_hash,
+    previous_token_expires_at = $3,
+    token_hash = $2,
+    issued_at = now(),
+    updated_at = now()
+WHERE id = $1 AND revoked_at IS NULL
+RETURNING id, name, created_at, updated_at, token_hash, issued_at, expires_at, user_id, organization_id, scopes, last_used_at, last_used_ip, usage_count, revoked_at, revoked_by, previous_token_hash, previous_token_expires_at
+`
+
+type RotateServiceAccessTokenParams struct {
+	ID                     int64
+	TokenHash              string
+	PreviousTokenExpiresAt pgtype.Timestamptz
+}
+
+func (q *Queries) RotateServiceAccessToken(ctx context.Context, arg RotateServiceAccessTokenParams) (ServiceAccessToken, error) {
+	row := q.db.QueryRow(ctx, rotateServiceAccessToken, arg.ID, arg.TokenHash, arg.PreviousTokenExpiresAt)
+	var i ServiceAccessToken
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TokenHash,
+		&i.IssuedAt,
+		&i.ExpiresAt,
+		&i.UserID,
+		&i.OrganizationID,
+		&i.Scopes,
+		&i.LastUsedAt,
+		&i.LastUsedIp,
+		&i.UsageCount,
+		&i.RevokedAt,
+		&i.RevokedBy,
+		&i.PreviousTokenHash,
+		&i.PreviousTokenExpiresAt,
+	)
+	return i, err
+}
+
+const searchTags = `-- name: SearchTags :many
+SELECT id, name, word_similarity($1::TEXT, name)::REAL AS similarity
+FROM tags
+WHERE $1::TEXT <% name
+ORDER BY similarity DESC, id
+OFFSET $2::INT LIMIT $3::INT
+`
+
+type SearchTagsParams struct {
+	Query     string
+	SqlOffset int32
+	SqlLimit  int32
+}
+
+type SearchTagsRow struct {
+	ID         int64
+	Name       pgtype.Text
+	Similarity float32
+}
+
+func (q *Queries) SearchTags(ctx context.Context, arg SearchTagsParams) ([]SearchTagsRow, error) {
+	rows, err := q.db.Query(ctx, searchTags, arg.Query, arg.SqlOffset, arg.SqlLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchTagsRow
+	for rows.Next() {
+		var i SearchTagsRow
+		if err := rows.Scan(&i.ID, &i.Name, &i.Similarity); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -1188,6 +2955,257 @@ func (q *Queries) ListUsedLanguageIDs(ctx context.Context) ([]pgtype.Int8, error
 	return items, nil
 }
 
+const setUserDisabledAt = `-- name: SetUserDisabledAt :execrows
+UPDATE users SET disabled_at = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1
+`
+
+type SetUserDisabledAtParams struct {
+	ID         int64
+	DisabledAt pgtype.Timestamptz
+}
+
+func (q *Queries) SetUserDisabledAt(ctx context.Context, arg SetUserDisabledAtParams) (int64, error) {
+	result, err := q.db.Exec(ctx, setUserDisabledAt, arg.ID, arg.DisabledAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const softDeleteSnippet = `-- name: SoftDeleteSnippet :exec
+UPDATE snippets SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1
+`
+
+func (q *Queries) SoftDeleteSnippet(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, softDeleteSnippet, id)
+	return err
+}
+
+const takeOverSnippet = `-- name: TakeOverSnippet :execrows
+UPDATE snippets SET user_id = $2, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND user_id IS NULL AND deleted_at IS NULL
+`
+
+type TakeOverSnippetParams struct {
+	ID     int64
+	UserID pgtype.Int8
+}
+
+func (q *Queries) TakeOverSnippet(ctx context.Context, arg TakeOverSnippetParams) (int64, error) {
+	result, err := q.db.Exec(ctx, takeOverSnippet, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const takeRateLimitToken = `-- name: TakeRateLimitToken :one
+INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+VALUES ($1, $2::FLOAT8 - 1, TRUE, now())
+ON CONFLICT (key) DO UPDATE SET
+    tokens = LEAST($2::FLOAT8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::FLOAT8 * $3::FLOAT8)
+        - CASE WHEN LEAST($2::FLOAT8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::FLOAT8 * $3::FLOAT8) >= 1 THEN 1 ELSE 0 END,
+    allowed = LEAST($2::FLOAT8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::FLOAT8 * $3::FLOAT8) >= 1,
+    updated_at = now()
+RETURNING tokens, allowed
+`
+
+type TakeRateLimitTokenParams struct {
+	Key   string
+	Burst float64
+	Rate  float64
+}
+
+type TakeRateLimitTokenRow struct {
+	Tokens  float64
+	Allowed bool
+}
+
+// refills the bucket for the time since the last request and takes a token
+// if one is available, in a single statement so instances do not race
+func (q *Queries) TakeRateLimitToken(ctx context.Context, arg TakeRateLimitTokenParams) (TakeRateLimitTokenRow, error) {
+	row := q.db.QueryRow(ctx, takeRateLimitToken, arg.Key, arg.Burst, arg.Rate)
+	var i TakeRateLimitTokenRow
+	err := row.Scan(&i.Tokens, &i.Allowed)
+	return i, err
+}
+
+const touchSession = `-- name: TouchSession :exec
+UPDATE sessions SET
+    last_used_at = CURRENT_TIMESTAMP,
+    user_agent = COALESCE($1::VARCHAR, user_agent),
+    ip_address = COALESCE($2::VARCHAR, ip_address)
+WHERE id = $3
+`
+
+type TouchSessionParams struct {
+	UserAgent pgtype.Text
+	IpAddress pgtype.Text
+	ID        int64
+}
+
+func (q *Queries) TouchSession(ctx context.Context, arg TouchSessionParams) error {
+	_, err := q.db.Exec(ctx, touchSession, arg.UserAgent, arg.IpAddress, arg.ID)
+	return err
+}
+
+const transferSnippets = `-- name: TransferSnippets :execrows
+UPDATE snippets s SET
+    user_id = $1::BIGINT,
+    organization_id = NULLIF(t.organization_id, 0),
+    visibility = t.visibility,
+    updated_at = CURRENT_TIMESTAMP
+FROM unnest(
+    $2::BIGINT[],
+    $3::BIGINT[],
+    $4::VARCHAR[]
+) AS t(id, organization_id, visibility)
+WHERE s.id = t.id
+`
+
+type TransferSnippetsParams struct {
+	ToUserID        int64
+	Ids             []int64
+	OrganizationIds []int64
+	Visibilities    []string
+}
+
+func (q *Queries) TransferSnippets(ctx context.Context, arg TransferSnippetsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, transferSnippets,
+		arg.ToUserID,
+		arg.Ids,
+		arg.OrganizationIds,
+		arg.Visibilities,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateOrganization = `-- name: UpdateOrganization :one
+UPDATE organizations SET
+    name = $1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $2
+RETURNING id, created_at, updated_at, name
+`
+
+type UpdateOrganizationParams struct {
+	Name string
+	ID   int64
+}
+
+func (q *Queries) UpdateOrganization(ctx context.Context, arg UpdateOrganizationParams) (Organization, error) {
+	row := q.db.QueryRow(ctx, updateOrganization, arg.Name, arg.ID)
+	var i Organization
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Name,
+	)
+	return i, err
+}
+
+const updateOrganizationMemberRole = `-- name: UpdateOrganizationMemberRole :execrows
+UPDATE organization_members SET role = $3
+WHERE organization_id = $1 AND user_id = $2
+`
+
+type UpdateOrganizationMemberRoleParams struct {
+	OrganizationID int64
+	UserID         int64
+	Role           string
+}
+
+func (q *Queries) UpdateOrganizationMemberRole(ctx context.Context, arg UpdateOrganizationMemberRoleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateOrganizationMemberRole, arg.OrganizationID, arg.UserID, arg.Role)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateSnippet = `-- name: UpdateSnippet :exec
+UPDATE snippets SET
+    title = COALESCE($1::VARCHAR, title),
+    code = COALESCE($2::TEXT, code),
+    visibility = COALESCE($3::VARCHAR, visibility),
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $4
+`
+
+type UpdateSnippetParams struct {
+	Title      pgtype.Text
+	Code       pgtype.Text
+	Visibility pgtype.Text
+	ID         int64
+}
+
+func (q *Queries) UpdateSnippet(ctx context.Context, arg UpdateSnippetParams) error {
+	_, err := q.db.Exec(ctx, updateSnippet,
+		arg.Title,
+		arg.Code,
+		arg.Visibility,
+		arg.ID,
+	)
+	return err
+}
+
+const updateUserIdentity = `-- name: UpdateUserIdentity :exec
+UPDATE user_identities
+SET username = $2, email = $3, updated_at = now()
+WHERE id = $1
+`
+
+type UpdateUserIdentityParams struct {
+	ID       int64
+	Username string
+	Email    string
+}
+
+func (q *Queries) UpdateUserIdentity(ctx context.Context, arg UpdateUserIdentityParams) error {
+	_, err := q.db.Exec(ctx, updateUserIdentity, arg.ID, arg.Username, arg.Email)
+	return err
+}
+
+const updateUserProfile = `-- name: UpdateUserProfile :exec
+UPDATE users SET
+    display_name = $2,
+    default_language_id = $3,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+`
+
+type UpdateUserProfileParams struct {
+	ID                int64
+	DisplayName       pgtype.Text
+	DefaultLanguageID pgtype.Int8
+}
+
+func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) error {
+	_, err := q.db.Exec(ctx, updateUserProfile, arg.ID, arg.DisplayName, arg.DefaultLanguageID)
+	return err
+}
+
+const updateUserRole = `-- name: UpdateUserRole :execrows
+UPDATE users SET role = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1
+`
+
+type UpdateUserRoleParams struct {
+	ID   int64
+	Role string
+}
+
+func (q *Queries) UpdateUserRole(ctx context.Context, arg UpdateUserRoleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateUserRole, arg.ID, arg.Role)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const upsertContributor = `-- name: UpsertContributor :exec
 INSERT INTO contributors (first_name, last_name, email) VALUES($1, $2, $3) ON CONFLICT (email) DO NOTHING
 `
@@ -1245,33 +3263,49 @@ func (q *Queries) UpsertLanguage(ctx context.Context, name pgtype.Text) (int64,
 
 const upsertSnippet = `-- name: UpsertSnippet :one
 
-INSERT INTO snippets (title, code, project_url,  git_file_path, git_version, language_id, git_repo_id, user_id, created_at)
-VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
-ON CONFLICT (git_repo_id, git_file_path) DO UPDATE SET
+INSERT INTO snippets (title, code, project_url,  git_file_path, git_version, language_id, git_repo_id, user_id, created_at, visibility, organization_id)
+VALUES(
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9,
+    COALESCE($10::VARCHAR, 'team'),
+    $11
+)
+ON CONFLICT (git_repo_id, git_file_path, organization_id) DO UPDATE SET
     code = EXCLUDED.code,
     project_url = EXCLUDED.project_url,
     git_repo_id = EXCLUDED.git_repo_id,
     git_file_path = EXCLUDED.git_file_path,
     git_version = EXCLUDED.git_version,
     language_id = EXCLUDED.language_id,
-    user_id = EXCLUDED.user_id,
-    created_at = EXCLUDED.created_at
+    created_at = EXCLUDED.created_at,
+    visibility = COALESCE($10::VARCHAR, snippets.visibility)
+WHERE snippets.user_id = EXCLUDED.user_id AND snippets.deleted_at IS NULL
 RETURNING id
 `
 
 type UpsertSnippetParams struct {
-	Title       pgtype.Text
-	Code        pgtype.Text
-	ProjectUrl  pgtype.Text
-	GitFilePath pgtype.Text
-	GitVersion  pgtype.Text
-	LanguageID  pgtype.Int8
-	GitRepoID   pgtype.Int8
-	UserID      pgtype.Int8
-	CreatedAt   pgtype.Timestamptz
+	Title          pgtype.Text
+	Code           pgtype.Text
+	ProjectUrl     pgtype.Text
+	GitFilePath    pgtype.Text
+	GitVersion     pgtype.Text
+	LanguageID     pgtype.Int8
+	GitRepoID      pgtype.Int8
+	UserID         pgtype.Int8
+	CreatedAt      pgtype.Timestamptz
+	Visibility     pgtype.Text
+	OrganizationID pgtype.Int8
 }
 
 // Snippets
+// only the owner overwrites a snippet, and not while it is in the trash
 func (q *Queries) UpsertSnippet(ctx context.Context, arg UpsertSnippetParams) (int64, error) {
 	row := q.db.QueryRow(ctx, upsertSnippet,
 		arg.Title,
@@ -1283,6 +3317,8 @@ func (q *Queries) UpsertSnippet(ctx context.Context, arg UpsertSnippetParams) (i
 		arg.GitRepoID,
 		arg.UserID,
 		arg.CreatedAt,
+		arg.Visibility,
+		arg.OrganizationID,
 	)
 	var id int64
 	err := row.Scan(&id)
@@ -1303,7 +3339,7 @@ const upsertUser = `-- name: UpsertUser :one
 WITH ins AS (
     INSERT INTO users (username, email, password_hash)
     VALUES ($1, $2, $3)
-    ON CONFLICT (email) DO NOTHING
+    ON CONFLICT DO NOTHING
     RETURNING id
 )
 SELECT id FROM ins
@@ -1318,6 +3354,7 @@ type UpsertUserParams struct {
 }
 
 // Users
+// no row when the username is taken by a user of another email
 func (q *Queries) UpsertUser(ctx context.Context, arg UpsertUserParams) (int64, error) {
 	row := q.db.QueryRow(ctx, upsertUser, arg.Username, arg.Email, arg.PasswordHash)
 	var id int64
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE snippets ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(code, '')), 'B')
+) STORED;
+
+CREATE INDEX idx_snippets_search ON snippets USING GIN (search_vector);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_snippets_search;
+ALTER TABLE snippets DROP COLUMN search_vector;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_snippets_title_trgm ON snippets USING GIN (title gin_trgm_ops);
+CREATE INDEX idx_tags_name_trgm ON tags USING GIN (name gin_trgm_ops);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_tags_name_trgm;
+DROP INDEX idx_snippets_title_trgm;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE snippet_revisions(
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    revision INT NOT NULL,
+    title VARCHAR(255),
+    code TEXT,
+    project_url VARCHAR(1024),
+    git_file_path VARCHAR(2048),
+    git_version VARCHAR(64),
+
+    snippet_id BIGINT NOT NULL REFERENCES snippets(id) ON DELETE CASCADE,
+    language_id BIGINT REFERENCES languages(id) ON DELETE SET NULL,
+    user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+
+    CONSTRAINT snippet_revisions_snippet_revision_unique UNIQUE (snippet_id, revision)
+);
+
+-- existing snippets start their history with the version we currently have
+INSERT INTO snippet_revisions (revision, title, code, project_url, git_file_path, git_version, snippet_id, language_id, user_id, created_at)
+SELECT 1, title, code, project_url, git_file_path, git_version, id, language_id, user_id, COALESCE(updated_at, created_at)
+FROM snippets;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE snippet_revisions;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- existing snippets keep being readable by every authenticated user
+ALTER TABLE snippets ADD COLUMN visibility VARCHAR(16) NOT NULL DEFAULT 'team'
+    CONSTRAINT snippets_visibility_check CHECK (visibility IN ('private', 'team', 'public'));
+
+CREATE INDEX idx_snippets_public ON snippets (id) WHERE visibility = 'public';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_snippets_public;
+ALTER TABLE snippets DROP COLUMN visibility;
+-- +goose StatementEnd
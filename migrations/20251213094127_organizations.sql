@@ -0,0 +1,39 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE organizations(
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE,
+
+    name VARCHAR(255) UNIQUE NOT NULL
+);
+
+CREATE TABLE organization_members(
+    organization_id BIGINT REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id BIGINT REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(16) NOT NULL DEFAULT 'member'
+        CHECK (role IN ('owner', 'admin', 'member')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(organization_id, user_id)
+);
+
+ALTER TABLE snippets
+    ADD COLUMN organization_id BIGINT REFERENCES organizations(id) ON DELETE SET NULL;
+
+ALTER TABLE service_access_tokens
+    ADD COLUMN organization_id BIGINT REFERENCES organizations(id) ON DELETE CASCADE;
+
+CREATE INDEX idx_organization_members_user ON organization_members (user_id, organization_id);
+CREATE INDEX idx_snippets_organization ON snippets (organization_id);
+CREATE INDEX idx_service_access_tokens_organization ON service_access_tokens (organization_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_service_access_tokens_organization;
+DROP INDEX idx_snippets_organization;
+ALTER TABLE service_access_tokens DROP COLUMN organization_id;
+ALTER TABLE snippets DROP COLUMN organization_id;
+DROP TABLE organization_members;
+DROP TABLE organizations;
+-- +goose StatementEnd
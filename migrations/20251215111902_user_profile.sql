@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN display_name VARCHAR(255),
+    ADD COLUMN default_language_id BIGINT REFERENCES languages(id) ON DELETE SET NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+    DROP COLUMN default_language_id,
+    DROP COLUMN display_name;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- a rotated refresh token is kept until its session ends so that a replay of
+-- it can be told apart from an unknown token
+ALTER TABLE refresh_tokens ADD COLUMN rotated_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_refresh_tokens_session ON refresh_tokens (session_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_refresh_tokens_session;
+ALTER TABLE refresh_tokens DROP COLUMN rotated_at;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- tokens issued before scopes existed keep the full power they were created with
+ALTER TABLE service_access_tokens ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{admin}';
+ALTER TABLE service_access_tokens ALTER COLUMN scopes DROP DEFAULT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE service_access_tokens DROP COLUMN scopes;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE service_access_tokens
+    ADD COLUMN last_used_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN last_used_ip VARCHAR(64),
+    ADD COLUMN usage_count BIGINT NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE service_access_tokens
+    DROP COLUMN last_used_at,
+    DROP COLUMN last_used_ip,
+    DROP COLUMN usage_count;
+-- +goose StatementEnd
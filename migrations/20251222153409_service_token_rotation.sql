@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the secret replaced by the latest rotation, accepted until its grace window ends
+ALTER TABLE service_access_tokens
+    ADD COLUMN previous_token_hash VARCHAR(512),
+    ADD COLUMN previous_token_expires_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_service_access_tokens_previous_hash ON service_access_tokens (previous_token_hash);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_service_access_tokens_previous_hash;
+ALTER TABLE service_access_tokens
+    DROP COLUMN previous_token_hash,
+    DROP COLUMN previous_token_expires_at;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+-- accounts at login providers, keyed on the provider's stable user ID so a
+-- changed email keeps signing in to the same user
+CREATE TABLE user_identities(
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE,
+
+    provider VARCHAR(64) NOT NULL,
+    provider_user_id VARCHAR(255) NOT NULL,
+    username VARCHAR(255) NOT NULL,
+    email VARCHAR(1024) NOT NULL,
+
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+
+    UNIQUE (provider, provider_user_id),
+    UNIQUE (user_id, provider)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE user_identities;
+-- +goose StatementEnd
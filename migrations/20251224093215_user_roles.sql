@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'member'
+        CHECK (role IN ('admin', 'maintainer', 'member', 'read_only')),
+    ADD COLUMN disabled_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_snippets_orphaned ON snippets (id) WHERE user_id IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_snippets_orphaned;
+ALTER TABLE users
+    DROP COLUMN disabled_at,
+    DROP COLUMN role;
+-- +goose StatementEnd
@@ -0,0 +1,41 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE audit_events
+    ADD COLUMN token_id BIGINT,   -- service access token the actor used
+    ADD COLUMN session_id BIGINT, -- login session the actor used
+    ADD COLUMN ip_address VARCHAR(64),
+    ADD COLUMN before_data JSONB,
+    ADD COLUMN after_data JSONB;
+
+CREATE INDEX idx_audit_events_actor ON audit_events (actor_id, id);
+CREATE INDEX idx_audit_events_target ON audit_events (target_type, target_id, id);
+CREATE INDEX idx_audit_events_action ON audit_events (action, id);
+CREATE INDEX idx_audit_events_token ON audit_events (token_id, id) WHERE token_id IS NOT NULL;
+
+-- the log is append-only, not even the API's own role may rewrite history
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER audit_events_append_only ON audit_events;
+DROP FUNCTION audit_events_append_only();
+DROP INDEX idx_audit_events_token;
+DROP INDEX idx_audit_events_action;
+DROP INDEX idx_audit_events_target;
+DROP INDEX idx_audit_events_actor;
+ALTER TABLE audit_events
+    DROP COLUMN after_data,
+    DROP COLUMN before_data,
+    DROP COLUMN ip_address,
+    DROP COLUMN session_id,
+    DROP COLUMN token_id;
+-- +goose StatementEnd
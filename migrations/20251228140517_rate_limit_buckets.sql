@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- token buckets of the rate limiter, shared by the API instances when the
+-- Postgres store is enabled
+CREATE UNLOGGED TABLE rate_limit_buckets(
+    key VARCHAR(255) PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    allowed BOOLEAN NOT NULL, -- whether the last request took a token
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_rate_limit_buckets_updated ON rate_limit_buckets (updated_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE rate_limit_buckets;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the same repo file is a separate snippet in every organization and outside
+-- of them, so ingests of one cannot overwrite the snippets of another
+ALTER TABLE snippets DROP CONSTRAINT snippets_repo_path_unique;
+ALTER TABLE snippets ADD CONSTRAINT snippets_repo_path_unique
+    UNIQUE NULLS NOT DISTINCT (git_repo_id, git_file_path, organization_id);
+-- such copies share their title, and a site-wide unique title would tell
+-- about snippets the user cannot see
+ALTER TABLE snippets DROP CONSTRAINT snippets_title_key;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE snippets ADD CONSTRAINT snippets_title_key UNIQUE (title);
+ALTER TABLE snippets DROP CONSTRAINT snippets_repo_path_unique;
+ALTER TABLE snippets ADD CONSTRAINT snippets_repo_path_unique UNIQUE (git_repo_id, git_file_path);
+-- +goose StatementEnd